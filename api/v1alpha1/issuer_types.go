@@ -23,22 +23,66 @@ import (
 // IssuerSpec defines the desired state of Issuer
 type IssuerSpec struct {
 	// URL is the base URL of your Horizon instance,
-	// for instance: "https://horizon.yourcompany.com".
-	URL string `json:"url"`
+	// for instance: "https://horizon.yourcompany.com". When empty, it is read
+	// from the "url" key of the issuer credentials instead.
+	// +optional
+	URL string `json:"url,omitempty"`
 
 	// The Horizon Profile that will be used to enroll certificates. Your
-	// authenticated principal should have rights over this Profile.
-	Profile string `json:"profile"`
+	// authenticated principal should have rights over this Profile. It may
+	// only be omitted when ProfileByKeyType covers every key enrolled.
+	// +optional
+	Profile string `json:"profile,omitempty"`
+
+	// ProfileByKeyType overrides Profile for the CSRs whose public key uses
+	// a given algorithm, such as RSA or ECDSA, so that a single issuer may
+	// route keys to the profile accepting them. Health checks only cover
+	// Profile.
+	// +optional
+	ProfileByKeyType map[KeyAlgorithm]string `json:"profileByKeyType,omitempty"`
 
 	// A reference to a Secret in the same namespace as the referent. If the
 	// referent is a ClusterIssuer, the reference instead refers to the resource
 	// with the given name in the configured 'cluster resource namespace', which
 	// is set as a flag on the controller component (and defaults to the
-	// namespace that the controller runs in).
-	AuthSecretName string `json:"authSecretName"`
+	// namespace that the controller runs in). Either AuthSecretName or
+	// AuthPath must be set.
+	// +optional
+	AuthSecretName string `json:"authSecretName,omitempty"`
+
+	// EnrollAuthSecretName references a Secret, resolved like AuthSecretName,
+	// holding the credentials used to submit, renew, revoke and cancel
+	// requests, and for health checks. It takes precedence over
+	// AuthSecretName and AuthPath for these operations.
+	// +optional
+	EnrollAuthSecretName string `json:"enrollAuthSecretName,omitempty"`
+
+	// ReadAuthSecretName references a Secret, resolved like AuthSecretName,
+	// holding the credentials used to poll and look up requests, so that
+	// they need not be as privileged as those used to enroll. It takes
+	// precedence over AuthSecretName and AuthPath for these operations.
+	// +optional
+	ReadAuthSecretName string `json:"readAuthSecretName,omitempty"`
+
+	// AuthPath is the path of a directory holding credentials as one file per
+	// key, such as a volume mounted by a CSI secret driver. It is relative to
+	// the credentials directory of the controller, and is only honored on
+	// ClusterIssuers. It takes precedence over AuthSecretName.
+	// +optional
+	AuthPath *string `json:"authPath,omitempty"`
+
+	// AuthType is how the issuer authenticates against Horizon. With
+	// "password", the username and password keys of the credentials are
+	// used. With "serviceAccountToken", the token key of the credentials,
+	// typically a projected service account token mounted under AuthPath, is
+	// sent as a bearer token, and read again as it is refreshed.
+	// +optional
+	// +kubebuilder:default:=password
+	AuthType AuthType `json:"authType,omitempty"`
 
 	// CaBundle contains the CA bundle required to
-	// trust the Horizon endpoint certificate
+	// trust the Horizon endpoint certificate. It takes precedence over the
+	// horizonCaBundle key of the issuer credentials.
 	// +optional
 	CaBundle *string `json:"caBundle,omitempty"`
 
@@ -48,16 +92,155 @@ type IssuerSpec struct {
 	// +kubebuilder:default:=false
 	SkipTLSVerify bool `json:"skipTLSVerify"`
 
+	// TLSServerName is the hostname sent as SNI and expected in the
+	// certificate of the Horizon endpoint, when it differs from the host of
+	// the URL, for instance when Horizon is reached through an IP address.
+	// +optional
+	TLSServerName string `json:"tlsServerName,omitempty"`
+
+	// AdditionalHeaders are HTTP headers sent with every request made to
+	// Horizon, for instance to authenticate against an API gateway.
+	// +optional
+	AdditionalHeaders map[string]string `json:"additionalHeaders,omitempty"`
+
+	// AdditionalSecretHeaders are HTTP headers sent with every request made to
+	// Horizon, mapped to the key of the authentication Secret holding their value.
+	// +optional
+	AdditionalSecretHeaders map[string]string `json:"additionalSecretHeaders,omitempty"`
+
+	// ReissueRevoked makes the issuer check hourly whether the certificates
+	// it issued were revoked on Horizon. The CertificateRequest of a revoked
+	// certificate is then marked as Revoked and its Certificate re-issued.
+	// +optional
+	ReissueRevoked bool `json:"reissueRevoked,omitempty"`
+
+	// MetadataConfigMap mirrors the Horizon request ID, status, profile,
+	// submission and completion times of each CertificateRequest into a
+	// ConfigMap of the same name, owned by the CertificateRequest, for
+	// tools that can't read its annotations and status.
+	// +optional
+	MetadataConfigMap bool `json:"metadataConfigMap,omitempty"`
+
+	// RenewalMode controls how certificates are renewed on Horizon. With
+	// "rekey", every renewal is a new enrollment. With "renew", renewals of
+	// a certificate stored in the secret of the Certificate are submitted as
+	// renewals of that certificate. With "auto", they are only submitted as
+	// renewals when the CSR reuses the key of that certificate, and as new
+	// enrollments when the key was rotated.
+	// +optional
+	// +kubebuilder:default:=rekey
+	RenewalMode RenewalMode `json:"renewalMode,omitempty"`
+
+	// MetadataAnnotations stamps the CertificateRequest of issued
+	// certificates with the Horizon certificate ID, the profile and the
+	// issuance time, in the horizon.evertrust.io/certificate-id, profile and
+	// issued-at annotations.
+	// +optional
+	MetadataAnnotations bool `json:"metadataAnnotations,omitempty"`
+
+	// ThumbprintAlgorithm is the hash algorithm of the thumbprint of issued
+	// certificates, stored in the horizon.evertrust.io/thumbprint-<algorithm>
+	// annotation of their CertificateRequest for systems pinning them.
+	// +optional
+	// +kubebuilder:default:=sha256
+	ThumbprintAlgorithm ThumbprintAlgorithm `json:"thumbprintAlgorithm,omitempty"`
+
+	// OnAbandon controls what happens to the Horizon request of a
+	// CertificateRequest deleted before its certificate is issued. With
+	// "leave", the request is left as is on Horizon. With "cancel", it is
+	// canceled before the CertificateRequest is deleted.
+	// +optional
+	// +kubebuilder:default:=leave
+	OnAbandon AbandonPolicy `json:"onAbandon,omitempty"`
+
+	// Suspend stops the issuer from submitting new certificate requests to
+	// Horizon, for instance during a Horizon maintenance. Requests already
+	// submitted are still completed. The issuer is not ready while
+	// suspended.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// HealthCheckPolicy controls whether new certificate requests are
+	// submitted to Horizon while the health check of the issuer fails. With
+	// "failClosed", they wait for the issuer to be ready again. With
+	// "failOpen", they are still submitted, the issuer remaining not ready
+	// with the FailingOpen reason. Errors such as missing credentials always
+	// block submissions.
+	// +optional
+	// +kubebuilder:default:=failClosed
+	HealthCheckPolicy HealthCheckPolicy `json:"healthCheckPolicy,omitempty"`
+
+	// RequireApproval makes the issuer wait for CertificateRequests to be
+	// approved in Kubernetes before submitting them to Horizon. By default,
+	// requests are submitted as soon as they are created, approval possibly
+	// happening on Horizon.
+	// +optional
+	RequireApproval bool `json:"requireApproval,omitempty"`
+
 	// RevokeCertificates controls whether this issuer should revoke certificates
 	// that have been issued through it when their Kubernetes object is deleted.
 	// +kubebuilder:default:=false
 	// +optional
 	RevokeCertificates bool `json:"revokeCertificates"`
 
+	// StaticLabels is a map of labels set on every certificate enrolled
+	// through this issuer. Labels set at the Certificate or Ingress levels
+	// override them.
+	// +optional
+	StaticLabels map[string]string `json:"staticLabels,omitempty"`
+
+	// LabelAnnotationAllowlist restricts the labels that may be set through
+	// horizon.evertrust.io/label-<name> annotations on Certificates and
+	// Ingresses to those whose name matches one of its patterns, which may
+	// hold * wildcards. All names are allowed when empty.
+	// +optional
+	LabelAnnotationAllowlist []string `json:"labelAnnotationAllowlist,omitempty"`
+
+	// LabelAnnotationDenylist prevents the labels whose name matches one of
+	// its patterns from being set through annotations. It takes precedence
+	// over LabelAnnotationAllowlist.
+	// +optional
+	LabelAnnotationDenylist []string `json:"labelAnnotationDenylist,omitempty"`
+
+	// DefaultLabelsConfigMapName is the name of a ConfigMap holding default
+	// labels set on every certificate enrolled through this issuer, one per
+	// key. It is read from the same namespace as AuthSecretName, and its
+	// labels are overridden by all others, including StaticLabels.
+	// +optional
+	DefaultLabelsConfigMapName string `json:"defaultLabelsConfigMapName,omitempty"`
+
 	// Labels is a map of labels that will override labels
 	// set at the Certificate or Ingress levels.
 	Labels map[string]string `json:"labels,omitempty"`
 
+	// MultiValuedLabels is a map of labels holding several values, for labels
+	// that are repeatable on Horizon. They override labels of the same name
+	// set at the Certificate or Ingress levels or in Labels.
+	// +optional
+	MultiValuedLabels map[string][]string `json:"multiValuedLabels,omitempty"`
+
+	// CorrelationIdLabel is the name of the Horizon label receiving the
+	// correlation ID generated for each CertificateRequest, which is also
+	// stored in its horizon.evertrust.io/correlation-id annotation. The
+	// correlation ID is not sent to Horizon when unset.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_-]+$`
+	// +optional
+	CorrelationIdLabel string `json:"correlationIdLabel,omitempty"`
+
+	// Category is the certificate category sent with every request, for
+	// Horizon tenants requiring one. It can be overridden on a Certificate
+	// through the horizon.evertrust.io/category annotation, and takes
+	// precedence over a label of the same name set by other means. It is
+	// checked against the values of the category label when Horizon defines
+	// some.
+	// +optional
+	Category string `json:"category,omitempty"`
+
+	// CategoryLabel is the name of the Horizon label receiving the category.
+	// +optional
+	// +kubebuilder:default:=category
+	CategoryLabel string `json:"categoryLabel,omitempty"`
+
 	// Owner will override the owner value set
 	// at the Certificate or Ingress levels.
 	Owner *string `json:"owner,omitempty"`
@@ -65,6 +248,287 @@ type IssuerSpec struct {
 	// Team will override the team value set
 	// at the Certificate or Ingress levels.
 	Team *string `json:"team,omitempty"`
+
+	// ValidateOwner makes the issuer check that the owner of each
+	// certificate is a known identity of the Horizon directory before
+	// submitting its request, failing the CertificateRequest otherwise.
+	// +optional
+	ValidateOwner bool `json:"validateOwner,omitempty"`
+
+	// NamespaceLabels resolves the owner, team and labels of certificates
+	// from the labels of their namespace, so that certificates are
+	// attributed without annotating each of them. Values set at the
+	// Certificate or Ingress levels take precedence.
+	// +optional
+	NamespaceLabels *NamespaceLabelMapping `json:"namespaceLabels,omitempty"`
+
+	// Module is the Horizon module certificates are requested on, such as
+	// webra or est, so that they are managed alongside the certificates of
+	// that module. Defaults to webra.
+	// +optional
+	Module string `json:"module,omitempty"`
+
+	// VirtualCa selects the virtual CA used to issue certificates, on profiles
+	// exposing several of them. It can be overridden on a Certificate through
+	// the horizon.evertrust.io/virtual-ca annotation.
+	// +optional
+	VirtualCa *string `json:"virtualCa,omitempty"`
+
+	// TemplateParameters are passed verbatim to Horizon along with enroll
+	// requests, for profiles accepting parameters that influence issuance.
+	// A parameter can be overridden on a Certificate through the
+	// horizon.evertrust.io/template-parameter-<key> annotation. Keys are
+	// checked against those accepted by the profile when Horizon reports them.
+	// +optional
+	TemplateParameters map[string]string `json:"templateParameters,omitempty"`
+
+	// Description is shown to the approvers of the requests on Horizon. The
+	// {namespace}, {name} and {certificate} placeholders are replaced with
+	// the namespace and name of the CertificateRequest and the name of its
+	// Certificate. It can be overridden on a Certificate through the
+	// horizon.evertrust.io/description annotation, and is truncated to 255
+	// characters.
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Subject holds subject DN components that are sent to Horizon along
+	// with the CSR, for profiles that build the subject from request parameters.
+	// +optional
+	Subject *Subject `json:"subject,omitempty"`
+
+	// OverrideSubject controls how Subject is merged with the subject of the CSR.
+	// With "Never", only components whose type is missing from the CSR are added.
+	// With "Always", components replace those of the same type in the CSR.
+	// +optional
+	// +kubebuilder:default:=Never
+	OverrideSubject SubjectOverridePolicy `json:"overrideSubject,omitempty"`
+
+	// CommonNameFromSan sets the common name of CSRs that have none to their
+	// first DNS SAN, for profiles requiring a common name. The common name
+	// may also be set on a Certificate through the
+	// horizon.evertrust.io/common-name annotation, which takes precedence.
+	// +optional
+	CommonNameFromSan bool `json:"cnFromSan,omitempty"`
+
+	// UpnSanType is the Horizon SAN type receiving the UPNs found in the
+	// otherName SANs of CSRs, as the profile expects them. Defaults to
+	// OTHERNAME_UPN.
+	// +optional
+	UpnSanType string `json:"upnSanType,omitempty"`
+
+	// AllowedKeyTypes restricts the keys that may be enrolled through this
+	// issuer, for instance to match the policy of the Horizon profile. CSRs
+	// with other keys are rejected before being submitted. All keys are
+	// allowed when empty.
+	// +optional
+	AllowedKeyTypes []AllowedKeyType `json:"allowedKeyTypes,omitempty"`
+
+	// IncludeChain stores the certificate along with its chain, without the
+	// root CA, in the issued certificate, which cert-manager writes to the
+	// tls.crt key of the secret. Only the leaf certificate is stored when
+	// unset. The top-most certificate of the chain is stored as the CA,
+	// written to ca.crt, either way. It can be overridden on a Certificate
+	// through the horizon.evertrust.io/include-chain annotation.
+	// +optional
+	IncludeChain bool `json:"includeChain,omitempty"`
+
+	// AllowedDomains restricts the DNS names that may be requested through
+	// this issuer, independently of the Horizon policy. A domain such as
+	// "*.example.com" allows any name under example.com, other domains
+	// allowing only that exact name. The common name of the CSR is checked
+	// too when it is a DNS name, and IP or URI SANs are not allowed.
+	// Requests for other names are denied before being submitted. All names
+	// are allowed when empty.
+	// +optional
+	AllowedDomains []string `json:"allowedDomains,omitempty"`
+
+	// AllowedUsages restricts the usages that may be requested through this
+	// issuer, using cert-manager usage names such as "digital signature" or
+	// "server auth", for instance to match the policy of the Horizon
+	// profile. Requests with other usages are rejected before being
+	// submitted. All usages are allowed when empty.
+	// +optional
+	AllowedUsages []string `json:"allowedUsages,omitempty"`
+
+	// AllowCA controls whether CA certificates may be requested through
+	// this issuer. Requests with isCA set are rejected before being
+	// submitted when false. They are allowed when unset.
+	// +optional
+	AllowCA *bool `json:"allowCA,omitempty"`
+
+	// MaxConcurrentRequests bounds the number of enroll and polling calls
+	// made to Horizon at the same time for this issuer. Reconciles over the
+	// limit are retried shortly after. Calls are not bounded when unset.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	MaxConcurrentRequests int `json:"maxConcurrentRequests,omitempty"`
+
+	// AllowedNamespaces restricts the namespaces from which CertificateRequests
+	// may use this issuer. All namespaces are allowed when unset. This is only
+	// honored on ClusterIssuers.
+	// +optional
+	AllowedNamespaces *AllowedNamespaces `json:"allowedNamespaces,omitempty"`
+}
+
+// AllowedNamespaces selects namespaces by name or by label. A namespace is
+// allowed when it matches either of them.
+type AllowedNamespaces struct {
+	// Names is a list of allowed namespace names.
+	// +optional
+	Names []string `json:"names,omitempty"`
+
+	// Selector selects allowed namespaces by their labels.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// Subject holds subject DN components. Each of them can be overridden on
+// a Certificate through the horizon.evertrust.io/subject-* annotations.
+type Subject struct {
+	// Organizations (O) of the subject.
+	// +optional
+	Organizations []string `json:"organizations,omitempty"`
+
+	// OrganizationalUnits (OU) of the subject.
+	// +optional
+	OrganizationalUnits []string `json:"organizationalUnits,omitempty"`
+
+	// Countries (C) of the subject, as two-letter ISO 3166 codes.
+	// +optional
+	Countries []string `json:"countries,omitempty"`
+
+	// Localities (L) of the subject.
+	// +optional
+	Localities []string `json:"localities,omitempty"`
+
+	// Provinces (ST) of the subject.
+	// +optional
+	Provinces []string `json:"provinces,omitempty"`
+}
+
+// SubjectOverridePolicy is the policy used to merge subject components
+// configured on an issuer with the subject of the CSR.
+// +kubebuilder:validation:Enum=Never;Always
+type SubjectOverridePolicy string
+
+const (
+	// SubjectOverrideNever only adds components whose type is missing from the CSR.
+	SubjectOverrideNever SubjectOverridePolicy = "Never"
+
+	// SubjectOverrideAlways replaces components of the CSR with configured ones.
+	SubjectOverrideAlways SubjectOverridePolicy = "Always"
+)
+
+// AuthType is the way an issuer authenticates against Horizon.
+// +kubebuilder:validation:Enum=password;serviceAccountToken
+type AuthType string
+
+const (
+	// AuthTypePassword authenticates with a username and a password.
+	AuthTypePassword AuthType = "password"
+
+	// AuthTypeServiceAccountToken authenticates with a federated bearer token.
+	AuthTypeServiceAccountToken AuthType = "serviceAccountToken"
+)
+
+// AbandonPolicy is the policy applied to the Horizon requests of deleted
+// CertificateRequests that were not issued.
+// +kubebuilder:validation:Enum=leave;cancel
+type AbandonPolicy string
+
+const (
+	// AbandonPolicyLeave leaves the request on Horizon.
+	AbandonPolicyLeave AbandonPolicy = "leave"
+
+	// AbandonPolicyCancel cancels the request on Horizon.
+	AbandonPolicyCancel AbandonPolicy = "cancel"
+)
+
+// HealthCheckPolicy is whether health check failures of an issuer block the
+// submission of new certificate requests.
+// +kubebuilder:validation:Enum=failClosed;failOpen
+type HealthCheckPolicy string
+
+const (
+	// HealthCheckPolicyFailClosed blocks submissions while the health check
+	// fails.
+	HealthCheckPolicyFailClosed HealthCheckPolicy = "failClosed"
+
+	// HealthCheckPolicyFailOpen keeps submitting while the health check
+	// fails.
+	HealthCheckPolicyFailOpen HealthCheckPolicy = "failOpen"
+)
+
+// RenewalMode is how certificates are renewed on Horizon.
+// +kubebuilder:validation:Enum=auto;renew;rekey
+type RenewalMode string
+
+const (
+	// RenewalModeAuto renews certificates whose key is reused, and enrolls
+	// new ones otherwise.
+	RenewalModeAuto RenewalMode = "auto"
+
+	// RenewalModeRenew always renews the previous certificate.
+	RenewalModeRenew RenewalMode = "renew"
+
+	// RenewalModeRekey always enrolls a new certificate.
+	RenewalModeRekey RenewalMode = "rekey"
+)
+
+// ThumbprintAlgorithm is a hash algorithm used to compute the thumbprint of
+// certificates, from their DER encoding.
+// +kubebuilder:validation:Enum=sha1;sha256
+type ThumbprintAlgorithm string
+
+const (
+	ThumbprintAlgorithmSHA1   ThumbprintAlgorithm = "sha1"
+	ThumbprintAlgorithmSHA256 ThumbprintAlgorithm = "sha256"
+)
+
+// AllowedKeyType describes keys of an algorithm that may be enrolled.
+type AllowedKeyType struct {
+	// Algorithm of the key.
+	Algorithm KeyAlgorithm `json:"algorithm"`
+
+	// MinSize is the minimum size of RSA keys, in bits.
+	// +optional
+	MinSize int `json:"minSize,omitempty"`
+
+	// Curves are the allowed ECDSA curves. All curves are allowed when empty.
+	// +optional
+	Curves []ECDSACurve `json:"curves,omitempty"`
+}
+
+// KeyAlgorithm is the algorithm of a key.
+// +kubebuilder:validation:Enum=RSA;ECDSA;Ed25519
+type KeyAlgorithm string
+
+const (
+	RSAKeyAlgorithm     KeyAlgorithm = "RSA"
+	ECDSAKeyAlgorithm   KeyAlgorithm = "ECDSA"
+	Ed25519KeyAlgorithm KeyAlgorithm = "Ed25519"
+)
+
+// ECDSACurve is the name of an ECDSA curve.
+// +kubebuilder:validation:Enum=P-256;P-384;P-521
+type ECDSACurve string
+
+// NamespaceLabelMapping maps the labels of a namespace to the metadata of
+// the certificates requested from it. Labels missing from a namespace are
+// ignored.
+type NamespaceLabelMapping struct {
+	// Owner is the name of the namespace label holding the owner.
+	// +optional
+	Owner string `json:"owner,omitempty"`
+
+	// Team is the name of the namespace label holding the team.
+	// +optional
+	Team string `json:"team,omitempty"`
+
+	// Labels maps the names of namespace labels to the names of the
+	// Horizon labels receiving their values.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // IssuerStatus defines the observed state of Issuer
@@ -73,6 +537,12 @@ type IssuerStatus struct {
 	// Known condition types are `Ready`.
 	// +optional
 	Conditions []IssuerCondition `json:"conditions,omitempty"`
+
+	// Profiles lists the Horizon profiles on which the credentials of the
+	// issuer may enroll certificates, as of the last successful health
+	// check. Only the first profiles are listed, by name.
+	// +optional
+	Profiles []string `json:"profiles,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -83,6 +553,8 @@ type IssuerStatus struct {
 // +kubebuilder:printcolumn:name="Horizon URL",type=string,JSONPath=`.spec.url`
 // +kubebuilder:printcolumn:name="Secret",type=string,JSONPath=`.spec.authSecretName`
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=='Ready')].status`
+// +kubebuilder:printcolumn:name="Generation",type=integer,JSONPath=`.metadata.generation`,priority=1
+// +kubebuilder:printcolumn:name="Observed Generation",type=integer,JSONPath=`.status.conditions[?(@.type=='Ready')].observedGeneration`,priority=1
 
 type Issuer struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -123,6 +595,12 @@ type IssuerCondition struct {
 	// transition, complementing reason.
 	// +optional
 	Message string `json:"message,omitempty"`
+
+	// ObservedGeneration is the generation of the issuer this condition was
+	// set from. The condition may be stale when it is lower than the current
+	// generation of the issuer.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 }
 
 // IssuerConditionType represents an Issuer condition value.
@@ -22,9 +22,55 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AllowedKeyType) DeepCopyInto(out *AllowedKeyType) {
+	*out = *in
+	if in.Curves != nil {
+		in, out := &in.Curves, &out.Curves
+		*out = make([]ECDSACurve, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AllowedKeyType.
+func (in *AllowedKeyType) DeepCopy() *AllowedKeyType {
+	if in == nil {
+		return nil
+	}
+	out := new(AllowedKeyType)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AllowedNamespaces) DeepCopyInto(out *AllowedNamespaces) {
+	*out = *in
+	if in.Names != nil {
+		in, out := &in.Names, &out.Names
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AllowedNamespaces.
+func (in *AllowedNamespaces) DeepCopy() *AllowedNamespaces {
+	if in == nil {
+		return nil
+	}
+	out := new(AllowedNamespaces)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterIssuer) DeepCopyInto(out *ClusterIssuer) {
 	*out = *in
@@ -165,11 +211,54 @@ func (in *IssuerList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IssuerSpec) DeepCopyInto(out *IssuerSpec) {
 	*out = *in
+	if in.ProfileByKeyType != nil {
+		in, out := &in.ProfileByKeyType, &out.ProfileByKeyType
+		*out = make(map[KeyAlgorithm]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.AuthPath != nil {
+		in, out := &in.AuthPath, &out.AuthPath
+		*out = new(string)
+		**out = **in
+	}
 	if in.CaBundle != nil {
 		in, out := &in.CaBundle, &out.CaBundle
 		*out = new(string)
 		**out = **in
 	}
+	if in.AdditionalHeaders != nil {
+		in, out := &in.AdditionalHeaders, &out.AdditionalHeaders
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.AdditionalSecretHeaders != nil {
+		in, out := &in.AdditionalSecretHeaders, &out.AdditionalSecretHeaders
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.StaticLabels != nil {
+		in, out := &in.StaticLabels, &out.StaticLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.LabelAnnotationAllowlist != nil {
+		in, out := &in.LabelAnnotationAllowlist, &out.LabelAnnotationAllowlist
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LabelAnnotationDenylist != nil {
+		in, out := &in.LabelAnnotationDenylist, &out.LabelAnnotationDenylist
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.Labels != nil {
 		in, out := &in.Labels, &out.Labels
 		*out = make(map[string]string, len(*in))
@@ -177,6 +266,21 @@ func (in *IssuerSpec) DeepCopyInto(out *IssuerSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.MultiValuedLabels != nil {
+		in, out := &in.MultiValuedLabels, &out.MultiValuedLabels
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
 	if in.Owner != nil {
 		in, out := &in.Owner, &out.Owner
 		*out = new(string)
@@ -187,6 +291,55 @@ func (in *IssuerSpec) DeepCopyInto(out *IssuerSpec) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.NamespaceLabels != nil {
+		in, out := &in.NamespaceLabels, &out.NamespaceLabels
+		*out = new(NamespaceLabelMapping)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.VirtualCa != nil {
+		in, out := &in.VirtualCa, &out.VirtualCa
+		*out = new(string)
+		**out = **in
+	}
+	if in.TemplateParameters != nil {
+		in, out := &in.TemplateParameters, &out.TemplateParameters
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Subject != nil {
+		in, out := &in.Subject, &out.Subject
+		*out = new(Subject)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedKeyTypes != nil {
+		in, out := &in.AllowedKeyTypes, &out.AllowedKeyTypes
+		*out = make([]AllowedKeyType, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AllowedDomains != nil {
+		in, out := &in.AllowedDomains, &out.AllowedDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedUsages != nil {
+		in, out := &in.AllowedUsages, &out.AllowedUsages
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowCA != nil {
+		in, out := &in.AllowCA, &out.AllowCA
+		*out = new(bool)
+		**out = **in
+	}
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = new(AllowedNamespaces)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssuerSpec.
@@ -209,6 +362,11 @@ func (in *IssuerStatus) DeepCopyInto(out *IssuerStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Profiles != nil {
+		in, out := &in.Profiles, &out.Profiles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssuerStatus.
@@ -220,3 +378,65 @@ func (in *IssuerStatus) DeepCopy() *IssuerStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespaceLabelMapping) DeepCopyInto(out *NamespaceLabelMapping) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespaceLabelMapping.
+func (in *NamespaceLabelMapping) DeepCopy() *NamespaceLabelMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespaceLabelMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Subject) DeepCopyInto(out *Subject) {
+	*out = *in
+	if in.Organizations != nil {
+		in, out := &in.Organizations, &out.Organizations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.OrganizationalUnits != nil {
+		in, out := &in.OrganizationalUnits, &out.OrganizationalUnits
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Countries != nil {
+		in, out := &in.Countries, &out.Countries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Localities != nil {
+		in, out := &in.Localities, &out.Localities
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Provinces != nil {
+		in, out := &in.Provinces, &out.Provinces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Subject.
+func (in *Subject) DeepCopy() *Subject {
+	if in == nil {
+		return nil
+	}
+	out := new(Subject)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -5,8 +5,11 @@ go 1.16
 require (
 	github.com/evertrust/horizon-go v0.0.3
 	github.com/jetstack/cert-manager v1.6.1
+	github.com/prometheus/client_golang v1.11.0
+	go.uber.org/zap v1.19.0
 	k8s.io/api v0.22.2
 	k8s.io/apimachinery v0.22.2
 	k8s.io/client-go v0.22.2
+	k8s.io/utils v0.0.0-20210819203725-bdf08cb9a70a
 	sigs.k8s.io/controller-runtime v0.10.1
 )
@@ -20,36 +20,65 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/evertrust/horizon-go/http"
-	"github.com/evertrust/horizon-go/requests"
+	"github.com/evertrust/horizon-go/rfc5280"
 	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
 	horizonissuer "github.com/evertrust/horizon-issuer/internal/issuer/horizon"
 	issuerutil "github.com/evertrust/horizon-issuer/internal/issuer/util"
 	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
 	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
 	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	"github.com/prometheus/client_golang/prometheus"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/clock"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 var (
 	errIssuerRef      = errors.New("error interpreting issuerRef")
 	errGetIssuer      = errors.New("error getting issuer")
 	errIssuerNotReady = errors.New("issuer is not ready")
+	errNamespaceCheck = errors.New("error checking whether the namespace may use the issuer")
+	errOwnerLookup    = errors.New("error looking up the owner in the Horizon directory")
 )
 
 const FinalizerName = horizonissuer.IssuerNamespace + "/finalizer"
 
+// suspendedRequeueAfter is the delay after which requests held by a
+// suspended issuer are checked again
+const suspendedRequeueAfter = time.Minute
+
+// revocationCheckInterval is the delay between two checks of whether an
+// issued certificate was revoked on Horizon
+const revocationCheckInterval = time.Hour
+
+// ReasonRevoked is the reason of the Ready condition of CertificateRequests
+// whose certificate was revoked on Horizon
+const ReasonRevoked = "Revoked"
+
+// ReasonInvalidRequest is the reason of the InvalidRequest condition of
+// CertificateRequests that do not hold a valid CSR
+const ReasonInvalidRequest = "InvalidRequest"
+
 // CertificateRequestReconciler reconciles a CertificateRequest object
 type CertificateRequestReconciler struct {
 	client.Client
@@ -57,6 +86,24 @@ type CertificateRequestReconciler struct {
 	ClusterResourceNamespace string
 	Clock                    clock.Clock
 	Issuer                   horizonissuer.HorizonIssuer
+	Clients                  *horizonissuer.ClientCache
+	// CredentialsDir is the directory in which ClusterIssuers may read
+	// credentials from files. File credentials are disabled when empty.
+	CredentialsDir string
+	// APIReader reads objects from the API server rather than the cache,
+	// to check whether a request was submitted before submitting it.
+	APIReader client.Reader
+	// VerifyDelivery reports issued certificates once cert-manager wrote
+	// them to the Secret of their Certificate, rather than as soon as they
+	// are stored on the CertificateRequest.
+	VerifyDelivery bool
+	// Priority is the class of requests processed first when many are
+	// queued at once, such as at startup.
+	Priority RequestPriority
+
+	// pending holds the gauge labels of the requests counted as pending
+	pendingMu sync.Mutex
+	pending   map[types.NamespacedName]prometheus.Labels
 }
 
 func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
@@ -69,6 +116,7 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 			return ctrl.Result{}, fmt.Errorf("unexpected get error: %v", err)
 		}
 		log.Info("Not found. Ignoring.")
+		r.trackPending(req.NamespacedName, nil)
 		return ctrl.Result{}, nil
 	}
 
@@ -78,6 +126,17 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return ctrl.Result{}, nil
 	}
 
+	// Other kinds of our group, such as lists, are not issuers either, and
+	// are ignored rather than failed as the request may not be meant for us
+	if _, _, ok := resolveIssuerRef(&certificateRequest); !ok {
+		log.Info("Foreign kind. Ignoring.", "kind", certificateRequest.Spec.IssuerRef.Kind)
+		return ctrl.Result{}, nil
+	}
+
+	// Registered first so that it sees the request as left by the updates
+	// deferred below
+	defer r.trackPending(req.NamespacedName, &certificateRequest)
+
 	// We now have a CertificateRequest that belongs to us so we are responsible
 	// for updating its Ready condition.
 	setReadyCondition := func(status cmmeta.ConditionStatus, reason, message string) {
@@ -93,16 +152,21 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 	issuer, err := r.issuerFromRequest(ctx, &certificateRequest)
 	if err != nil {
 		log.Error(err, "Cannot find Issuer")
+		if horizonissuer.IsPermanent(err) {
+			return ctrl.Result{}, nil
+		}
 		return ctrl.Result{}, fmt.Errorf("%w", err)
 	}
 
 	var secretNamespace string
+	var credentialsDir string
 	switch issuer.(type) {
 	case *horizonapi.Issuer:
 		secretNamespace = certificateRequest.Namespace
 		log = log.WithValues("issuer", issuer.GetName())
 	case *horizonapi.ClusterIssuer:
 		secretNamespace = r.ClusterResourceNamespace
+		credentialsDir = r.CredentialsDir
 		log = log.WithValues("clusterissuer", issuer.GetName())
 	default:
 		return ctrl.Result{}, nil
@@ -115,48 +179,76 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return ctrl.Result{}, nil
 	}
 
-	if !issuerutil.IsReady(issuerStatus) {
+	// Issuers may enroll keys of some algorithms on other profiles. The
+	// profile of the request replaces the one of the issuer from here, so
+	// that submissions are tracked and polled on the right profile. Failures
+	// are only reported before submitting, so that an invalid CSR is
+	// reported as such.
+	profile, profileErr := horizonissuer.ProfileForKeyType(issuerSpec, certificateRequest.Spec.Request)
+	if profileErr == nil && profile != issuerSpec.Profile {
+		requestSpec := *issuerSpec
+		requestSpec.Profile = profile
+		issuerSpec = &requestSpec
+	}
+
+	// Suspended issuers are not ready, but still complete the requests that
+	// were already submitted to Horizon, and issuers failing open keep
+	// submitting new ones. Waiting requests are reconciled as soon as their
+	// issuer becomes ready, the requeue only bounding the delay should that
+	// event be missed.
+	if failingOpen(issuerSpec, issuerStatus) {
+		log.Info("Issuer health check is failing, submitting anyway as its healthCheckPolicy is failOpen")
+	} else if !issuerutil.IsReady(issuerStatus) && !issuerSpec.Suspend {
+		if requeueAfter := r.Issuer.NotReadyRequeueAfter(&certificateRequest); requeueAfter > 0 {
+			log.Info("Issuer is not ready. Retrying later.", "requeueAfter", requeueAfter)
+			return ctrl.Result{RequeueAfter: requeueAfter}, nil
+		}
 		return ctrl.Result{}, errIssuerNotReady
 	}
 
-	secretName := types.NamespacedName{
-		Name:      issuerSpec.AuthSecretName,
-		Namespace: secretNamespace,
-	}
-
-	var secret corev1.Secret
-
-	err = r.Get(ctx, secretName, &secret)
+	credentials, err := horizonissuer.CredentialSourceFromIssuer(r.Client, issuerSpec, secretNamespace, credentialsDir, horizonissuer.CredentialsEnroll)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("%w, secret name: %s, reason: %v", errGetAuthSecret, secretName, err)
+		return ctrl.Result{}, horizonissuer.WrapError(errGetCredentials, err)
 	}
 
 	// From here, we're ready to instantiate a Horizon client
-	clientFromIssuer, err := horizonissuer.HorizonClientFromIssuer(issuerSpec, secret.Data)
+	clientFromIssuer, err := r.Clients.Get(ctx, issuer, issuerSpec, credentials, horizonissuer.CredentialsEnroll)
 	if err != nil || clientFromIssuer == nil {
 		return ctrl.Result{}, fmt.Errorf("%s: %v", "Unable to instantiate an Horizon client", err)
 	}
 
 	r.Issuer.Client = *clientFromIssuer
-
-	if issuerSpec.RevokeCertificates {
-		// examine DeletionTimestamp to determine if object is under deletion
-		if certificateRequest.ObjectMeta.DeletionTimestamp.IsZero() {
-			// The object is not being deleted, so if it does not have our finalizer,
-			// then lets add the finalizer and update the object. This is equivalent
-			// registering our finalizer.
-			if !controllerutil.ContainsFinalizer(&certificateRequest, FinalizerName) {
-				controllerutil.AddFinalizer(&certificateRequest, FinalizerName)
-			}
-		} else {
-			// The object is being deleted
-			err = r.handleDeletion(ctx, &certificateRequest)
-			if err != nil {
-				return ctrl.Result{}, err
-			}
-			// Stop reconciliation as the item is being deleted
-			return ctrl.Result{}, nil
+	r.Issuer.ReadClient = nil
+	if horizonissuer.SeparateCredentials(issuerSpec) {
+		readCredentials, err := horizonissuer.CredentialSourceFromIssuer(r.Client, issuerSpec, secretNamespace, credentialsDir, horizonissuer.CredentialsRead)
+		if err != nil {
+			return ctrl.Result{}, horizonissuer.WrapError(errGetCredentials, err)
 		}
+		readClient, err := r.Clients.Get(ctx, issuer, issuerSpec, readCredentials, horizonissuer.CredentialsRead)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("%s: %v", "Unable to instantiate an Horizon client", err)
+		}
+		r.Issuer.ReadClient = readClient
+	}
+
+	// examine DeletionTimestamp to determine if object is under deletion
+	if certificateRequest.ObjectMeta.DeletionTimestamp.IsZero() {
+		// The object is not being deleted, so if it does not have our finalizer,
+		// then lets add the finalizer and update the object. This is equivalent
+		// registering our finalizer. It is only needed when the issuer has to
+		// revoke or cancel something on Horizon once the object is deleted.
+		holdDeletion := issuerSpec.RevokeCertificates || issuerSpec.OnAbandon == horizonapi.AbandonPolicyCancel
+		if holdDeletion && !controllerutil.ContainsFinalizer(&certificateRequest, FinalizerName) {
+			controllerutil.AddFinalizer(&certificateRequest, FinalizerName)
+		}
+	} else {
+		// The object is being deleted
+		err = r.handleDeletion(ctx, &certificateRequest, issuerSpec)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		// Stop reconciliation as the item is being deleted
+		return ctrl.Result{}, nil
 	}
 
 	// Ignore CertificateRequest if it is already Ready
@@ -164,9 +256,32 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 		Type:   cmapi.CertificateRequestConditionReady,
 		Status: cmmeta.ConditionTrue,
 	}) {
+		if certificateRequest.Annotations[horizonissuer.DeliveryAnnotation] == deliveryPending {
+			return r.checkDelivery(ctx, &certificateRequest)
+		}
+		// Certificates may be revoked on Horizon out-of-band
+		if issuerSpec.ReissueRevoked {
+			return r.checkRevocation(ctx, &certificateRequest)
+		}
 		log.Info("CertificateRequest is Ready. Ignoring.")
 		return ctrl.Result{}, nil
 	}
+	// Ignore CertificateRequest if its certificate was revoked, as a new
+	// one is requested for its Certificate
+	if cmutil.CertificateRequestHasCondition(&certificateRequest, cmapi.CertificateRequestCondition{
+		Type:   cmapi.CertificateRequestConditionReady,
+		Status: cmmeta.ConditionFalse,
+		Reason: ReasonRevoked,
+	}) {
+		log.Info("CertificateRequest is Revoked. Ignoring.")
+		return ctrl.Result{}, nil
+	}
+	// Operators may discard the Horizon request of a stuck or failed request
+	// to submit it again. Denials are final, so denied requests are left as is.
+	if certificateRequest.Annotations[horizonissuer.ForceReenrollAnnotation] == "true" && !cmutil.CertificateRequestIsDenied(&certificateRequest) {
+		return r.forceReenroll(ctx, &certificateRequest, issuerSpec)
+	}
+
 	// Ignore CertificateRequest if it is already Failed
 	if cmutil.CertificateRequestHasCondition(&certificateRequest, cmapi.CertificateRequestCondition{
 		Type:   cmapi.CertificateRequestConditionReady,
@@ -186,10 +301,41 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return ctrl.Result{}, nil
 	}
 
+	// Conditions set during this reconcile are compared against this one, so
+	// that a final state isn't overridden by a generic one
+	var initialReady cmapi.CertificateRequestCondition
+	if ready := cmutil.GetCertificateRequestCondition(&certificateRequest, cmapi.CertificateRequestConditionReady); ready != nil {
+		initialReady = *ready
+	}
+
 	// Update the CSR object when returning from the Reconcile function
 	defer func() {
-		if err != nil {
-			setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, err.Error())
+		if recovered := recover(); recovered != nil {
+			err = panicError(ctx, recovered)
+			result = ctrl.Result{}
+		}
+
+		// Permanent errors won't be solved by retrying, so the request is
+		// marked as failed instead of being requeued
+		if horizonissuer.IsPermanent(err) {
+			log.Error(err, "Permanent error, marking CertificateRequest as failed")
+			if certificateRequest.Status.FailureTime == nil {
+				nowTime := metav1.NewTime(r.Clock.Now())
+				certificateRequest.Status.FailureTime = &nowTime
+			}
+			setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed, err.Error())
+			err = nil
+			result = ctrl.Result{}
+		} else if err != nil && !finalReadySet(&certificateRequest, initialReady) {
+			setReadyCondition(cmmeta.ConditionFalse, horizonissuer.ErrorReason(err, cmapi.CertificateRequestReasonPending), err.Error())
+		}
+
+		// The issuance is reported once the certificate is in its Secret
+		// when delivery is verified, and right away otherwise
+		issued := err == nil && justIssued(&certificateRequest, initialReady)
+		if issued && r.VerifyDelivery {
+			metav1.SetMetaDataAnnotation(&certificateRequest.ObjectMeta, horizonissuer.DeliveryAnnotation, deliveryPending)
+			result = ctrl.Result{RequeueAfter: deliveryCheckInterval}
 		}
 
 		annotations := certificateRequest.Annotations
@@ -206,8 +352,39 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 				result = ctrl.Result{}
 			}
 		}
+
+		if issued && !r.VerifyDelivery && err == nil {
+			r.recordIssued(&certificateRequest, "The certificate was issued by Horizon")
+		}
+
+		if issuerSpec.MetadataConfigMap {
+			if syncErr := r.syncMetadataConfigMap(ctx, &certificateRequest, issuerSpec); syncErr != nil {
+				err = utilerrors.NewAggregate([]error{err, syncErr})
+				result = ctrl.Result{}
+			}
+		}
 	}()
 
+	// ClusterIssuers may restrict the namespaces allowed to use them
+	if _, ok := issuer.(*horizonapi.ClusterIssuer); ok {
+		allowed, err := r.namespaceAllowed(ctx, issuerSpec.AllowedNamespaces, certificateRequest.Namespace)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("%w: %v", errNamespaceCheck, err)
+		}
+		if !allowed {
+			log.Info("Namespace is not allowed to use the ClusterIssuer. Marking as failed.")
+
+			if certificateRequest.Status.FailureTime == nil {
+				nowTime := metav1.NewTime(r.Clock.Now())
+				certificateRequest.Status.FailureTime = &nowTime
+			}
+
+			message := fmt.Sprintf("Namespace %s is not allowed to use ClusterIssuer %s", certificateRequest.Namespace, issuer.GetName())
+			setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed, message)
+			return ctrl.Result{}, nil
+		}
+	}
+
 	// If CertificateRequest has been denied, mark the CertificateRequest as
 	// Ready=Denied and set FailureTime if not already.
 	if cmutil.CertificateRequestIsDenied(&certificateRequest) {
@@ -223,153 +400,525 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return ctrl.Result{}, nil
 	}
 
-	// If CertificateRequest has not been approved, we should submit the request.
-	if !cmutil.CertificateRequestIsApproved(&certificateRequest) {
-		// If the request has been submitted to Horizon, pull info from Horizon
-		if _, ok := certificateRequest.Annotations[horizonissuer.RequestIdAnnotation]; ok {
-			return r.Issuer.UpdateRequest(ctx, &certificateRequest)
-		} else {
-			labels, owner, team, err := r.certificateMetadata(ctx, &certificateRequest)
-			if err != nil {
-				setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, err.Error())
-			}
-			return r.Issuer.SubmitRequest(ctx, r.Client, *issuerSpec, labels, owner, team, &certificateRequest)
+	// The certificate may have been persisted without the Ready condition,
+	// for instance when the controller restarted in between, in which case
+	// there is no need to contact Horizon again
+	if len(certificateRequest.Status.Certificate) > 0 {
+		if _, err := pki.DecodeX509CertificateBytes(certificateRequest.Status.Certificate); err == nil {
+			log.Info("CertificateRequest already has a certificate. Marking as issued.")
+			setReadyCondition(cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued, "Signed")
+			return ctrl.Result{}, nil
+		}
+	}
+
+	// If the request has been submitted to Horizon, pull info from Horizon.
+	// Approval by cert-manager may happen before or after submission, so it
+	// has no say on whether the request is polled. Only issuers requiring
+	// approval wait for it before submitting.
+	if _, ok := certificateRequest.Annotations[horizonissuer.RequestIdAnnotation]; ok {
+		effectiveSpec := *issuerSpec
+		effectiveSpec.IncludeChain = r.includeChain(ctx, &certificateRequest, issuerSpec)
+		return r.Issuer.UpdateRequest(ctx, effectiveSpec, &certificateRequest)
+	}
+
+	// The cache may lag behind a previous reconciliation that persisted the
+	// request ID, in which case the request must not be submitted again.
+	if r.APIReader != nil {
+		var latest cmapi.CertificateRequest
+		if err := r.APIReader.Get(ctx, req.NamespacedName, &latest); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unexpected get error: %v", err)
+		}
+		if requestId, ok := latest.Annotations[horizonissuer.RequestIdAnnotation]; ok {
+			log.Info("Request was already submitted. Not submitting it again.", "requestId", requestId)
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+
+	// A missing or malformed CSR would only be rejected by Horizon, so the
+	// request fails right away with the InvalidRequest condition cert-manager
+	// defines for it
+	if err := horizonissuer.ValidateCSR(certificateRequest.Spec.Request); err != nil {
+		log.Info("CSR is invalid. Marking as failed.", "reason", err.Error())
+
+		if certificateRequest.Status.FailureTime == nil {
+			nowTime := metav1.NewTime(r.Clock.Now())
+			certificateRequest.Status.FailureTime = &nowTime
+		}
+
+		cmutil.SetCertificateRequestCondition(&certificateRequest, cmapi.CertificateRequestConditionInvalidRequest, cmmeta.ConditionTrue, ReasonInvalidRequest, err.Error())
+		setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed, err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	if issuerSpec.Suspend {
+		log.Info("Issuer is suspended. Not submitting the request.")
+		setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, "The issuer is suspended, the request will be submitted once it is resumed")
+		return ctrl.Result{RequeueAfter: suspendedRequeueAfter}, nil
+	}
+
+	// Names outside of the allowed domains are denied, as an approver would.
+	// Only the Ready reason says so: cert-manager reserves the Denied
+	// condition to approvers, and rejects it on requests it already approved.
+	if err := horizonissuer.ValidateDomains(certificateRequest.Spec.Request, issuerSpec.AllowedDomains); err != nil {
+		log.Info("Requested names are not allowed. Marking as denied.", "reason", err.Error())
+
+		if certificateRequest.Status.FailureTime == nil {
+			nowTime := metav1.NewTime(r.Clock.Now())
+			certificateRequest.Status.FailureTime = &nowTime
+		}
+
+		setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonDenied, err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	// Rejecting keys and usages locally gives a faster and clearer failure
+	// than a rejection by the Horizon profile policy
+	if err := horizonissuer.ValidateKeyType(certificateRequest.Spec.Request, issuerSpec.AllowedKeyTypes); err != nil {
+		return ctrl.Result{}, &horizonissuer.PermanentError{Err: err}
+	}
+	if profileErr != nil {
+		return ctrl.Result{}, &horizonissuer.PermanentError{Err: profileErr}
+	}
+	if err := horizonissuer.ValidateUsages(certificateRequest.Spec.Usages, certificateRequest.Spec.IsCA, issuerSpec.AllowedUsages, issuerSpec.AllowCA); err != nil {
+		return ctrl.Result{}, &horizonissuer.PermanentError{Err: err}
+	}
+
+	metadata, err := r.certificateMetadata(ctx, &certificateRequest, issuerSpec, secretNamespace)
+	if err != nil {
+		setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, err.Error())
+	}
+
+	metadata.IsCA = certificateRequest.Spec.IsCA
+
+	// Fall back to the usages requested in the CSR when the Certificate
+	// could not be resolved or does not list any
+	if len(metadata.KeyUsages) == 0 && len(metadata.ExtendedKeyUsages) == 0 {
+		metadata.KeyUsages, metadata.ExtendedKeyUsages, err = horizonissuer.UsagesFromCSR(certificateRequest.Spec.Request)
+		if err != nil {
+			log.Error(err, "Unable to read usages from the CSR")
+		}
+	}
+
+	// An invalid subject won't get any better by retrying, so the request
+	// is marked as failed.
+	if err := horizonissuer.ValidateSubject(metadata.Subject); err != nil {
+		log.Error(err, "Invalid subject, marking CertificateRequest as failed")
+
+		if certificateRequest.Status.FailureTime == nil {
+			nowTime := metav1.NewTime(r.Clock.Now())
+			certificateRequest.Status.FailureTime = &nowTime
 		}
+
+		setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed, err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	// Owners unknown to Horizon would break the routing of its notifications
+	if issuerSpec.ValidateOwner && metadata.Owner != nil && *metadata.Owner != "" {
+		exists, err := r.Issuer.OwnerExists(ctx, *metadata.Owner)
+		if err != nil {
+			return ctrl.Result{}, horizonissuer.WrapError(errOwnerLookup, err)
+		}
+		if !exists {
+			return ctrl.Result{}, &horizonissuer.PermanentError{Err: fmt.Errorf("owner %s is not a known identity of the Horizon directory", *metadata.Owner)}
+		}
+	}
+
+	// Issuers requiring approval only submit approved requests, the approval
+	// triggering a new reconcile
+	if issuerSpec.RequireApproval && !cmutil.CertificateRequestIsApproved(&certificateRequest) {
+		log.Info("CertificateRequest is not approved yet. Not submitting the request.")
+		setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, "Waiting for the CertificateRequest to be approved before submitting it to Horizon")
+		return ctrl.Result{}, nil
 	}
 
-	return ctrl.Result{}, nil
+	return r.Issuer.SubmitRequest(ctx, r.Client, *issuerSpec, metadata, &certificateRequest)
 }
 
-func (r *CertificateRequestReconciler) handleDeletion(ctx context.Context, certificateRequest *cmapi.CertificateRequest) error {
+func (r *CertificateRequestReconciler) handleDeletion(ctx context.Context, certificateRequest *cmapi.CertificateRequest, issuerSpec *horizonapi.IssuerSpec) error {
 	if controllerutil.ContainsFinalizer(certificateRequest, FinalizerName) {
-		// our finalizer is present, so lets handle any external dependency
-		if err := r.Issuer.RevokeCertificate(ctx, certificateRequest); err != nil {
+		// our finalizer is present, so lets handle any external dependency:
+		// the issued certificate, or the request that won't be issued
+		var err error
+		_, submitted := certificateRequest.Annotations[horizonissuer.RequestIdAnnotation]
+		switch {
+		case len(certificateRequest.Status.Certificate) > 0:
+			if issuerSpec.RevokeCertificates {
+				err = r.Issuer.RevokeCertificate(ctx, *issuerSpec, certificateRequest)
+			}
+		case submitted && issuerSpec.OnAbandon == horizonapi.AbandonPolicyCancel:
+			err = r.Issuer.CancelRequest(ctx, *issuerSpec, certificateRequest)
+		}
+		if err != nil {
 			// if fail to delete the external dependency here, return with error
-			// so that it can be retried, except if the error is from Horizon
-			if _, isHorizonError := err.(*http.HorizonErrorResponse); !isHorizonError {
+			// so that it can be retried, except if Horizon rejected it for good
+			var horizonErr *horizonissuer.HorizonError
+			if !errors.As(err, &horizonErr) || !horizonissuer.IsPermanent(err) {
 				return err
-			} else {
-				log.FromContext(ctx).Info(fmt.Sprintf("Horizon returned an error when revoking the certificate : %s. Marking the certificate as revoked to avoid a loop.", err.Error()))
 			}
+			log.FromContext(ctx).Info(fmt.Sprintf("Horizon returned an error when releasing the request : %s. Removing the finalizer to avoid a loop.", err.Error()))
 		}
 
 		// remove our finalizer from the list and update it.
 		controllerutil.RemoveFinalizer(certificateRequest, FinalizerName)
+		return r.Update(ctx, certificateRequest)
 	}
 
 	return nil
 }
 
-func (r *CertificateRequestReconciler) certificateMetadata(ctx context.Context, certificateRequest *cmapi.CertificateRequest) ([]requests.LabelElement, *string, *string, error) {
-	// Récupérer le certificat
-	var owner *string
-	var team *string
-	var labels []requests.LabelElement
+// finalReadySet returns whether a final Ready condition, such as Failed or
+// Issued, was set on a CertificateRequest since its Ready condition was initial.
+func finalReadySet(certificateRequest *cmapi.CertificateRequest, initial cmapi.CertificateRequestCondition) bool {
+	ready := cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady)
+	if ready == nil || (ready.Status == initial.Status && ready.Reason == initial.Reason && ready.Message == initial.Message) {
+		return false
+	}
+	switch ready.Reason {
+	case cmapi.CertificateRequestReasonFailed, cmapi.CertificateRequestReasonDenied, cmapi.CertificateRequestReasonIssued:
+		return true
+	}
+	return false
+}
 
+// checkRevocation checks whether the certificate of an issued
+// CertificateRequest was revoked on Horizon, in which case the request is
+// marked as revoked and the re-issuance of its Certificate is triggered.
+// Only the latest request of a Certificate is checked, as the certificates
+// of older ones are no longer in use.
+func (r *CertificateRequestReconciler) checkRevocation(ctx context.Context, certificateRequest *cmapi.CertificateRequest) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	requestId, ok := certificateRequest.Annotations[horizonissuer.RequestIdAnnotation]
+	if !ok {
+		return ctrl.Result{}, nil
+	}
 	certificate, err := r.certificateFromRequest(ctx, certificateRequest)
 	if err != nil {
-		return nil, nil, nil, err
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if certificate.Status.Revision == nil || certificateRequest.Annotations[cmapi.CertificateRequestRevisionAnnotationKey] != strconv.Itoa(*certificate.Status.Revision) {
+		return ctrl.Result{}, nil
+	}
+
+	revoked, err := r.Issuer.IsRevoked(ctx, requestId)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !revoked {
+		return ctrl.Result{RequeueAfter: revocationCheckInterval}, nil
+	}
+
+	log.Info("Certificate was revoked on Horizon, triggering its re-issuance", "requestId", requestId)
+	cmutil.SetCertificateRequestCondition(
+		certificateRequest,
+		cmapi.CertificateRequestConditionReady,
+		cmmeta.ConditionFalse,
+		ReasonRevoked,
+		"The certificate was revoked on Horizon",
+	)
+	if err := r.Status().Update(ctx, certificateRequest); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// This is how cert-manager triggers a manual renewal
+	cmutil.SetCertificateCondition(
+		certificate,
+		certificate.Generation,
+		cmapi.CertificateConditionIssuing,
+		cmmeta.ConditionTrue,
+		ReasonRevoked,
+		"Re-issuing the certificate, which was revoked on Horizon",
+	)
+	return ctrl.Result{}, r.Status().Update(ctx, certificate)
+}
+
+// forceReenroll discards the Horizon request of a CertificateRequest and
+// resets its Ready condition, so that it is submitted again. The force
+// annotation is removed along with the request ID, so that a request is
+// re-enrolled only once per annotation.
+func (r *CertificateRequestReconciler) forceReenroll(ctx context.Context, certificateRequest *cmapi.CertificateRequest, issuerSpec *horizonapi.IssuerSpec) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+	log.Info("Forcing re-enrollment", "requestId", certificateRequest.Annotations[horizonissuer.RequestIdAnnotation])
+
+	r.Issuer.ForgetSubmission(certificateRequest, issuerSpec.Profile)
+	if err := r.Update(ctx, certificateRequest); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	certificateRequest.Status.FailureTime = nil
+	cmutil.SetCertificateRequestCondition(
+		certificateRequest,
+		cmapi.CertificateRequestConditionReady,
+		cmmeta.ConditionFalse,
+		cmapi.CertificateRequestReasonPending,
+		"Re-enrollment forced, submitting the request again",
+	)
+	if err := r.Status().Update(ctx, certificateRequest); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// namespaceAllowed returns whether CertificateRequests from the given namespace
+// are allowed by the issuer's namespace restrictions.
+func (r *CertificateRequestReconciler) namespaceAllowed(ctx context.Context, allowedNamespaces *horizonapi.AllowedNamespaces, namespace string) (bool, error) {
+	if allowedNamespaces == nil {
+		return true, nil
+	}
+
+	for _, name := range allowedNamespaces.Names {
+		if name == namespace {
+			return true, nil
+		}
+	}
+
+	if allowedNamespaces.Selector == nil {
+		return false, nil
 	}
-	issuer, err := r.issuerFromRequest(ctx, certificateRequest)
+
+	selector, err := metav1.LabelSelectorAsSelector(allowedNamespaces.Selector)
 	if err != nil {
-		return nil, nil, nil, err
+		return false, err
+	}
+
+	var ns corev1.Namespace
+	if err := r.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		return false, err
+	}
+
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// certificateMetadata resolves the metadata sent to Horizon along with a CertificateRequest, from
+// its namespace labels, its Certificate and Ingress annotations and from the issuer spec. When the Certificate or Ingress
+// cannot be fetched, the metadata from the issuer is still returned along with the error. The default
+// labels ConfigMap of the issuer is read from issuerNamespace.
+func (r *CertificateRequestReconciler) certificateMetadata(ctx context.Context, certificateRequest *cmapi.CertificateRequest, issuerSpec *horizonapi.IssuerSpec, issuerNamespace string) (horizonissuer.CertificateMetadata, error) {
+	// Récupérer le certificat
+	var metadata horizonissuer.CertificateMetadata
+	var subject []rfc5280.CFDistinguishedName
+	var ingress *v1.Ingress
+	var templateAnnotations map[string]string
+
+	// Labels by increasing precedence
+	var labelSets []map[string][]string
+	if issuerSpec.DefaultLabelsConfigMapName != "" {
+		var configMap corev1.ConfigMap
+		if cmErr := r.Get(ctx, types.NamespacedName{Namespace: issuerNamespace, Name: issuerSpec.DefaultLabelsConfigMapName}, &configMap); cmErr != nil {
+			ctrl.LoggerFrom(ctx).Error(cmErr, "Unable to read the default labels ConfigMap, ignoring it")
+		} else {
+			labelSets = append(labelSets, horizonissuer.SingleValuedLabels(configMap.Data))
+		}
 	}
-	ingress, err := r.ingressFromCertificate(ctx, certificate)
+	labelSets = append(labelSets, horizonissuer.SingleValuedLabels(issuerSpec.StaticLabels))
+
+	certificate, err := r.certificateFromRequest(ctx, certificateRequest)
 	if err != nil {
-		return nil, nil, nil, err
+		certificate = nil
+	} else if ingress, err = r.ingressFromCertificate(ctx, certificate); err != nil {
+		ingress = nil
+	}
+
+	if mapping := issuerSpec.NamespaceLabels; mapping != nil {
+		var ns corev1.Namespace
+		if nsErr := r.Get(ctx, types.NamespacedName{Name: certificateRequest.Namespace}, &ns); nsErr != nil {
+			ctrl.LoggerFrom(ctx).Error(nsErr, "Unable to read the namespace labels, ignoring them")
+		} else {
+			if owner := ns.Labels[mapping.Owner]; mapping.Owner != "" && owner != "" {
+				metadata.Owner = &owner
+			}
+			if team := ns.Labels[mapping.Team]; mapping.Team != "" && team != "" {
+				metadata.Team = &team
+			}
+			labelSets = append(labelSets, horizonissuer.LabelsFromNamespace(ns.Labels, mapping.Labels))
+		}
 	}
 
 	if ingress != nil {
 		ownerString := ingress.Annotations[horizonissuer.OwnerAnnotation]
 		if ownerString != "" {
-			owner = &ownerString
+			metadata.Owner = &ownerString
 		}
 		teamString := ingress.Annotations[horizonissuer.TeamAnnotation]
 		if teamString != "" {
-			owner = &teamString
+			metadata.Team = &teamString
 		}
+		labelSets = append(labelSets, horizonissuer.FilterLabels(horizonissuer.LabelsFromAnnotations(ingress.Annotations), issuerSpec.LabelAnnotationAllowlist, issuerSpec.LabelAnnotationDenylist))
 	}
 
 	if certificate != nil {
 		ownerString := certificate.Annotations[horizonissuer.OwnerAnnotation]
 		if ownerString != "" {
-			owner = &ownerString
+			metadata.Owner = &ownerString
 		}
 		teamString := certificate.Annotations[horizonissuer.TeamAnnotation]
 		if teamString != "" {
-			owner = &teamString
+			metadata.Team = &teamString
+		}
+		labelSets = append(labelSets, horizonissuer.FilterLabels(horizonissuer.LabelsFromAnnotations(certificate.Annotations), issuerSpec.LabelAnnotationAllowlist, issuerSpec.LabelAnnotationDenylist))
+		subject = horizonissuer.SubjectFromAnnotations(certificate.Annotations)
+		metadata.CommonName = certificate.Annotations[horizonissuer.CommonNameAnnotation]
+		metadata.VirtualCa = certificate.Annotations[horizonissuer.VirtualCaAnnotation]
+		metadata.Category = certificate.Annotations[horizonissuer.CategoryAnnotation]
+		templateAnnotations = certificate.Annotations
+		metadata.Description = certificate.Annotations[horizonissuer.DescriptionAnnotation]
+		metadata.KeyUsages, metadata.ExtendedKeyUsages = horizonissuer.UsagesFromCertManager(certificate.Spec.Usages)
+
+		if issuerSpec.RenewalMode == horizonapi.RenewalModeAuto || issuerSpec.RenewalMode == horizonapi.RenewalModeRenew {
+			renewed, renewErr := r.renewedCertificate(ctx, certificateRequest, certificate, issuerSpec.RenewalMode)
+			if renewErr != nil {
+				ctrl.LoggerFrom(ctx).Error(renewErr, "Unable to compare the CSR with the current certificate, enrolling a new one")
+			}
+			metadata.RenewedCertificate = renewed
 		}
-	}
-
-	issuerSpec, _, err := issuerutil.GetSpecAndStatus(issuer)
-	if err != nil {
-		return nil, nil, nil, err
 	}
 
 	if issuerSpec.Owner != nil {
-		owner = issuerSpec.Owner
+		metadata.Owner = issuerSpec.Owner
 	}
 
 	if issuerSpec.Team != nil {
-		team = issuerSpec.Team
+		metadata.Team = issuerSpec.Team
 	}
 
-	if len(issuerSpec.Labels) > 0 {
-		for k, v := range issuerSpec.Labels {
-			labels = append(labels, requests.LabelElement{
-				Label: k,
-				Value: v,
-			})
-		}
+	// The virtual CA set on the Certificate takes precedence over the issuer one
+	if metadata.VirtualCa == "" && issuerSpec.VirtualCa != nil {
+		metadata.VirtualCa = *issuerSpec.VirtualCa
+	}
+
+	// The category set on the Certificate takes precedence over the issuer one
+	if metadata.Category == "" {
+		metadata.Category = issuerSpec.Category
+	}
+
+	// Template parameters set on the Certificate take precedence over the issuer ones
+	metadata.TemplateParameters = horizonissuer.TemplateParameters(issuerSpec.TemplateParameters, templateAnnotations)
+
+	// The description set on the Certificate takes precedence over the issuer one
+	if metadata.Description == "" {
+		metadata.Description = issuerSpec.Description
+	}
+	metadata.Description = horizonissuer.ExpandDescription(metadata.Description, certificateRequest)
+
+	labelSets = append(labelSets, horizonissuer.SingleValuedLabels(issuerSpec.Labels), issuerSpec.MultiValuedLabels)
+	metadata.Labels = horizonissuer.MergeLabels(labelSets...)
+
+	// Subject components set on the Certificate take precedence over the issuer ones
+	metadata.Subject = horizonissuer.MergeSubject(horizonissuer.SubjectFromSpec(issuerSpec.Subject), subject)
+
+	return metadata, err
+}
+
+// includeChain returns whether the chain is stored along with the issued
+// certificate, as set on the Certificate of a CertificateRequest through the
+// horizon.evertrust.io/include-chain annotation, or on the issuer when the
+// Certificate cannot be resolved or does not set it.
+func (r *CertificateRequestReconciler) includeChain(ctx context.Context, certificateRequest *cmapi.CertificateRequest, issuerSpec *horizonapi.IssuerSpec) bool {
+	certificate, err := r.certificateFromRequest(ctx, certificateRequest)
+	if err != nil {
+		return issuerSpec.IncludeChain
 	}
+	value, ok := certificate.Annotations[horizonissuer.IncludeChainAnnotation]
+	if !ok {
+		return issuerSpec.IncludeChain
+	}
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "Invalid include-chain annotation, using the issuer setting", "value", value)
+		return issuerSpec.IncludeChain
+	}
+	return include
+}
 
-	return labels, owner, team, nil
+// renewedCertificate returns the certificate renewed by a CertificateRequest,
+// read from the secret of its Certificate, or an empty string when a new
+// certificate should be enrolled.
+func (r *CertificateRequestReconciler) renewedCertificate(ctx context.Context, certificateRequest *cmapi.CertificateRequest, certificate *cmapi.Certificate, mode horizonapi.RenewalMode) (string, error) {
+	// The secret doesn't exist before the first issuance
+	var secret corev1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: certificate.Namespace, Name: certificate.Spec.SecretName}, &secret); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	return horizonissuer.RenewedCertificate(mode, certificateRequest.Spec.Request, secret.Data[corev1.TLSCertKey])
 }
 
 // issuerFromRequest returns the Issuer of a given CertificateRequest.
 func (r *CertificateRequestReconciler) issuerFromRequest(ctx context.Context, certificateRequest *cmapi.CertificateRequest) (client.Object, error) {
-	issuerGVK := horizonapi.GroupVersion.WithKind(certificateRequest.Spec.IssuerRef.Kind)
-	issuerRO, err := r.Scheme.New(issuerGVK)
-	if err != nil {
-		err = fmt.Errorf("%w: %v", errIssuerRef, err)
-		return nil, err
+	kind, issuerName, ok := resolveIssuerRef(certificateRequest)
+	if !ok {
+		return nil, &horizonissuer.PermanentError{Err: fmt.Errorf("%w: unexpected issuer kind: %s", errIssuerRef, certificateRequest.Spec.IssuerRef.Kind)}
 	}
-	issuer := issuerRO.(client.Object)
-	// Create a Namespaced name for Issuer and a non-Namespaced name for ClusterIssuer
-	issuerName := types.NamespacedName{
-		Name: certificateRequest.Spec.IssuerRef.Name,
+	// Issuers are always looked up in the namespace of the request, so a
+	// namespace-qualified name can't be honored
+	if strings.Contains(issuerName.Name, "/") {
+		return nil, &horizonissuer.PermanentError{Err: fmt.Errorf("%w: name %s must not be qualified with a namespace, Issuers are looked up in the namespace of the CertificateRequest", errIssuerRef, issuerName.Name)}
 	}
-	switch t := issuer.(type) {
-	case *horizonapi.Issuer:
-		issuerName.Namespace = certificateRequest.Namespace
-	case *horizonapi.ClusterIssuer:
-	default:
-		err := fmt.Errorf("unexpected issuer type: %v", t)
+
+	issuer, err := r.newIssuer(kind)
+	if err != nil {
 		return nil, err
 	}
-
-	// Get the Issuer or ClusterIssuer
 	if err := r.Get(ctx, issuerName, issuer); err != nil {
+		// An Issuer and a ClusterIssuer may share a name, in which case the
+		// error points to the one of the other kind
+		if apierrors.IsNotFound(err) {
+			otherKind, otherName := "ClusterIssuer", types.NamespacedName{Name: issuerName.Name}
+			if kind == "ClusterIssuer" {
+				otherKind, otherName = "Issuer", types.NamespacedName{Namespace: certificateRequest.Namespace, Name: issuerName.Name}
+			}
+			if other, otherErr := r.newIssuer(otherKind); otherErr == nil && r.Get(ctx, otherName, other) == nil {
+				return nil, fmt.Errorf("%w: %v, but a %s of that name exists, set issuerRef.kind to %s to use it", errGetIssuer, err, otherKind, otherKind)
+			}
+		}
 		return nil, fmt.Errorf("%w: %v", errGetIssuer, err)
 	}
 
 	return issuer, nil
-
 }
 
-// certificateFromRequest returns the Certificate object associated with that CertificateRequest
-func (r *CertificateRequestReconciler) certificateFromRequest(ctx context.Context, certificateRequest *cmapi.CertificateRequest) (*cmapi.Certificate, error) {
-	certificateName := types.NamespacedName{
-		Namespace: certificateRequest.Namespace,
-		Name:      certificateRequest.Annotations["cert-manager.io/certificate-name"],
+// newIssuer returns an empty Issuer or ClusterIssuer.
+func (r *CertificateRequestReconciler) newIssuer(kind string) (client.Object, error) {
+	issuerRO, err := r.Scheme.New(horizonapi.GroupVersion.WithKind(kind))
+	if err != nil {
+		return nil, &horizonissuer.PermanentError{Err: fmt.Errorf("%w: %v", errIssuerRef, err)}
 	}
+	return issuerRO.(client.Object), nil
+}
 
+// failingOpen returns whether an issuer whose healthCheckPolicy is failOpen
+// failed its health check.
+func failingOpen(issuerSpec *horizonapi.IssuerSpec, issuerStatus *horizonapi.IssuerStatus) bool {
+	ready := issuerutil.GetReadyCondition(issuerStatus)
+	return issuerSpec.HealthCheckPolicy == horizonapi.HealthCheckPolicyFailOpen && ready != nil && ready.Reason == ReasonFailingOpen
+}
+
+// certificateFromRequest returns the Certificate object associated with that CertificateRequest,
+// preferably found through its owner references.
+func (r *CertificateRequestReconciler) certificateFromRequest(ctx context.Context, certificateRequest *cmapi.CertificateRequest) (*cmapi.Certificate, error) {
 	var certificate cmapi.Certificate
-	err := r.Get(ctx, certificateName, &certificate)
+	err := r.Get(ctx, certificateName(certificateRequest), &certificate)
 
 	return &certificate, err
 }
 
+// certificateName returns the name of the Certificate of a CertificateRequest,
+// read from its owner references or else from the annotation cert-manager
+// sets. The name is empty for requests created without a Certificate.
+func certificateName(certificateRequest *cmapi.CertificateRequest) types.NamespacedName {
+	name := types.NamespacedName{
+		Namespace: certificateRequest.Namespace,
+		Name:      certificateRequest.Annotations[cmapi.CertificateNameKey],
+	}
+	for _, ref := range certificateRequest.OwnerReferences {
+		if ref.APIVersion == cmapi.SchemeGroupVersion.String() && ref.Kind == cmapi.CertificateKind {
+			name.Name = ref.Name
+		}
+	}
+	return name
+}
+
 func (r *CertificateRequestReconciler) ingressFromCertificate(ctx context.Context, certificate *cmapi.Certificate) (*v1.Ingress, error) {
 	var ingressName *types.NamespacedName
 	for _, ref := range certificate.OwnerReferences {
@@ -391,7 +940,27 @@ func (r *CertificateRequestReconciler) ingressFromCertificate(ctx context.Contex
 }
 
 func (r *CertificateRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &cmapi.CertificateRequest{}, issuerRefField, indexIssuerRef); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&cmapi.CertificateRequest{}).
+		For(&cmapi.CertificateRequest{}, builder.WithPredicates(ignoreOwnUpdates, onlyIf(!r.Priority.enabled()))).
+		Watches(
+			&source.Kind{Type: &cmapi.CertificateRequest{}},
+			prioritizedHandler{priority: r.Priority},
+			builder.WithPredicates(ignoreOwnUpdates, onlyIf(r.Priority.enabled())),
+		).
+		Watches(
+			&source.Kind{Type: &horizonapi.Issuer{}},
+			handler.EnqueueRequestsFromMapFunc(r.waitingRequests("Issuer")),
+			builder.WithPredicates(becameReady),
+		).
+		Watches(
+			&source.Kind{Type: &horizonapi.ClusterIssuer{}},
+			handler.EnqueueRequestsFromMapFunc(r.waitingRequests("ClusterIssuer")),
+			builder.WithPredicates(becameReady),
+		).
+		WithOptions(controller.Options{RecoverPanic: true}).
 		Complete(r)
 }
@@ -0,0 +1,735 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evertrust/horizon-go/requests"
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	horizonissuer "github.com/evertrust/horizon-issuer/internal/issuer/horizon"
+	"github.com/evertrust/horizon-issuer/internal/issuer/horizon/horizontest"
+	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// submit reconciles a new CertificateRequest, and returns the ID of the
+// request it submitted to Horizon.
+func (h *testHarness) submit(certificateRequest *cmapi.CertificateRequest) string {
+	h.t.Helper()
+	result, err := h.reconcile(certificateRequest)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	requestId := certificateRequest.Annotations[horizonissuer.RequestIdAnnotation]
+	if requestId == "" {
+		h.t.Fatalf("no request was submitted: %+v", certificateRequest.Status.Conditions)
+	}
+	if result.RequeueAfter != 10*time.Second {
+		h.t.Errorf("RequeueAfter = %s after submission, want the submitted poll interval", result.RequeueAfter)
+	}
+	expectReady(h.t, certificateRequest, cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending)
+	return requestId
+}
+
+func TestCertificateRequestEnroll(t *testing.T) {
+	h := newTestHarness(t)
+	h.readyIssuer(nil)
+	certificateRequest := h.createRequest("enroll", newCSR(t, nil, "www.example.com"), nil)
+
+	requestId := h.submit(certificateRequest)
+	request, ok := h.horizon.Request(requestId)
+	if !ok {
+		t.Fatalf("request %s is unknown to Horizon", requestId)
+	}
+	if request.Profile != testProfile || request.Workflow != requests.RequestWorkflowEnroll {
+		t.Errorf("submitted a %s request on %s, want an enroll request on %s", request.Workflow, request.Profile, testProfile)
+	}
+
+	if err := h.horizon.Issue(requestId); err != nil {
+		t.Fatal(err)
+	}
+	result, err := h.reconcile(certificateRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requeue || result.RequeueAfter != 0 {
+		t.Errorf("issued request requeued with %+v", result)
+	}
+	expectReady(t, certificateRequest, cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued)
+	certificate, err := pki.DecodeX509CertificateBytes(certificateRequest.Status.Certificate)
+	if err != nil {
+		t.Fatalf("issued certificate is invalid: %v", err)
+	}
+	if len(certificate.DNSNames) != 1 || certificate.DNSNames[0] != "www.example.com" {
+		t.Errorf("issued certificate names = %v, want [www.example.com]", certificate.DNSNames)
+	}
+	if h.horizon.Calls(horizontest.EndpointSubmit) != 1 {
+		t.Errorf("submitted %d requests, want 1", h.horizon.Calls(horizontest.EndpointSubmit))
+	}
+}
+
+func TestCertificateRequestPoll(t *testing.T) {
+	h := newTestHarness(t)
+	h.readyIssuer(nil)
+	certificateRequest := h.createRequest("poll", newCSR(t, nil, "www.example.com"), nil)
+	requestId := h.submit(certificateRequest)
+
+	result, err := h.reconcile(certificateRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter != 15*time.Second {
+		t.Errorf("RequeueAfter = %s for a pending request, want the poll interval", result.RequeueAfter)
+	}
+	expectReady(t, certificateRequest, cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending)
+
+	// Approvals on Horizon are mirrored, and the request is still polled
+	if err := h.horizon.Approve(requestId); err != nil {
+		t.Fatal(err)
+	}
+	result, err = h.reconcile(certificateRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter != 15*time.Second {
+		t.Errorf("RequeueAfter = %s for an approved request, want the poll interval", result.RequeueAfter)
+	}
+	if !cmutil.CertificateRequestIsApproved(certificateRequest) {
+		t.Error("approval on Horizon was not mirrored onto the CertificateRequest")
+	}
+	if calls := h.horizon.Calls(horizontest.EndpointGetRequest); calls != 2 {
+		t.Errorf("polled Horizon %d times, want 2", calls)
+	}
+}
+
+func TestCertificateRequestReject(t *testing.T) {
+	tests := []struct {
+		name     string
+		approved bool
+		reason   string
+	}{
+		{name: "denied", reason: cmapi.CertificateRequestReasonDenied},
+		// cert-manager does not allow denying approved requests
+		{name: "approved then denied", approved: true, reason: cmapi.CertificateRequestReasonFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHarness(t)
+			h.readyIssuer(nil)
+			certificateRequest := h.createRequest("reject", newCSR(t, nil, "www.example.com"), func(certificateRequest *cmapi.CertificateRequest) {
+				if tt.approved {
+					cmutil.SetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionApproved, cmmeta.ConditionTrue, "cert-manager.io", "Approved")
+				}
+			})
+			requestId := h.submit(certificateRequest)
+
+			h.clock.Step(time.Hour)
+			if err := h.horizon.Deny(requestId); err != nil {
+				t.Fatal(err)
+			}
+			// Denials are mirrored first, and reported by the next reconcile
+			for i := 0; i < 2; i++ {
+				if _, err := h.reconcile(certificateRequest); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expectReady(t, certificateRequest, cmmeta.ConditionFalse, tt.reason)
+			if failureTime := certificateRequest.Status.FailureTime; failureTime == nil || !failureTime.Time.Equal(h.clock.Now()) {
+				t.Errorf("failure time = %v, want the time of the fake clock %s", failureTime, h.clock.Now())
+			}
+		})
+	}
+}
+
+func TestCertificateRequestRequeue(t *testing.T) {
+	h := newTestHarness(t)
+	h.readyIssuer(nil)
+	certificateRequest := h.createRequest("requeue", newCSR(t, nil, "www.example.com"), nil)
+
+	h.horizon.Fail(horizontest.EndpointSubmit, horizontest.Failure{StatusCode: 503, Code: "UNAVAILABLE", Message: "Maintenance", RetryAfter: "42", Times: 1})
+	result, err := h.reconcile(certificateRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter != 42*time.Second {
+		t.Errorf("RequeueAfter = %s, want the Retry-After delay of Horizon", result.RequeueAfter)
+	}
+	ready := expectReady(t, certificateRequest, cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending)
+	if ready.Message != "Horizon temporarily unavailable" {
+		t.Errorf("Ready message = %q", ready.Message)
+	}
+	if _, ok := certificateRequest.Annotations[horizonissuer.RequestIdAnnotation]; ok {
+		t.Error("request ID set although the submission failed")
+	}
+
+	// The request is submitted once Horizon is back
+	h.submit(certificateRequest)
+}
+
+func TestCertificateRequestIssuerNotReady(t *testing.T) {
+	h := newTestHarness(t)
+	h.horizon.Fail(horizontest.EndpointSelf, horizontest.Failure{StatusCode: 503, Code: "UNAVAILABLE", Message: "Maintenance"})
+	h.settleIssuer(h.createIssuer(nil))
+	certificateRequest := h.createRequest("not-ready", newCSR(t, nil, "www.example.com"), nil)
+
+	if _, err := h.reconcile(certificateRequest); !errors.Is(err, errIssuerNotReady) {
+		t.Errorf("err = %v, want %v", err, errIssuerNotReady)
+	}
+	if calls := h.horizon.Calls(horizontest.EndpointSubmit); calls != 0 {
+		t.Errorf("submitted %d requests to an issuer that is not ready", calls)
+	}
+}
+
+func TestCertificateRequestDeletion(t *testing.T) {
+	tests := []struct {
+		name   string
+		cancel bool
+		status requests.RequestStatus
+	}{
+		{name: "abandoned requests are canceled", cancel: true, status: requests.RequestStatusCanceled},
+		{name: "abandoned requests are kept", status: requests.RequestStatusPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHarness(t)
+			h.readyIssuer(func(spec *horizonapi.IssuerSpec) {
+				if tt.cancel {
+					spec.OnAbandon = horizonapi.AbandonPolicyCancel
+				}
+			})
+			certificateRequest := h.createRequest("deletion", newCSR(t, nil, "www.example.com"), nil)
+			requestId := h.submit(certificateRequest)
+			if controllerutil.ContainsFinalizer(certificateRequest, FinalizerName) != tt.cancel {
+				t.Fatalf("finalizers = %v", certificateRequest.Finalizers)
+			}
+
+			h.delete(certificateRequest)
+			if err := h.client.Get(h.ctx, client.ObjectKeyFromObject(certificateRequest), certificateRequest); err == nil {
+				if _, err := h.reconcile(certificateRequest); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := h.client.Get(h.ctx, client.ObjectKeyFromObject(certificateRequest), certificateRequest)
+			if err == nil && controllerutil.ContainsFinalizer(certificateRequest, FinalizerName) {
+				t.Errorf("finalizer %s was not removed", FinalizerName)
+			} else if client.IgnoreNotFound(err) != nil {
+				t.Fatal(err)
+			}
+			request, _ := h.horizon.Request(requestId)
+			if request.Status != tt.status {
+				t.Errorf("Horizon request status = %s, want %s", request.Status, tt.status)
+			}
+		})
+	}
+}
+
+// approved sets the Approved condition an approver would set.
+func approved(certificateRequest *cmapi.CertificateRequest) {
+	cmutil.SetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionApproved, cmmeta.ConditionTrue, "cert-manager.io", "Approved")
+}
+
+func TestCertificateRequestApproval(t *testing.T) {
+	tests := []struct {
+		name            string
+		requireApproval bool
+		approveBefore   bool
+	}{
+		{name: "approved before submission", approveBefore: true},
+		{name: "approved after submission"},
+		{name: "approval required, approved before submission", requireApproval: true, approveBefore: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHarness(t)
+			h.readyIssuer(func(spec *horizonapi.IssuerSpec) { spec.RequireApproval = tt.requireApproval })
+			certificateRequest := h.createRequest("approval", newCSR(t, nil, "www.example.com"), func(certificateRequest *cmapi.CertificateRequest) {
+				if tt.approveBefore {
+					approved(certificateRequest)
+				}
+			})
+
+			requestId := h.submit(certificateRequest)
+			if !tt.approveBefore {
+				approved(certificateRequest)
+				if err := h.client.Status().Update(h.ctx, certificateRequest); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Approved and submitted requests are polled
+			if err := h.horizon.Issue(requestId); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := h.reconcile(certificateRequest); err != nil {
+				t.Fatal(err)
+			}
+			expectReady(t, certificateRequest, cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued)
+		})
+	}
+}
+
+func TestCertificateRequestWaitsForApproval(t *testing.T) {
+	h := newTestHarness(t)
+	h.readyIssuer(func(spec *horizonapi.IssuerSpec) { spec.RequireApproval = true })
+	certificateRequest := h.createRequest("wait", newCSR(t, nil, "www.example.com"), nil)
+
+	if _, err := h.reconcile(certificateRequest); err != nil {
+		t.Fatal(err)
+	}
+	expectReady(t, certificateRequest, cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending)
+	if calls := h.horizon.Calls(horizontest.EndpointSubmit); calls != 0 {
+		t.Fatalf("submitted %d requests before approval", calls)
+	}
+
+	approved(certificateRequest)
+	if err := h.client.Status().Update(h.ctx, certificateRequest); err != nil {
+		t.Fatal(err)
+	}
+	h.submit(certificateRequest)
+}
+
+var errAPIUnavailable = errors.New("the API server is unavailable")
+
+// failingWrites is a client whose writes of CertificateRequests carrying a
+// request ID fail while fail is set, as when the API server becomes
+// unavailable right after a request is submitted to Horizon.
+type failingWrites struct {
+	client.Client
+	fail bool
+}
+
+func (c *failingWrites) failing(obj client.Object) bool {
+	_, submitted := obj.GetAnnotations()[horizonissuer.RequestIdAnnotation]
+	return c.fail && submitted
+}
+
+func (c *failingWrites) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if c.failing(obj) {
+		return errAPIUnavailable
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *failingWrites) Status() client.StatusWriter {
+	return &failingStatusWrites{StatusWriter: c.Client.Status(), client: c}
+}
+
+type failingStatusWrites struct {
+	client.StatusWriter
+	client *failingWrites
+}
+
+func (w *failingStatusWrites) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if w.client.failing(obj) {
+		return errAPIUnavailable
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func TestCertificateRequestNotSubmittedTwice(t *testing.T) {
+	tests := []struct {
+		name string
+		// restart replaces the issuer by a new one, as when the controller
+		// restarts before the request is reconciled again
+		restart bool
+	}{
+		{name: "same controller process"},
+		{name: "restarted controller", restart: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHarness(t)
+			h.readyIssuer(func(spec *horizonapi.IssuerSpec) { spec.CorrelationIdLabel = "correlation-id" })
+			writes := &failingWrites{Client: h.client, fail: true}
+			h.requests.Client = writes
+			certificateRequest := h.createRequest("twice", newCSR(t, nil, "www.example.com"), nil)
+
+			if _, err := h.reconcile(certificateRequest); !errors.Is(err, errAPIUnavailable) {
+				t.Fatalf("err = %v, want %v", err, errAPIUnavailable)
+			}
+			if _, ok := certificateRequest.Annotations[horizonissuer.RequestIdAnnotation]; ok {
+				t.Fatal("request ID was persisted")
+			}
+			if certificateRequest.Annotations[horizonissuer.CorrelationIdAnnotation] == "" {
+				t.Fatal("correlation ID was not persisted before submitting")
+			}
+			submitted := h.horizon.Requests()
+			if len(submitted) != 1 {
+				t.Fatalf("submitted %d requests, want 1", len(submitted))
+			}
+
+			writes.fail = false
+			if tt.restart {
+				h.requests.Issuer = horizonissuer.HorizonIssuer{
+					Requeue:       h.requests.Issuer.Requeue,
+					AdoptRequests: true,
+				}
+			}
+			if requestId := h.submit(certificateRequest); requestId != submitted[0].Id {
+				t.Errorf("request ID = %s, want the one of the first submission %s", requestId, submitted[0].Id)
+			}
+			if calls := h.horizon.Calls(horizontest.EndpointSubmit); calls != 1 {
+				t.Errorf("submitted %d requests, want 1", calls)
+			}
+		})
+	}
+}
+
+func TestCertificateRequestWithoutAnnotations(t *testing.T) {
+	h := newTestHarness(t)
+	h.readyIssuer(nil)
+	certificateRequest := h.createRequest("no-annotations", newCSR(t, nil, "www.example.com"), nil)
+	if certificateRequest.Annotations != nil {
+		t.Fatalf("annotations = %v, want none", certificateRequest.Annotations)
+	}
+
+	requestId := h.submit(certificateRequest)
+	if err := h.horizon.Issue(requestId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.reconcile(certificateRequest); err != nil {
+		t.Fatal(err)
+	}
+	expectReady(t, certificateRequest, cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued)
+}
+
+func TestCertificateRequestPolicyRejected(t *testing.T) {
+	h := newTestHarness(t)
+	h.readyIssuer(nil)
+	certificateRequest := h.createRequest("rejected", newCSR(t, nil, "www.example.com"), nil)
+
+	h.horizon.Fail(horizontest.EndpointSubmit, horizontest.Failure{StatusCode: 400, Code: "WEBRA-POLICY", Message: "Key size is too small"})
+	for i := 0; i < 2; i++ {
+		result, err := h.reconcile(certificateRequest)
+		if err != nil || result.Requeue || result.RequeueAfter != 0 {
+			t.Fatalf("Reconcile() = %+v, %v, want no requeue", result, err)
+		}
+		ready := expectReady(t, certificateRequest, cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed)
+		if !strings.Contains(ready.Message, "Key size is too small") {
+			t.Errorf("Ready message = %q, want the Horizon error", ready.Message)
+		}
+	}
+	if calls := h.horizon.Calls(horizontest.EndpointSubmit); calls != 1 {
+		t.Errorf("submitted %d requests, want 1", calls)
+	}
+}
+
+func TestFinalReadySet(t *testing.T) {
+	condition := func(status cmmeta.ConditionStatus, reason string, message string) cmapi.CertificateRequestCondition {
+		return cmapi.CertificateRequestCondition{Type: cmapi.CertificateRequestConditionReady, Status: status, Reason: reason, Message: message}
+	}
+	pending := condition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, "Submitted request to Horizon")
+	rejected := condition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed, "(HTTP 400) Horizon returned a WEBRA-POLICY error")
+
+	tests := []struct {
+		name    string
+		initial cmapi.CertificateRequestCondition
+		ready   *cmapi.CertificateRequestCondition
+		want    bool
+	}{
+		{name: "no Ready condition"},
+		{name: "pending", initial: pending, ready: &pending},
+		{name: "failed during the reconcile", initial: pending, ready: &rejected, want: true},
+		{name: "failed before the reconcile", initial: rejected, ready: &rejected},
+		{name: "issued during the reconcile", ready: &cmapi.CertificateRequestCondition{Status: cmmeta.ConditionTrue, Reason: cmapi.CertificateRequestReasonIssued}, want: true},
+		{name: "denied during the reconcile", initial: pending, ready: &cmapi.CertificateRequestCondition{Status: cmmeta.ConditionFalse, Reason: cmapi.CertificateRequestReasonDenied}, want: true},
+		{name: "pending again", initial: rejected, ready: &pending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificateRequest := &cmapi.CertificateRequest{}
+			if tt.ready != nil {
+				cmutil.SetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady, tt.ready.Status, tt.ready.Reason, tt.ready.Message)
+			}
+			if got := finalReadySet(certificateRequest, tt.initial); got != tt.want {
+				t.Errorf("finalReadySet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateRequestRevocation(t *testing.T) {
+	tests := []struct {
+		name       string
+		module     string
+		wantModule string
+	}{
+		{name: "default module", wantModule: horizonissuer.DefaultModule},
+		{name: "module of the issuer", module: "est", wantModule: "est"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHarness(t)
+			h.readyIssuer(func(spec *horizonapi.IssuerSpec) {
+				spec.RevokeCertificates = true
+				spec.Module = tt.module
+			})
+			certificateRequest := h.createRequest("revocation", newCSR(t, nil, "www.example.com"), nil)
+			requestId := h.submit(certificateRequest)
+			if err := h.horizon.Issue(requestId); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := h.reconcile(certificateRequest); err != nil {
+				t.Fatal(err)
+			}
+			expectReady(t, certificateRequest, cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued)
+
+			h.delete(certificateRequest)
+			if _, err := h.reconcile(certificateRequest); err != nil {
+				t.Fatal(err)
+			}
+			var revocations []requests.HorizonRequest
+			for _, request := range h.horizon.Requests() {
+				if request.Workflow == requests.RequestWorkflowRevoke {
+					revocations = append(revocations, request)
+				}
+			}
+			if len(revocations) != 1 {
+				t.Fatalf("submitted %d revocation requests, want 1", len(revocations))
+			}
+			if revocations[0].Module != tt.wantModule {
+				t.Errorf("revocation module = %s, want %s", revocations[0].Module, tt.wantModule)
+			}
+		})
+	}
+}
+
+func TestCertificateRequestDomainNotAllowed(t *testing.T) {
+	h := newTestHarness(t)
+	h.readyIssuer(func(spec *horizonapi.IssuerSpec) { spec.AllowedDomains = []string{"*.example.com"} })
+	certificateRequest := h.createRequest("domains", newCSR(t, nil, "www.example.com", "www.example.org"), nil)
+
+	if _, err := h.reconcile(certificateRequest); err != nil {
+		t.Fatal(err)
+	}
+	ready := expectReady(t, certificateRequest, cmmeta.ConditionFalse, cmapi.CertificateRequestReasonDenied)
+	if !strings.Contains(ready.Message, "www.example.org") {
+		t.Errorf("Ready message = %q, want the name that is not allowed", ready.Message)
+	}
+	if calls := h.horizon.Calls(horizontest.EndpointSubmit); calls != 0 {
+		t.Errorf("submitted %d requests for names that are not allowed", calls)
+	}
+}
+
+func TestCertificateRequestForeignIssuerRef(t *testing.T) {
+	h := newTestHarness(t)
+	h.readyIssuer(nil)
+	for i, tt := range issuerRefs {
+		if tt.wantOk {
+			continue
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			certificateRequest := h.createRequest(fmt.Sprintf("foreign-%d", i), newCSR(t, nil, "www.example.com"), func(certificateRequest *cmapi.CertificateRequest) {
+				certificateRequest.Spec.IssuerRef.Group = tt.group
+				certificateRequest.Spec.IssuerRef.Kind = tt.kind
+			})
+			result, err := h.reconcile(certificateRequest)
+			if err != nil || !result.IsZero() {
+				t.Fatalf("Reconcile() = %+v, %v, want the request ignored", result, err)
+			}
+			if len(certificateRequest.Status.Conditions) > 0 || len(certificateRequest.Finalizers) > 0 {
+				t.Errorf("request was handled: conditions %+v, finalizers %v", certificateRequest.Status.Conditions, certificateRequest.Finalizers)
+			}
+		})
+	}
+	if calls := h.horizon.Calls(horizontest.EndpointSubmit); calls != 0 {
+		t.Errorf("%d requests were submitted, want none", calls)
+	}
+}
+
+func TestCertificateRequestOwnerValidation(t *testing.T) {
+	tests := []struct {
+		name            string
+		owner           string
+		requireApproval bool
+		reason          string
+	}{
+		{name: "known owner", owner: "jdoe", reason: cmapi.CertificateRequestReasonPending},
+		{name: "unknown owner", owner: "unknown", reason: cmapi.CertificateRequestReasonFailed},
+		// Owners are checked along with the other restrictions, before
+		// waiting for approval
+		{name: "unknown owner of a request to approve", owner: "unknown", requireApproval: true, reason: cmapi.CertificateRequestReasonFailed},
+		{name: "known owner of a request to approve", owner: "jdoe", requireApproval: true, reason: cmapi.CertificateRequestReasonPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHarness(t)
+			h.horizon.Identities = []string{"jdoe"}
+			h.readyIssuer(func(spec *horizonapi.IssuerSpec) {
+				spec.Owner = &tt.owner
+				spec.ValidateOwner = true
+				spec.RequireApproval = tt.requireApproval
+			})
+			certificateRequest := h.createRequest("owner", newCSR(t, nil, "www.example.com"), nil)
+
+			if _, err := h.reconcile(certificateRequest); err != nil {
+				t.Fatal(err)
+			}
+			ready := expectReady(t, certificateRequest, cmmeta.ConditionFalse, tt.reason)
+			if tt.reason == cmapi.CertificateRequestReasonFailed && !strings.Contains(ready.Message, tt.owner) {
+				t.Errorf("Ready message = %q, want the owner named", ready.Message)
+			}
+			wantSubmitted := 0
+			if tt.reason == cmapi.CertificateRequestReasonPending && !tt.requireApproval {
+				wantSubmitted = 1
+			}
+			if calls := h.horizon.Calls(horizontest.EndpointSubmit); calls != wantSubmitted {
+				t.Errorf("submitted %d requests, want %d", calls, wantSubmitted)
+			}
+			if calls := h.horizon.Calls(horizontest.EndpointIdentity); calls != 1 {
+				t.Errorf("owner was looked up %d times, want once", calls)
+			}
+		})
+	}
+}
+
+func TestCertificateRequestInvalidCSR(t *testing.T) {
+	tests := []struct {
+		name   string
+		csrPem []byte
+	}{
+		{name: "empty request"},
+		{name: "not a CSR", csrPem: []byte("not a CSR")},
+		{name: "truncated CSR", csrPem: newCSR(t, nil, "www.example.com")[:200]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHarness(t)
+			h.readyIssuer(nil)
+			certificateRequest := h.createRequest("invalid", tt.csrPem, nil)
+
+			result, err := h.reconcile(certificateRequest)
+			if err != nil || !result.IsZero() {
+				t.Fatalf("Reconcile() = %+v, %v, want no requeue", result, err)
+			}
+			ready := expectReady(t, certificateRequest, cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed)
+			if !cmutil.CertificateRequestHasCondition(certificateRequest, cmapi.CertificateRequestCondition{
+				Type:   cmapi.CertificateRequestConditionInvalidRequest,
+				Status: cmmeta.ConditionTrue,
+				Reason: ReasonInvalidRequest,
+			}) {
+				t.Errorf("conditions = %+v, want InvalidRequest", certificateRequest.Status.Conditions)
+			}
+			if invalid := cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionInvalidRequest); invalid != nil && invalid.Message != ready.Message {
+				t.Errorf("InvalidRequest message = %q, want the Ready message %q", invalid.Message, ready.Message)
+			}
+			if certificateRequest.Status.FailureTime == nil {
+				t.Error("failure time is not set")
+			}
+			if calls := h.horizon.Calls(horizontest.EndpointSubmit); calls != 0 {
+				t.Errorf("submitted %d requests, want none", calls)
+			}
+		})
+	}
+}
+
+func TestCertificateRequestAmbiguousAdoption(t *testing.T) {
+	h := newTestHarness(t)
+	h.readyIssuer(func(spec *horizonapi.IssuerSpec) { spec.CorrelationIdLabel = "correlation-id" })
+	writes := &failingWrites{Client: h.client, fail: true}
+	h.requests.Client = writes
+	certificateRequest := h.createRequest("ambiguous", newCSR(t, nil, "www.example.com"), nil)
+
+	// Submitted twice with the same correlation ID by controllers that lost
+	// the request ID, the second one not adopting requests
+	for i := 0; i < 2; i++ {
+		h.requests.Issuer = horizonissuer.HorizonIssuer{Requeue: h.requests.Issuer.Requeue, Recorder: h.recorder}
+		if _, err := h.reconcile(certificateRequest); !errors.Is(err, errAPIUnavailable) {
+			t.Fatalf("err = %v, want %v", err, errAPIUnavailable)
+		}
+	}
+	submitted := h.horizon.Requests()
+	if len(submitted) != 2 {
+		t.Fatalf("submitted %d requests, want 2", len(submitted))
+	}
+	if err := h.horizon.Issue(submitted[0].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	writes.fail = false
+	h.requests.Issuer = horizonissuer.HorizonIssuer{Requeue: h.requests.Issuer.Requeue, Recorder: h.recorder, AdoptRequests: true}
+	if _, err := h.reconcile(certificateRequest); err != nil {
+		t.Fatal(err)
+	}
+	if requestId := certificateRequest.Annotations[horizonissuer.RequestIdAnnotation]; requestId != submitted[0].Id {
+		t.Errorf("adopted request %s, want the completed one %s", requestId, submitted[0].Id)
+	}
+	ready := expectReady(t, certificateRequest, cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending)
+	correlationId := certificateRequest.Annotations[horizonissuer.CorrelationIdAnnotation]
+	if want := "Found 2 requests carrying correlation ID " + correlationId + " on Horizon, adopted " + submitted[0].Id; ready.Message != want {
+		t.Errorf("Ready message = %q, want %q", ready.Message, want)
+	}
+	select {
+	case event := <-h.recorder.Events:
+		if !strings.HasPrefix(event, "Warning "+horizonissuer.EventReasonAmbiguousRequest+" ") {
+			t.Errorf("event = %q, want a %s warning", event, horizonissuer.EventReasonAmbiguousRequest)
+		}
+	default:
+		t.Errorf("no %s event was recorded", horizonissuer.EventReasonAmbiguousRequest)
+	}
+	if calls := h.horizon.Calls(horizontest.EndpointSubmit); calls != 2 {
+		t.Errorf("submitted %d requests, want 2", calls)
+	}
+}
+
+func TestCertificateRequestForceReenrollAdoption(t *testing.T) {
+	h := newTestHarness(t)
+	h.readyIssuer(func(spec *horizonapi.IssuerSpec) { spec.CorrelationIdLabel = "correlation-id" })
+	h.requests.Issuer.AdoptRequests = true
+	certificateRequest := h.createRequest("reenroll", newCSR(t, nil, "www.example.com"), nil)
+
+	stuckId := h.submit(certificateRequest)
+	correlationId := certificateRequest.Annotations[horizonissuer.CorrelationIdAnnotation]
+
+	h.update(certificateRequest, func() {
+		certificateRequest.Annotations[horizonissuer.ForceReenrollAnnotation] = "true"
+	})
+	if _, err := h.reconcile(certificateRequest); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := certificateRequest.Annotations[horizonissuer.CorrelationIdAnnotation]; ok {
+		t.Error("correlation ID was kept when forcing the re-enrollment")
+	}
+
+	// The stuck request still carries the previous correlation ID on
+	// Horizon, and must not be adopted
+	if requestId := h.submit(certificateRequest); requestId == stuckId {
+		t.Errorf("request ID = %s, want a new request rather than the discarded one", requestId)
+	}
+	if calls := h.horizon.Calls(horizontest.EndpointSubmit); calls != 2 {
+		t.Errorf("submitted %d requests, want 2", calls)
+	}
+	if certificateRequest.Annotations[horizonissuer.CorrelationIdAnnotation] == correlationId {
+		t.Errorf("correlation ID = %s, want a new one", correlationId)
+	}
+}
+
+func TestCertificateRequestAdoptInvalidRequestId(t *testing.T) {
+	h := newTestHarness(t)
+	h.readyIssuer(nil)
+	selfCalls := h.horizon.Calls(horizontest.EndpointSelf)
+	// Sent as is, the ID would lead to the principal endpoint of Horizon
+	certificateRequest := h.createRequest("adopt", newCSR(t, nil, "www.example.com"), func(cr *cmapi.CertificateRequest) {
+		cr.Annotations = map[string]string{horizonissuer.AdoptRequestIdAnnotation: "../security/principals/self"}
+	})
+
+	result, err := h.reconcile(certificateRequest)
+	if err != nil || !result.IsZero() {
+		t.Fatalf("Reconcile() = %+v, %v, want no requeue", result, err)
+	}
+	expectReady(t, certificateRequest, cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed)
+	if calls := h.horizon.Calls(horizontest.EndpointSelf) - selfCalls; calls != 0 {
+		t.Errorf("called %s %d times, want none", horizontest.EndpointSelf, calls)
+	}
+	if calls := h.horizon.Calls(horizontest.EndpointGetRequest); calls != 0 {
+		t.Errorf("fetched %d requests, want none", calls)
+	}
+}
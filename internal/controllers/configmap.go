@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	horizonissuer "github.com/evertrust/horizon-issuer/internal/issuer/horizon"
+	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Keys of the metadata ConfigMap of a CertificateRequest
+const (
+	MetadataRequestIdKey      = "requestId"
+	MetadataStatusKey         = "status"
+	MetadataProfileKey        = "profile"
+	MetadataSubmittedAtKey    = "submittedAt"
+	MetadataCompletionTimeKey = "completionTime"
+)
+
+// syncMetadataConfigMap mirrors the Horizon request of a CertificateRequest
+// into a ConfigMap of the same name, garbage-collected along with it. The
+// submission time is the first time the request ID was seen, and is kept
+// across updates.
+func (r *CertificateRequestReconciler) syncMetadataConfigMap(ctx context.Context, certificateRequest *cmapi.CertificateRequest, issuerSpec *horizonapi.IssuerSpec) error {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      certificateRequest.Name,
+			Namespace: certificateRequest.Namespace,
+		},
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+
+		requestId := certificateRequest.Annotations[horizonissuer.RequestIdAnnotation]
+		configMap.Data[MetadataRequestIdKey] = requestId
+		configMap.Data[MetadataProfileKey] = issuerSpec.Profile
+		if requestId == "" {
+			delete(configMap.Data, MetadataSubmittedAtKey)
+		} else if _, ok := configMap.Data[MetadataSubmittedAtKey]; !ok {
+			configMap.Data[MetadataSubmittedAtKey] = r.Clock.Now().UTC().Format(time.RFC3339)
+		}
+
+		configMap.Data[MetadataStatusKey] = ""
+		delete(configMap.Data, MetadataCompletionTimeKey)
+		if ready := cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady); ready != nil {
+			configMap.Data[MetadataStatusKey] = ready.Reason
+			if completed := completionTime(certificateRequest, ready); completed != nil {
+				configMap.Data[MetadataCompletionTimeKey] = completed.UTC().Format(time.RFC3339)
+			}
+		}
+
+		return controllerutil.SetControllerReference(certificateRequest, configMap, r.Scheme)
+	})
+	return err
+}
+
+// completionTime returns when a CertificateRequest was issued or failed, or
+// nil while it's still pending.
+func completionTime(certificateRequest *cmapi.CertificateRequest, ready *cmapi.CertificateRequestCondition) *metav1.Time {
+	if certificateRequest.Status.FailureTime != nil {
+		return certificateRequest.Status.FailureTime
+	}
+	if ready.Status == cmmeta.ConditionTrue {
+		return ready.LastTransitionTime
+	}
+	return nil
+}
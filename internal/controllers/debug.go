@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	horizonissuer "github.com/evertrust/horizon-issuer/internal/issuer/horizon"
+	issuerutil "github.com/evertrust/horizon-issuer/internal/issuer/util"
+	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// debugRequestsInterval is the minimum delay between two calls made to
+// Horizon while listing requests, so that triage does not load Horizon.
+const debugRequestsInterval = 200 * time.Millisecond
+
+// DebugRequest describes a CertificateRequest managed by the controller, as
+// seen by Kubernetes and by Horizon.
+type DebugRequest struct {
+	Namespace     string `json:"namespace"`
+	Name          string `json:"name"`
+	IssuerKind    string `json:"issuerKind"`
+	IssuerName    string `json:"issuerName"`
+	RequestId     string `json:"requestId,omitempty"`
+	Ready         string `json:"ready,omitempty"`
+	HorizonStatus string `json:"horizonStatus,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// DebugRequestsHandler lists the CertificateRequests referencing our
+// issuers along with the status of their Horizon request, which is only
+// fetched for requests still waiting for their certificate. Listings are
+// served one at a time, and calls to Horizon are spaced by
+// debugRequestsInterval.
+func (r *CertificateRequestReconciler) DebugRequestsHandler() http.Handler {
+	busy := make(chan struct{}, 1)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case busy <- struct{}{}:
+			defer func() { <-busy }()
+		default:
+			http.Error(w, "a listing is already in progress", http.StatusTooManyRequests)
+			return
+		}
+
+		requests, err := r.debugRequests(req.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(requests)
+	})
+}
+
+func (r *CertificateRequestReconciler) debugRequests(ctx context.Context) ([]DebugRequest, error) {
+	var certificateRequests cmapi.CertificateRequestList
+	if err := r.List(ctx, &certificateRequests); err != nil {
+		return nil, err
+	}
+
+	throttle := time.NewTicker(debugRequestsInterval)
+	defer throttle.Stop()
+
+	result := []DebugRequest{}
+	for i := range certificateRequests.Items {
+		certificateRequest := &certificateRequests.Items[i]
+		kind, issuerName, ok := resolveIssuerRef(certificateRequest)
+		if !ok {
+			continue
+		}
+
+		request := DebugRequest{
+			Namespace:  certificateRequest.Namespace,
+			Name:       certificateRequest.Name,
+			IssuerKind: kind,
+			IssuerName: issuerName.Name,
+			RequestId:  certificateRequest.Annotations[horizonissuer.RequestIdAnnotation],
+		}
+		if ready := cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady); ready != nil {
+			request.Ready = ready.Reason
+		}
+
+		if request.RequestId != "" && isWaiting(certificateRequest) {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-throttle.C:
+			}
+			status, err := r.horizonStatus(ctx, certificateRequest, request.RequestId)
+			if err != nil {
+				request.Error = err.Error()
+			}
+			request.HorizonStatus = status
+		}
+
+		result = append(result, request)
+	}
+	return result, nil
+}
+
+// horizonStatus fetches the status of a request from the Horizon instance
+// of the issuer of a CertificateRequest.
+func (r *CertificateRequestReconciler) horizonStatus(ctx context.Context, certificateRequest *cmapi.CertificateRequest, requestId string) (string, error) {
+	issuer, err := r.issuerFromRequest(ctx, certificateRequest)
+	if err != nil {
+		return "", err
+	}
+	issuerSpec, _, err := issuerutil.GetSpecAndStatus(issuer)
+	if err != nil {
+		return "", err
+	}
+
+	secretNamespace := certificateRequest.Namespace
+	credentialsDir := ""
+	if _, ok := issuer.(*horizonapi.ClusterIssuer); ok {
+		secretNamespace = r.ClusterResourceNamespace
+		credentialsDir = r.CredentialsDir
+	}
+	purpose := horizonissuer.CredentialsEnroll
+	if horizonissuer.SeparateCredentials(issuerSpec) {
+		purpose = horizonissuer.CredentialsRead
+	}
+	credentials, err := horizonissuer.CredentialSourceFromIssuer(r.Client, issuerSpec, secretNamespace, credentialsDir, purpose)
+	if err != nil {
+		return "", horizonissuer.WrapError(errGetCredentials, err)
+	}
+	horizonClient, err := r.Clients.Get(ctx, issuer, issuerSpec, credentials, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	request, err := horizonClient.GetRequest(ctx, requestId)
+	if err != nil {
+		return "", err
+	}
+	return string(request.Status), nil
+}
+
+// redacted replaces the values that may hold secrets in DebugConfig.
+const redacted = "REDACTED"
+
+// DebugConfig is the effective configuration of the controller.
+type DebugConfig struct {
+	Flags   map[string]string `json:"flags"`
+	Issuers []DebugIssuer     `json:"issuers"`
+}
+
+// DebugIssuer holds the effective settings of an issuer.
+type DebugIssuer struct {
+	Kind      string                `json:"kind"`
+	Namespace string                `json:"namespace,omitempty"`
+	Name      string                `json:"name"`
+	Spec      horizonapi.IssuerSpec `json:"spec"`
+	Ready     string                `json:"ready,omitempty"`
+}
+
+// DebugConfigHandler serves the flags of the controller along with the
+// settings of every issuer, defaults resolved by the controller being
+// filled in. Credentials are never read, and the values of additional
+// headers are redacted.
+func DebugConfigHandler(reader client.Reader, flags map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		config := DebugConfig{Flags: flags, Issuers: []DebugIssuer{}}
+
+		var issuers horizonapi.IssuerList
+		if err := reader.List(req.Context(), &issuers); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range issuers.Items {
+			config.Issuers = append(config.Issuers, debugIssuer("Issuer", &issuers.Items[i], &issuers.Items[i].Spec, &issuers.Items[i].Status))
+		}
+		var clusterIssuers horizonapi.ClusterIssuerList
+		if err := reader.List(req.Context(), &clusterIssuers); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range clusterIssuers.Items {
+			config.Issuers = append(config.Issuers, debugIssuer("ClusterIssuer", &clusterIssuers.Items[i], &clusterIssuers.Items[i].Spec, &clusterIssuers.Items[i].Status))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(config)
+	})
+}
+
+func debugIssuer(kind string, issuer client.Object, spec *horizonapi.IssuerSpec, status *horizonapi.IssuerStatus) DebugIssuer {
+	effective := *spec.DeepCopy()
+	if effective.Module == "" {
+		effective.Module = horizonissuer.DefaultModule
+	}
+	if effective.UpnSanType == "" {
+		effective.UpnSanType = horizonissuer.DefaultUpnSanType
+	}
+	if effective.CategoryLabel == "" {
+		effective.CategoryLabel = horizonissuer.DefaultCategoryLabel
+	}
+	for name := range effective.AdditionalHeaders {
+		effective.AdditionalHeaders[name] = redacted
+	}
+
+	debug := DebugIssuer{
+		Kind:      kind,
+		Namespace: issuer.GetNamespace(),
+		Name:      issuer.GetName(),
+		Spec:      effective,
+	}
+	if ready := issuerutil.GetReadyCondition(status); ready != nil {
+		debug.Ready = fmt.Sprintf("%s/%s", ready.Status, ready.Reason)
+	}
+	return debug
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	horizonissuer "github.com/evertrust/horizon-issuer/internal/issuer/horizon"
+	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Values of the delivery annotation of issued CertificateRequests
+const (
+	deliveryPending      = "Pending"
+	deliveryDelivered    = "Delivered"
+	deliveryNotDelivered = "NotDelivered"
+)
+
+// Reasons of the events recorded when a certificate is issued
+const (
+	EventReasonIssued       = "Issued"
+	EventReasonNotDelivered = "NotDelivered"
+)
+
+// deliveryCheckInterval is the delay between two checks of whether
+// cert-manager wrote an issued certificate to its Secret, and deliveryTimeout
+// how long after the issuance it is checked.
+const (
+	deliveryCheckInterval = 5 * time.Second
+	deliveryTimeout       = 10 * time.Minute
+)
+
+// justIssued returns whether a CertificateRequest was issued during the
+// reconcile that started with the initial Ready condition.
+func justIssued(certificateRequest *cmapi.CertificateRequest, initial cmapi.CertificateRequestCondition) bool {
+	ready := cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady)
+	return ready != nil && ready.Status == cmmeta.ConditionTrue && ready.Reason == cmapi.CertificateRequestReasonIssued &&
+		(initial.Status != cmmeta.ConditionTrue || initial.Reason != cmapi.CertificateRequestReasonIssued)
+}
+
+// recordIssued reports the issuance of a CertificateRequest through an event
+// and the issued certificates counter.
+func (r *CertificateRequestReconciler) recordIssued(certificateRequest *cmapi.CertificateRequest, message string) {
+	issuedCertificates.With(issuerLabels(certificateRequest)).Inc()
+	if r.Issuer.Recorder != nil {
+		r.Issuer.Recorder.Event(certificateRequest, corev1.EventTypeNormal, EventReasonIssued, message)
+	}
+}
+
+// checkDelivery checks whether cert-manager wrote the certificate of an
+// issued CertificateRequest to the Secret of its Certificate, in which case
+// the issuance is reported. It is checked again until deliveryTimeout has
+// elapsed since the issuance, after which a warning is recorded instead.
+// Requests that name no Certificate, or whose Certificate is gone, have no
+// Secret to check, and are reported right away.
+func (r *CertificateRequestReconciler) checkDelivery(ctx context.Context, certificateRequest *cmapi.CertificateRequest) (ctrl.Result, error) {
+	var certificate *cmapi.Certificate
+	if certificateName(certificateRequest).Name != "" {
+		var err error
+		certificate, err = r.certificateFromRequest(ctx, certificateRequest)
+		if apierrors.IsNotFound(err) {
+			certificate = nil
+		} else if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	message := "The certificate was issued by Horizon"
+	delivered := true
+	if certificate != nil {
+		var secret corev1.Secret
+		secretName := types.NamespacedName{Namespace: certificate.Namespace, Name: certificate.Spec.SecretName}
+		if err := r.Get(ctx, secretName, &secret); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		delivered = sameLeaf(secret.Data[corev1.TLSCertKey], certificateRequest.Status.Certificate)
+		message = fmt.Sprintf("The certificate was issued by Horizon and written to Secret %s", secretName.Name)
+	}
+
+	state := deliveryDelivered
+	if !delivered {
+		ready := cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady)
+		if ready != nil && ready.LastTransitionTime != nil && r.Clock.Since(ready.LastTransitionTime.Time) < deliveryTimeout {
+			return ctrl.Result{RequeueAfter: deliveryCheckInterval}, nil
+		}
+		state = deliveryNotDelivered
+	}
+
+	metav1.SetMetaDataAnnotation(&certificateRequest.ObjectMeta, horizonissuer.DeliveryAnnotation, state)
+	if err := r.Update(ctx, certificateRequest); err != nil {
+		return ctrl.Result{}, err
+	}
+	if delivered {
+		r.recordIssued(certificateRequest, message)
+	} else if r.Issuer.Recorder != nil {
+		r.Issuer.Recorder.Eventf(certificateRequest, corev1.EventTypeWarning, EventReasonNotDelivered, "The certificate was issued by Horizon, but was not written to Secret %s within %s", certificate.Spec.SecretName, deliveryTimeout)
+	}
+	return ctrl.Result{}, nil
+}
+
+// sameLeaf returns whether two PEM-encoded chains start with the same
+// certificate.
+func sameLeaf(chainPem []byte, otherPem []byte) bool {
+	leaf, err := pki.DecodeX509CertificateBytes(chainPem)
+	if err != nil {
+		return false
+	}
+	other, err := pki.DecodeX509CertificateBytes(otherPem)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(leaf.Raw, other.Raw)
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	horizonissuer "github.com/evertrust/horizon-issuer/internal/issuer/horizon"
+	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclock "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// failingCertificateGets fails the reads of Certificates while fail is set.
+type failingCertificateGets struct {
+	client.Client
+	fail bool
+}
+
+func (c *failingCertificateGets) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if _, ok := obj.(*cmapi.Certificate); ok && c.fail {
+		return errAPIUnavailable
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+// issue submits and issues a CertificateRequest, and returns it as left by
+// the reconcile that issued it.
+func (h *testHarness) issue(certificateRequest *cmapi.CertificateRequest) {
+	h.t.Helper()
+	if err := h.horizon.Issue(h.submit(certificateRequest)); err != nil {
+		h.t.Fatal(err)
+	}
+	if _, err := h.reconcile(certificateRequest); err != nil {
+		h.t.Fatal(err)
+	}
+	if !cmutil.CertificateRequestHasCondition(certificateRequest, cmapi.CertificateRequestCondition{Type: cmapi.CertificateRequestConditionReady, Status: cmmeta.ConditionTrue}) {
+		h.t.Fatalf("request is not issued: %+v", certificateRequest.Status.Conditions)
+	}
+}
+
+func TestCertificateRequestDelivery(t *testing.T) {
+	tests := []struct {
+		name string
+		// certificate is the name of the Certificate of the request, if any
+		certificate string
+		// owned references the Certificate as owner rather than through the
+		// annotation of cert-manager
+		owned bool
+		// exists creates the Certificate, and written writes the issued
+		// certificate to its Secret
+		exists  bool
+		written bool
+		want    string
+		event   string
+	}{
+		{name: "request without Certificate", want: deliveryDelivered, event: EventReasonIssued},
+		{name: "deleted Certificate", certificate: "deleted", want: deliveryDelivered, event: EventReasonIssued},
+		{name: "certificate written to the Secret", certificate: "www", exists: true, written: true, want: deliveryDelivered, event: EventReasonIssued},
+		{name: "certificate of an owner written to the Secret", certificate: "www", owned: true, exists: true, written: true, want: deliveryDelivered, event: EventReasonIssued},
+		{name: "certificate not written to the Secret", certificate: "www", exists: true, want: deliveryNotDelivered, event: EventReasonNotDelivered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHarness(t)
+			// Transition times of the conditions set by cert-manager helpers
+			// are compared with the clock of the reconciler
+			conditionClock := fakeclock.NewFakeClock(h.clock.Now())
+			previous := cmutil.Clock
+			cmutil.Clock = conditionClock
+			t.Cleanup(func() { cmutil.Clock = previous })
+			h.requests.VerifyDelivery = true
+			h.readyIssuer(nil)
+
+			var certificate *cmapi.Certificate
+			if tt.exists {
+				certificate = &cmapi.Certificate{
+					ObjectMeta: metav1.ObjectMeta{Name: tt.certificate},
+					Spec: cmapi.CertificateSpec{
+						SecretName: tt.certificate + "-tls",
+						IssuerRef:  cmmeta.ObjectReference{Group: horizonapi.GroupVersion.Group, Kind: "Issuer", Name: testIssuerName},
+					},
+				}
+				h.create(certificate)
+			}
+			certificateRequest := h.createRequest("delivery", newCSR(t, nil, "www.example.com"), func(certificateRequest *cmapi.CertificateRequest) {
+				switch {
+				case tt.owned && certificate != nil:
+					certificateRequest.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(certificate, cmapi.SchemeGroupVersion.WithKind(cmapi.CertificateKind))}
+				case tt.certificate != "":
+					certificateRequest.Annotations = map[string]string{cmapi.CertificateNameKey: tt.certificate}
+				}
+			})
+			h.issue(certificateRequest)
+			if got := certificateRequest.Annotations[horizonissuer.DeliveryAnnotation]; got != deliveryPending {
+				t.Fatalf("delivery = %q once issued, want %s", got, deliveryPending)
+			}
+			if tt.written {
+				h.create(&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: certificate.Spec.SecretName},
+					Data:       map[string][]byte{corev1.TLSCertKey: certificateRequest.Status.Certificate},
+				})
+			}
+
+			result, err := h.reconcile(certificateRequest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == deliveryNotDelivered {
+				// Delivery is checked again until it times out
+				if result.RequeueAfter != deliveryCheckInterval || certificateRequest.Annotations[horizonissuer.DeliveryAnnotation] != deliveryPending {
+					t.Fatalf("Reconcile() = %+v with delivery %q, want a new check", result, certificateRequest.Annotations[horizonissuer.DeliveryAnnotation])
+				}
+				h.clock.Step(deliveryTimeout)
+				conditionClock.Step(deliveryTimeout)
+				if result, err = h.reconcile(certificateRequest); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !result.IsZero() {
+				t.Errorf("Reconcile() = %+v, want no requeue", result)
+			}
+			if got := certificateRequest.Annotations[horizonissuer.DeliveryAnnotation]; got != tt.want {
+				t.Errorf("delivery = %q, want %s", got, tt.want)
+			}
+			var events []string
+			for len(h.recorder.Events) > 0 {
+				events = append(events, <-h.recorder.Events)
+			}
+			if len(events) == 0 || !strings.Contains(events[len(events)-1], " "+tt.event+" ") {
+				t.Errorf("events = %q, want a %s event last", events, tt.event)
+			}
+		})
+	}
+}
+
+func TestCertificateRequestDeliveryCertificateError(t *testing.T) {
+	h := newTestHarness(t)
+	h.requests.VerifyDelivery = true
+	h.readyIssuer(nil)
+	certificateRequest := h.createRequest("delivery", newCSR(t, nil, "www.example.com"), func(certificateRequest *cmapi.CertificateRequest) {
+		certificateRequest.Annotations = map[string]string{cmapi.CertificateNameKey: "www"}
+	})
+	reads := &failingCertificateGets{Client: h.client}
+	h.requests.Client = reads
+	h.issue(certificateRequest)
+
+	reads.fail = true
+	if _, err := h.reconcile(certificateRequest); !errors.Is(err, errAPIUnavailable) {
+		t.Fatalf("err = %v, want %v", err, errAPIUnavailable)
+	}
+	if got := certificateRequest.Annotations[horizonissuer.DeliveryAnnotation]; got != deliveryPending {
+		t.Errorf("delivery = %q after failing to get the Certificate, want %s", got, deliveryPending)
+	}
+}
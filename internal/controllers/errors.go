@@ -0,0 +1,21 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var errPanic = errors.New("recovered from panic")
+
+// panicError logs a panic recovered during a reconciliation along with its
+// stack trace, and turns it into a transient error so that the object is
+// retried rather than taking the controller down.
+func panicError(ctx context.Context, recovered interface{}) error {
+	err := fmt.Errorf("%w: %v", errPanic, recovered)
+	ctrl.LoggerFrom(ctx).Error(err, "Panic during reconciliation", "stacktrace", string(debug.Stack()))
+	return err
+}
@@ -23,24 +23,57 @@ import (
 	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
 	horizonissuer "github.com/evertrust/horizon-issuer/internal/issuer/horizon"
 	issuerutil "github.com/evertrust/horizon-issuer/internal/issuer/util"
-	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"net/http"
+	"sort"
+	"sync"
 	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 )
 
 const (
 	defaultHealthCheckInterval = time.Minute
+	// healthCheckRetryInterval is the delay before checking an issuer again
+	// after a first failed health check, so that a brief blip is recovered
+	// from quickly. It doubles on each consecutive failure, up to
+	// defaultHealthCheckInterval.
+	healthCheckRetryInterval = 5 * time.Second
+	// maxListedProfiles bounds the number of profiles listed in the status
+	// of an issuer, to keep its size reasonable
+	maxListedProfiles = 50
+)
+
+const IssuerFinalizerName = horizonissuer.IssuerNamespace + "/issuer-finalizer"
+
+// Reasons of the Ready condition of issuers. Failures that Horizon errors
+// describe use the reasons of horizonissuer.ErrorReason instead.
+const (
+	ReasonHorizonReachable = "HorizonReachable"
+	// ReasonHorizonUnreachable is used when the health check failed for a
+	// reason that could not be classified.
+	ReasonHorizonUnreachable = "HorizonUnreachable"
+	ReasonSuspended          = "Suspended"
+	// ReasonFailingOpen is used when the health check failed for an issuer
+	// whose healthCheckPolicy is failOpen, which still submits requests.
+	ReasonFailingOpen = "FailingOpen"
+	ReasonFirstSeen   = "FirstSeen"
+	// ReasonError is used for other failures, such as missing credentials.
+	ReasonError = "Error"
 )
 
 var (
-	errGetAuthSecret        = errors.New("failed to get Secret containing Issuer credentials")
+	errGetCredentials       = errors.New("failed to get Issuer credentials")
 	errHealthCheckerBuilder = errors.New("failed to build the healthchecker")
 	errHealthCheckerCheck   = errors.New("healthcheck failed")
+	errIssuerFinalizer      = errors.New("failed to update the issuer finalizer")
 )
 
 // IssuerReconciler reconciles a Issuer object
@@ -50,6 +83,42 @@ type IssuerReconciler struct {
 	Scheme                   *runtime.Scheme
 	ClusterResourceNamespace string
 	HealthCheckerBuilder     horizonissuer.HealthCheckerBuilder
+	Clients                  *horizonissuer.ClientCache
+	// Finalizer controls whether a finalizer is added to issuers, ensuring
+	// that resources held for them are released before they are deleted.
+	Finalizer bool
+	// CredentialsDir is the directory in which ClusterIssuers may read
+	// credentials from files. File credentials are disabled when empty.
+	CredentialsDir string
+	// HealthCheckTTL is how long the result of a health check is reused by
+	// subsequent reconciles of the same issuer. Results are never reused
+	// when zero, nor for longer than defaultHealthCheckInterval.
+	HealthCheckTTL time.Duration
+	// Clock is the source of the times of health checks and conditions.
+	Clock clock.Clock
+
+	mu           sync.Mutex
+	healthChecks map[types.NamespacedName]healthCheck
+}
+
+// healthCheck is the result of the health check of an issuer. The client
+// the check was made with identifies the issuer spec and credentials it
+// applies to, as a new client is built whenever they change.
+type healthCheck struct {
+	client *horizonissuer.Client
+	// readClient is the client of the read credentials of the issuer, nil
+	// when it reads with its enroll credentials
+	readClient *horizonissuer.Client
+	checkedAt  time.Time
+	err        error
+	// profiles are the profiles available to the issuer, nil when they
+	// could not be listed
+	profiles []string
+	// failures is the number of consecutive failed health checks made with
+	// the same client, and retryAt when the issuer should be checked again
+	// after a failure
+	failures int
+	retryAt  time.Time
 }
 
 func (r *IssuerReconciler) newIssuer() (client.Object, error) {
@@ -74,6 +143,29 @@ func (r *IssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 			return ctrl.Result{}, fmt.Errorf("unexpected get error: %v", err)
 		}
 		log.Info("Not found. Ignoring.")
+		r.cleanup(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+
+	// examine DeletionTimestamp to determine if object is under deletion
+	if issuer.GetDeletionTimestamp().IsZero() {
+		if r.Finalizer && !controllerutil.ContainsFinalizer(issuer, IssuerFinalizerName) {
+			controllerutil.AddFinalizer(issuer, IssuerFinalizerName)
+			if err := r.Update(ctx, issuer); err != nil {
+				return ctrl.Result{}, fmt.Errorf("%w: %v", errIssuerFinalizer, err)
+			}
+		}
+	} else {
+		// The finalizer is removed even when disabled, as it may have been
+		// added by a previous configuration
+		r.cleanup(req.NamespacedName)
+		if controllerutil.ContainsFinalizer(issuer, IssuerFinalizerName) {
+			controllerutil.RemoveFinalizer(issuer, IssuerFinalizerName)
+			if err := r.Update(ctx, issuer); err != nil {
+				return ctrl.Result{}, fmt.Errorf("%w: %v", errIssuerFinalizer, err)
+			}
+		}
+		// Stop reconciliation as the item is being deleted
 		return ctrl.Result{}, nil
 	}
 
@@ -85,8 +177,22 @@ func (r *IssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 
 	// Always attempt to update the Ready condition
 	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicError(ctx, recovered)
+			result = ctrl.Result{}
+		}
 		if err != nil {
-			issuerutil.SetReadyCondition(issuerStatus, horizonapi.ConditionFalse, "Error", err.Error())
+			reason := ReasonError
+			if errors.Is(err, errHealthCheckerCheck) {
+				reason = ReasonHorizonUnreachable
+			}
+			issuerutil.SetReadyCondition(issuerStatus, r.Clock.Now(), issuer.GetGeneration(), horizonapi.ConditionFalse, horizonissuer.ErrorReason(err, reason), err.Error())
+		}
+		// Permanent errors are only retried once the issuer changes
+		if horizonissuer.IsPermanent(err) {
+			log.Error(err, "Permanent error. Not retrying.")
+			err = nil
+			result = ctrl.Result{}
 		}
 		if updateErr := r.Status().Update(ctx, issuer); updateErr != nil {
 			err = utilerrors.NewAggregate([]error{err, updateErr})
@@ -94,43 +200,207 @@ func (r *IssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 		}
 	}()
 
-	if ready := issuerutil.GetReadyCondition(issuerStatus); ready == nil {
-		issuerutil.SetReadyCondition(issuerStatus, horizonapi.ConditionUnknown, "FirstSeen", "First seen")
+	// Suspended issuers are not health checked, as Horizon may be down for
+	// maintenance, and are checked again once resumed
+	if issuerSpec.Suspend {
+		issuerutil.SetReadyCondition(issuerStatus, r.Clock.Now(), issuer.GetGeneration(), horizonapi.ConditionFalse, ReasonSuspended, "Issuer is suspended, new certificate requests are not submitted to Horizon")
 		return ctrl.Result{}, nil
 	}
 
-	secretName := types.NamespacedName{
-		Name: issuerSpec.AuthSecretName,
+	if ready := issuerutil.GetReadyCondition(issuerStatus); ready == nil {
+		issuerutil.SetReadyCondition(issuerStatus, r.Clock.Now(), issuer.GetGeneration(), horizonapi.ConditionUnknown, ReasonFirstSeen, "First seen")
+		return ctrl.Result{}, nil
 	}
 
+	var secretNamespace string
+	var credentialsDir string
 	switch issuer.(type) {
 	case *horizonapi.Issuer:
-		secretName.Namespace = req.Namespace
+		secretNamespace = req.Namespace
 	case *horizonapi.ClusterIssuer:
-		secretName.Namespace = r.ClusterResourceNamespace
+		secretNamespace = r.ClusterResourceNamespace
+		credentialsDir = r.CredentialsDir
 	default:
 		log.Error(fmt.Errorf("unexpected issuer type: %t", issuer), "Not retrying.")
 		return ctrl.Result{}, nil
 	}
 
-	var secret corev1.Secret
-	if err := r.Get(ctx, secretName, &secret); err != nil {
-		return ctrl.Result{}, fmt.Errorf("%w, secret name: %s, reason: %v", errGetAuthSecret, secretName, err)
+	credentials, err := horizonissuer.CredentialSourceFromIssuer(r.Client, issuerSpec, secretNamespace, credentialsDir, horizonissuer.CredentialsEnroll)
+	if err != nil {
+		return ctrl.Result{}, horizonissuer.WrapError(errGetCredentials, err)
 	}
 
-	checker, err := r.HealthCheckerBuilder(issuerSpec, secret.Data)
+	horizonClient, err := r.Clients.Get(ctx, issuer, issuerSpec, credentials, horizonissuer.CredentialsEnroll)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("%w: %v", errHealthCheckerBuilder, err)
+		return ctrl.Result{}, horizonissuer.WrapError(errHealthCheckerBuilder, err)
+	}
+
+	// Read credentials are checked along with the enroll ones
+	var readClient *horizonissuer.Client
+	if horizonissuer.SeparateCredentials(issuerSpec) {
+		readCredentials, err := horizonissuer.CredentialSourceFromIssuer(r.Client, issuerSpec, secretNamespace, credentialsDir, horizonissuer.CredentialsRead)
+		if err != nil {
+			return ctrl.Result{}, horizonissuer.WrapError(errGetCredentials, err)
+		}
+		readClient, err = r.Clients.Get(ctx, issuer, issuerSpec, readCredentials, horizonissuer.CredentialsRead)
+		if err != nil {
+			return ctrl.Result{}, horizonissuer.WrapError(errHealthCheckerBuilder, err)
+		}
+	}
+
+	key := client.ObjectKeyFromObject(issuer)
+	check, cached := r.cachedHealthCheck(key, horizonClient, readClient)
+	if !cached {
+		checker, err := r.HealthCheckerBuilder(horizonClient, issuerSpec)
+		if err != nil {
+			return ctrl.Result{}, horizonissuer.WrapError(errHealthCheckerBuilder, err)
+		}
+		checker.ReadClient = readClient
+		err = checker.Check()
+		var profiles []string
+		if err == nil {
+			profiles = listProfiles(ctx, horizonClient, issuerSpec.Module)
+		}
+		check = r.recordHealthCheck(key, horizonClient, readClient, err, profiles)
 	}
 
-	if err := checker.Check(); err != nil {
-		return ctrl.Result{}, fmt.Errorf("%w: %v", errHealthCheckerCheck, err)
+	// Failures are checked again sooner than successes, backing off as they
+	// persist so as not to hammer a Horizon that is down
+	if check.err != nil {
+		err := horizonissuer.WrapError(errHealthCheckerCheck, check.err)
+		if horizonissuer.IsPermanent(err) {
+			return ctrl.Result{}, err
+		}
+		log.Error(err, "Health check failed", "failures", check.failures)
+		if issuerSpec.HealthCheckPolicy == horizonapi.HealthCheckPolicyFailOpen {
+			issuerutil.SetReadyCondition(issuerStatus, r.Clock.Now(), issuer.GetGeneration(), horizonapi.ConditionFalse, ReasonFailingOpen, fmt.Sprintf("Health check failed, new certificate requests are still submitted: %v", err))
+		} else {
+			issuerutil.SetReadyCondition(issuerStatus, r.Clock.Now(), issuer.GetGeneration(), horizonapi.ConditionFalse, horizonissuer.ErrorReason(err, ReasonHorizonUnreachable), err.Error())
+		}
+		return ctrl.Result{RequeueAfter: check.retryAt.Sub(r.Clock.Now())}, nil
+	}
+
+	if check.profiles != nil {
+		issuerStatus.Profiles = check.profiles
 	}
 
-	issuerutil.SetReadyCondition(issuerStatus, horizonapi.ConditionTrue, "Success", "Health check succeeded")
+	issuerutil.SetReadyCondition(issuerStatus, r.Clock.Now(), issuer.GetGeneration(), horizonapi.ConditionTrue, ReasonHorizonReachable, "Health check succeeded")
 	return ctrl.Result{RequeueAfter: defaultHealthCheckInterval}, nil
 }
 
+// cachedHealthCheck returns the result of the last health check of an
+// issuer, if it was made with the same clients recently enough.
+func (r *IssuerReconciler) cachedHealthCheck(issuer types.NamespacedName, horizonClient *horizonissuer.Client, readClient *horizonissuer.Client) (healthCheck, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ttl := r.HealthCheckTTL
+	if ttl > defaultHealthCheckInterval {
+		ttl = defaultHealthCheckInterval
+	}
+	check, ok := r.healthChecks[issuer]
+	if !ok || check.client != horizonClient || check.readClient != readClient || r.Clock.Since(check.checkedAt) >= ttl {
+		return healthCheck{}, false
+	}
+	if check.err != nil && !r.Clock.Now().Before(check.retryAt) {
+		return healthCheck{}, false
+	}
+	return check, true
+}
+
+func (r *IssuerReconciler) recordHealthCheck(issuer types.NamespacedName, horizonClient *horizonissuer.Client, readClient *horizonissuer.Client, err error, profiles []string) healthCheck {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.healthChecks == nil {
+		r.healthChecks = make(map[types.NamespacedName]healthCheck)
+	}
+	check := healthCheck{client: horizonClient, readClient: readClient, checkedAt: r.Clock.Now(), err: err, profiles: profiles}
+	if err != nil {
+		if previous, ok := r.healthChecks[issuer]; ok && previous.client == horizonClient && previous.readClient == readClient && previous.err != nil {
+			check.failures = previous.failures
+		}
+		check.failures++
+		check.retryAt = check.checkedAt.Add(healthCheckRetryDelay(check.failures))
+	}
+	r.healthChecks[issuer] = check
+	return check
+}
+
+// healthCheckRetryDelay returns the delay before checking an issuer again
+// after the given number of consecutive failed health checks.
+func healthCheckRetryDelay(failures int) time.Duration {
+	delay := healthCheckRetryInterval
+	for i := 1; i < failures && delay < defaultHealthCheckInterval; i++ {
+		delay *= 2
+	}
+	if delay > defaultHealthCheckInterval {
+		delay = defaultHealthCheckInterval
+	}
+	return delay
+}
+
+// listProfiles returns the first profiles available to an issuer, sorted by
+// name. Listing them is informative, so failures are only logged and nil is
+// returned, keeping the profiles previously listed.
+func listProfiles(ctx context.Context, horizonClient *horizonissuer.Client, module string) []string {
+	profiles, err := horizonClient.Profiles(ctx, module)
+	if err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "Unable to list the profiles available to the issuer")
+		return nil
+	}
+	sort.Strings(profiles)
+	if len(profiles) > maxListedProfiles {
+		profiles = profiles[:maxListedProfiles]
+	}
+	return profiles
+}
+
+// cleanup releases the resources held for an issuer.
+func (r *IssuerReconciler) cleanup(issuer types.NamespacedName) {
+	if r.Clients != nil {
+		r.Clients.Evict(issuer)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.healthChecks, issuer)
+}
+
+// healthCheckCounts returns the number of issuers with a recorded health
+// check, and how many of them failed it.
+func (r *IssuerReconciler) healthCheckCounts() (checked int, failing int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, check := range r.healthChecks {
+		checked++
+		if check.err != nil {
+			failing++
+		}
+	}
+	return checked, failing
+}
+
+// IssuersReady returns a readiness check failing when every issuer health
+// checked by the reconcilers failed its last health check. It passes when no
+// issuer was checked, for instance when there is none or on replicas that
+// are not the leader, as the controller itself is not at fault.
+func IssuersReady(reconcilers ...*IssuerReconciler) healthz.Checker {
+	return func(_ *http.Request) error {
+		var checked, failing int
+		for _, r := range reconcilers {
+			c, f := r.healthCheckCounts()
+			checked += c
+			failing += f
+		}
+		if checked > 0 && failing == checked {
+			return fmt.Errorf("all %d issuers are failing their health checks", checked)
+		}
+		return nil
+	}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *IssuerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	issuerType, err := r.newIssuer()
@@ -139,5 +409,6 @@ func (r *IssuerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(issuerType).
+		WithOptions(controller.Options{RecoverPanic: true}).
 		Complete(r)
 }
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	horizonissuer "github.com/evertrust/horizon-issuer/internal/issuer/horizon"
+	"github.com/evertrust/horizon-issuer/internal/issuer/horizon/horizontest"
+	issuerutil "github.com/evertrust/horizon-issuer/internal/issuer/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestIssuerReady(t *testing.T) {
+	h := newTestHarness(t)
+	issuer := h.readyIssuer(nil)
+
+	if !controllerutil.ContainsFinalizer(issuer, IssuerFinalizerName) {
+		t.Errorf("finalizers = %v, want %s", issuer.Finalizers, IssuerFinalizerName)
+	}
+	ready := issuerutil.GetReadyCondition(&issuer.Status)
+	if ready.Reason != ReasonHorizonReachable {
+		t.Errorf("Ready reason = %s, want %s", ready.Reason, ReasonHorizonReachable)
+	}
+	if len(issuer.Status.Profiles) != 1 || issuer.Status.Profiles[0] != testProfile {
+		t.Errorf("profiles = %v, want [%s]", issuer.Status.Profiles, testProfile)
+	}
+}
+
+func TestIssuerNotReady(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    func(*horizonapi.IssuerSpec)
+		failure *horizontest.Failure
+		reason  string
+	}{
+		{
+			name:    "rejected credentials",
+			failure: &horizontest.Failure{StatusCode: 401, Code: "SEC-AUTH", Message: "Invalid credentials"},
+			reason:  horizonissuer.ReasonAuthFailed,
+		},
+		{
+			name:    "unavailable Horizon",
+			failure: &horizontest.Failure{StatusCode: 503, Code: "UNAVAILABLE", Message: "Maintenance"},
+			reason:  ReasonHorizonUnreachable,
+		},
+		{
+			name:   "suspended issuer",
+			spec:   func(spec *horizonapi.IssuerSpec) { spec.Suspend = true },
+			reason: ReasonSuspended,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHarness(t)
+			if tt.failure != nil {
+				h.horizon.Fail(horizontest.EndpointSelf, *tt.failure)
+			}
+			issuer := h.createIssuer(tt.spec)
+			ready := h.settleIssuer(issuer)
+			if ready.Status != horizonapi.ConditionFalse || ready.Reason != tt.reason {
+				t.Errorf("Ready condition = %s/%s (%s), want False/%s", ready.Status, ready.Reason, ready.Message, tt.reason)
+			}
+		})
+	}
+}
+
+func TestIssuerDeletion(t *testing.T) {
+	h := newTestHarness(t)
+	issuer := h.readyIssuer(nil)
+
+	h.delete(issuer)
+	if _, err := h.reconcileIssuer(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.client.Get(h.ctx, client.ObjectKeyFromObject(issuer), issuer); err == nil && controllerutil.ContainsFinalizer(issuer, IssuerFinalizerName) {
+		t.Errorf("finalizer %s was not removed", IssuerFinalizerName)
+	}
+}
+
+func TestHealthCheckRetryDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: 5 * time.Second},
+		{failures: 2, want: 10 * time.Second},
+		{failures: 3, want: 20 * time.Second},
+		{failures: 4, want: 40 * time.Second},
+		{failures: 5, want: time.Minute},
+		{failures: 20, want: time.Minute},
+	}
+	for _, tt := range tests {
+		if got := healthCheckRetryDelay(tt.failures); got != tt.want {
+			t.Errorf("healthCheckRetryDelay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestIssuerHealthCheckTTL(t *testing.T) {
+	h := newTestHarness(t)
+	h.issuers.HealthCheckTTL = 30 * time.Second
+	issuer := h.readyIssuer(nil)
+	calls := h.horizon.Calls(horizontest.EndpointSelf)
+
+	h.clock.Step(30*time.Second - time.Nanosecond)
+	if _, err := h.reconcileIssuer(); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.horizon.Calls(horizontest.EndpointSelf); got != calls {
+		t.Errorf("issuer was checked again %d times within the TTL", got-calls)
+	}
+
+	h.clock.Step(time.Nanosecond)
+	if _, err := h.reconcileIssuer(); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.horizon.Calls(horizontest.EndpointSelf); got != calls+1 {
+		t.Errorf("issuer was checked again %d times once the TTL elapsed, want 1", got-calls)
+	}
+	h.get(issuer)
+	if ready := issuerutil.GetReadyCondition(&issuer.Status); ready.Status != horizonapi.ConditionTrue {
+		t.Errorf("Ready condition = %s/%s, want True", ready.Status, ready.Reason)
+	}
+}
+
+func TestIssuerHealthCheckBackoff(t *testing.T) {
+	h := newTestHarness(t)
+	h.issuers.HealthCheckTTL = time.Minute
+	h.horizon.Fail(horizontest.EndpointSelf, horizontest.Failure{StatusCode: 503, Code: "UNAVAILABLE", Message: "Maintenance"})
+	issuer := h.createIssuer(nil)
+	failedAt := h.clock.Now()
+	if ready := h.settleIssuer(issuer); ready.Status != horizonapi.ConditionFalse {
+		t.Fatalf("Ready condition = %s/%s, want False", ready.Status, ready.Reason)
+	}
+	if ready := issuerutil.GetReadyCondition(&issuer.Status); ready.LastTransitionTime == nil || !ready.LastTransitionTime.Time.Equal(failedAt) {
+		t.Errorf("LastTransitionTime = %v, want the time of the fake clock %s", ready.LastTransitionTime, failedAt)
+	}
+	calls := h.horizon.Calls(horizontest.EndpointSelf)
+
+	// Reconciles before the retry reuse the failure and requeue for the rest
+	// of the delay
+	h.clock.Step(4 * time.Second)
+	result, err := h.reconcileIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter != time.Second {
+		t.Errorf("RequeueAfter = %s before the retry, want 1s", result.RequeueAfter)
+	}
+	if got := h.horizon.Calls(horizontest.EndpointSelf); got != calls {
+		t.Errorf("issuer was checked again %d times before the retry", got-calls)
+	}
+
+	for _, delay := range []time.Duration{10 * time.Second, 20 * time.Second} {
+		h.clock.Step(result.RequeueAfter)
+		if result, err = h.reconcileIssuer(); err != nil {
+			t.Fatal(err)
+		}
+		if result.RequeueAfter != delay {
+			t.Errorf("RequeueAfter = %s, want %s", result.RequeueAfter, delay)
+		}
+	}
+
+	h.horizon.Recover(horizontest.EndpointSelf)
+	h.clock.Step(result.RequeueAfter)
+	if result, err = h.reconcileIssuer(); err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter != time.Minute {
+		t.Errorf("RequeueAfter = %s once recovered, want the health check interval", result.RequeueAfter)
+	}
+	h.get(issuer)
+	ready := issuerutil.GetReadyCondition(&issuer.Status)
+	if ready.Status != horizonapi.ConditionTrue || !ready.LastTransitionTime.Time.Equal(h.clock.Now()) {
+		t.Errorf("Ready condition = %s at %v, want True at %s", ready.Status, ready.LastTransitionTime, h.clock.Now())
+	}
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"context"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	issuerutil "github.com/evertrust/horizon-issuer/internal/issuer/util"
+	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// issuerRefField indexes CertificateRequests by the issuer they reference,
+// so that the requests waiting for an issuer can be listed once it is ready.
+const issuerRefField = ".spec.issuerRef"
+
+// issuerRefKey identifies an issuer in the issuerRefField index, namespace
+// being empty for ClusterIssuers.
+func issuerRefKey(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// resolveIssuerRef returns the kind and name of the issuer referenced by a
+// CertificateRequest, the namespace being empty for ClusterIssuers. An empty
+// kind references an Issuer, as in cert-manager. It returns false for
+// references to other groups or kinds, which are not ours to handle.
+func resolveIssuerRef(certificateRequest *cmapi.CertificateRequest) (string, types.NamespacedName, bool) {
+	ref := certificateRequest.Spec.IssuerRef
+	if ref.Group != horizonapi.GroupVersion.Group {
+		return "", types.NamespacedName{}, false
+	}
+	switch ref.Kind {
+	case "", "Issuer":
+		return "Issuer", types.NamespacedName{Namespace: certificateRequest.Namespace, Name: ref.Name}, true
+	case "ClusterIssuer":
+		return "ClusterIssuer", types.NamespacedName{Name: ref.Name}, true
+	}
+	return "", types.NamespacedName{}, false
+}
+
+// indexIssuerRef is the indexer of issuerRefField, only indexing requests
+// referencing our issuers.
+func indexIssuerRef(obj client.Object) []string {
+	certificateRequest, ok := obj.(*cmapi.CertificateRequest)
+	if !ok {
+		return nil
+	}
+	kind, name, ok := resolveIssuerRef(certificateRequest)
+	if !ok {
+		return nil
+	}
+	return []string{issuerRefKey(kind, name.Namespace, name.Name)}
+}
+
+// becameReady only lets through issuer updates turning their Ready
+// condition to True.
+var becameReady = predicate.Funcs{
+	CreateFunc:  func(event.CreateEvent) bool { return false },
+	DeleteFunc:  func(event.DeleteEvent) bool { return false },
+	GenericFunc: func(event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		_, oldStatus, err := issuerutil.GetSpecAndStatus(e.ObjectOld)
+		if err != nil {
+			return false
+		}
+		_, newStatus, err := issuerutil.GetSpecAndStatus(e.ObjectNew)
+		if err != nil {
+			return false
+		}
+		return !issuerutil.IsReady(oldStatus) && issuerutil.IsReady(newStatus)
+	},
+}
+
+// waitingRequests maps an issuer of the given kind to the CertificateRequests
+// referencing it that are neither issued nor rejected, so that they are
+// reconciled as soon as the issuer becomes ready instead of after their
+// backoff.
+func (r *CertificateRequestReconciler) waitingRequests(kind string) handler.MapFunc {
+	return func(issuer client.Object) []reconcile.Request {
+		namespace := issuer.GetNamespace()
+		if kind == "ClusterIssuer" {
+			namespace = ""
+		}
+
+		var certificateRequests cmapi.CertificateRequestList
+		if err := r.List(context.Background(), &certificateRequests, client.MatchingFields{
+			issuerRefField: issuerRefKey(kind, namespace, issuer.GetName()),
+		}); err != nil {
+			ctrl.Log.Error(err, "Unable to list the certificate requests waiting for an issuer", "kind", kind, "name", issuer.GetName())
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for i := range certificateRequests.Items {
+			certificateRequest := &certificateRequests.Items[i]
+			if !isWaiting(certificateRequest) {
+				continue
+			}
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: certificateRequest.Namespace,
+				Name:      certificateRequest.Name,
+			}})
+		}
+		return requests
+	}
+}
+
+// isWaiting returns whether a CertificateRequest is still to be issued.
+func isWaiting(certificateRequest *cmapi.CertificateRequest) bool {
+	ready := cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady)
+	if ready == nil {
+		return true
+	}
+	if ready.Status == cmmeta.ConditionTrue {
+		return false
+	}
+	switch ready.Reason {
+	case cmapi.CertificateRequestReasonFailed, cmapi.CertificateRequestReasonDenied, ReasonRevoked:
+		return false
+	}
+	return true
+}
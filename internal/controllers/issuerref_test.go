@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	horizonissuer "github.com/evertrust/horizon-issuer/internal/issuer/horizon"
+	issuerutil "github.com/evertrust/horizon-issuer/internal/issuer/util"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// issuerRefs are issuerRefs of each combination of group and kind, with the
+// issuer they resolve to within the "default" namespace.
+var issuerRefs = []struct {
+	name     string
+	group    string
+	kind     string
+	wantKind string
+	wantName types.NamespacedName
+	wantOk   bool
+}{
+	{name: "Issuer", group: horizonapi.GroupVersion.Group, kind: "Issuer", wantKind: "Issuer", wantName: types.NamespacedName{Namespace: "default", Name: "horizon"}, wantOk: true},
+	{name: "empty kind", group: horizonapi.GroupVersion.Group, kind: "", wantKind: "Issuer", wantName: types.NamespacedName{Namespace: "default", Name: "horizon"}, wantOk: true},
+	{name: "ClusterIssuer", group: horizonapi.GroupVersion.Group, kind: "ClusterIssuer", wantKind: "ClusterIssuer", wantName: types.NamespacedName{Name: "horizon"}, wantOk: true},
+	{name: "other kind of our group", group: horizonapi.GroupVersion.Group, kind: "IssuerList"},
+	{name: "unknown kind of our group", group: horizonapi.GroupVersion.Group, kind: "Isuer"},
+	{name: "Issuer of a foreign group", group: "cert-manager.io", kind: "Issuer"},
+	{name: "ClusterIssuer of a foreign group", group: "cert-manager.io", kind: "ClusterIssuer"},
+	{name: "empty group", kind: "Issuer"},
+}
+
+// issuerRefRequest returns a CertificateRequest of the "default" namespace
+// referencing the "horizon" issuer of group and kind.
+func issuerRefRequest(group string, kind string) *cmapi.CertificateRequest {
+	return &cmapi.CertificateRequest{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "request"},
+		Spec: cmapi.CertificateRequestSpec{
+			IssuerRef: cmmeta.ObjectReference{Group: group, Kind: kind, Name: "horizon"},
+		},
+	}
+}
+
+func TestResolveIssuerRef(t *testing.T) {
+	for _, tt := range issuerRefs {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, name, ok := resolveIssuerRef(issuerRefRequest(tt.group, tt.kind))
+			if kind != tt.wantKind || name != tt.wantName || ok != tt.wantOk {
+				t.Errorf("resolveIssuerRef() = %q, %v, %v, want %q, %v, %v", kind, name, ok, tt.wantKind, tt.wantName, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestIndexIssuerRef(t *testing.T) {
+	for _, tt := range issuerRefs {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := indexIssuerRef(issuerRefRequest(tt.group, tt.kind))
+			if !tt.wantOk {
+				if len(keys) != 0 {
+					t.Errorf("indexIssuerRef() = %v, want no key", keys)
+				}
+				return
+			}
+			if want := issuerRefKey(tt.wantKind, tt.wantName.Namespace, tt.wantName.Name); len(keys) != 1 || keys[0] != want {
+				t.Errorf("indexIssuerRef() = %v, want [%s]", keys, want)
+			}
+		})
+	}
+}
+
+func TestIssuerFromRequest(t *testing.T) {
+	h := newTestHarness(t)
+	// ClusterIssuers are shared by the tests of an API server
+	shared, clusterOnly, namespacedOnly := "shared-"+h.namespace, "cluster-"+h.namespace, "namespaced-"+h.namespace
+	for _, name := range []string{shared, clusterOnly} {
+		clusterIssuer := &horizonapi.ClusterIssuer{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       horizonapi.IssuerSpec{URL: h.horizon.URL, Profile: "Cluster"},
+		}
+		h.create(clusterIssuer)
+		t.Cleanup(func() { _ = h.client.Delete(context.Background(), clusterIssuer) })
+	}
+	for _, name := range []string{shared, namespacedOnly} {
+		h.create(&horizonapi.Issuer{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       horizonapi.IssuerSpec{URL: h.horizon.URL, Profile: "Namespaced"},
+		})
+	}
+
+	tests := []struct {
+		name          string
+		kind          string
+		issuerName    string
+		wantProfile   string
+		wantErr       error
+		wantPermanent bool
+		wantHint      string
+	}{
+		{name: "Issuer sharing its name with a ClusterIssuer", kind: "Issuer", issuerName: shared, wantProfile: "Namespaced"},
+		{name: "empty kind", issuerName: shared, wantProfile: "Namespaced"},
+		{name: "ClusterIssuer sharing its name with an Issuer", kind: "ClusterIssuer", issuerName: shared, wantProfile: "Cluster"},
+		{name: "missing Issuer named like a ClusterIssuer", kind: "Issuer", issuerName: clusterOnly, wantErr: errGetIssuer, wantHint: "set issuerRef.kind to ClusterIssuer"},
+		{name: "missing ClusterIssuer named like an Issuer", kind: "ClusterIssuer", issuerName: namespacedOnly, wantErr: errGetIssuer, wantHint: "set issuerRef.kind to Issuer"},
+		{name: "missing issuer", kind: "Issuer", issuerName: "missing", wantErr: errGetIssuer},
+		{name: "namespace-qualified name", kind: "Issuer", issuerName: h.namespace + "/" + shared, wantErr: errIssuerRef, wantPermanent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificateRequest := &cmapi.CertificateRequest{
+				ObjectMeta: metav1.ObjectMeta{Namespace: h.namespace, Name: "request"},
+				Spec: cmapi.CertificateRequestSpec{
+					IssuerRef: cmmeta.ObjectReference{Group: horizonapi.GroupVersion.Group, Kind: tt.kind, Name: tt.issuerName},
+				},
+			}
+			issuer, err := h.requests.issuerFromRequest(h.ctx, certificateRequest)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("issuerFromRequest() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if horizonissuer.IsPermanent(err) != tt.wantPermanent {
+					t.Errorf("issuerFromRequest() error = %v, permanent: %v", err, tt.wantPermanent)
+				}
+				if !strings.Contains(err.Error(), tt.wantHint) || (tt.wantHint == "" && strings.Contains(err.Error(), "issuerRef.kind")) {
+					t.Errorf("issuerFromRequest() error = %v, want the hint %q", err, tt.wantHint)
+				}
+				return
+			}
+			issuerSpec, _, err := issuerutil.GetSpecAndStatus(issuer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if issuerSpec.Profile != tt.wantProfile {
+				t.Errorf("resolved the issuer of profile %s, want %s", issuerSpec.Profile, tt.wantProfile)
+			}
+		})
+	}
+}
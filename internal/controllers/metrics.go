@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	horizonissuer "github.com/evertrust/horizon-issuer/internal/issuer/horizon"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// pendingRequests counts the CertificateRequests submitted to Horizon that
+// are not issued yet, by issuer.
+var pendingRequests = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "horizon_issuer_pending_certificate_requests",
+		Help: "Number of CertificateRequests submitted to Horizon and waiting to be issued, by issuer.",
+	},
+	[]string{"issuer_kind", "issuer_namespace", "issuer_name"},
+)
+
+// issuedCertificates counts the certificates issued by Horizon, by issuer.
+var issuedCertificates = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "horizon_issuer_issued_certificates_total",
+		Help: "Number of certificates issued by Horizon, counted once written to their Secret when delivery is verified, by issuer.",
+	},
+	[]string{"issuer_kind", "issuer_namespace", "issuer_name"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(pendingRequests, issuedCertificates)
+}
+
+// issuerLabels returns the labels identifying the issuer of a
+// CertificateRequest in metrics.
+func issuerLabels(certificateRequest *cmapi.CertificateRequest) prometheus.Labels {
+	labels := prometheus.Labels{
+		"issuer_kind":      certificateRequest.Spec.IssuerRef.Kind,
+		"issuer_namespace": "",
+		"issuer_name":      certificateRequest.Spec.IssuerRef.Name,
+	}
+	if certificateRequest.Spec.IssuerRef.Kind != "ClusterIssuer" {
+		labels["issuer_namespace"] = certificateRequest.Namespace
+	}
+	return labels
+}
+
+// trackPending updates the pending requests gauge with the state in which
+// a reconcile left a CertificateRequest, or nil if it no longer exists.
+func (r *CertificateRequestReconciler) trackPending(key types.NamespacedName, certificateRequest *cmapi.CertificateRequest) {
+	r.pendingMu.Lock()
+	defer r.pendingMu.Unlock()
+
+	if labels, ok := r.pending[key]; ok {
+		pendingRequests.With(labels).Dec()
+		delete(r.pending, key)
+	}
+	if certificateRequest == nil || !isPending(certificateRequest) {
+		return
+	}
+
+	labels := issuerLabels(certificateRequest)
+	if r.pending == nil {
+		r.pending = make(map[types.NamespacedName]prometheus.Labels)
+	}
+	pendingRequests.With(labels).Inc()
+	r.pending[key] = labels
+}
+
+// isPending returns whether a CertificateRequest was submitted to Horizon
+// and is neither issued nor rejected yet.
+func isPending(certificateRequest *cmapi.CertificateRequest) bool {
+	if _, ok := certificateRequest.Annotations[horizonissuer.RequestIdAnnotation]; !ok {
+		return false
+	}
+	return certificateRequest.DeletionTimestamp.IsZero() && isWaiting(certificateRequest)
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"reflect"
+	"strings"
+
+	horizonissuer "github.com/evertrust/horizon-issuer/internal/issuer/horizon"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// managedAnnotations are the annotations written by the controller itself,
+// whose changes bring no new information to act upon.
+var managedAnnotations = map[string]bool{
+	horizonissuer.RequestIdAnnotation:             true,
+	horizonissuer.CorrelationIdAnnotation:         true,
+	horizonissuer.CertificateUrlAnnotation:        true,
+	horizonissuer.SerialNumberAnnotation:          true,
+	horizonissuer.NotAfterAnnotation:              true,
+	horizonissuer.OcspServersAnnotation:           true,
+	horizonissuer.CrlDistributionPointsAnnotation: true,
+	horizonissuer.RenewalTimeAnnotation:           true,
+	horizonissuer.CertificateIdAnnotation:         true,
+	horizonissuer.ProfileAnnotation:               true,
+	horizonissuer.IssuedAtAnnotation:              true,
+	horizonissuer.DeliveryAnnotation:              true,
+}
+
+// ignoreOwnUpdates drops the CertificateRequest updates only made of the
+// writes of the controller, that is its annotations, Ready condition,
+// certificate and failure time, so that they don't trigger a reconcile
+// polling Horizon ahead of the requeue the controller asked for. Updates of
+// the spec, of other annotations, of finalizers, of the deletion timestamp,
+// and of other conditions such as approvals are let through.
+var ignoreOwnUpdates = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldRequest, ok := e.ObjectOld.(*cmapi.CertificateRequest)
+		if !ok {
+			return true
+		}
+		newRequest, ok := e.ObjectNew.(*cmapi.CertificateRequest)
+		if !ok {
+			return true
+		}
+
+		return oldRequest.Generation != newRequest.Generation ||
+			!newRequest.DeletionTimestamp.Equal(oldRequest.DeletionTimestamp) ||
+			!reflect.DeepEqual(oldRequest.Finalizers, newRequest.Finalizers) ||
+			!reflect.DeepEqual(unmanagedAnnotations(oldRequest.Annotations), unmanagedAnnotations(newRequest.Annotations)) ||
+			!reflect.DeepEqual(otherConditions(oldRequest.Status.Conditions), otherConditions(newRequest.Status.Conditions))
+	},
+}
+
+// unmanagedAnnotations returns the annotations not written by the controller.
+func unmanagedAnnotations(annotations map[string]string) map[string]string {
+	unmanaged := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		if managedAnnotations[key] || strings.HasPrefix(key, horizonissuer.ThumbprintAnnotationPrefix) {
+			continue
+		}
+		unmanaged[key] = value
+	}
+	return unmanaged
+}
+
+// otherConditions returns the conditions other than Ready, which is owned by
+// the controller.
+func otherConditions(conditions []cmapi.CertificateRequestCondition) []cmapi.CertificateRequestCondition {
+	var others []cmapi.CertificateRequestCondition
+	for _, condition := range conditions {
+		if condition.Type != cmapi.CertificateRequestConditionReady {
+			others = append(others, condition)
+		}
+	}
+	return others
+}
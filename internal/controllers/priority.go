@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// RequestPriority is the class of CertificateRequests processed first.
+type RequestPriority string
+
+// Supported request priorities
+const (
+	PriorityNone      RequestPriority = "none"
+	PriorityRenewals  RequestPriority = "renewals"
+	PriorityIssuances RequestPriority = "issuances"
+)
+
+// deprioritizedDelay is how long CertificateRequests that are not of the
+// prioritized class wait before being queued when they are first seen, so
+// that the requests of that class seen meanwhile, such as when the cache is
+// filled at startup, are processed first. Only the requests of that class
+// processed within the delay go first, as controller-runtime v0.10 doesn't
+// let controllers replace their work queue with a priority queue.
+const deprioritizedDelay = 10 * time.Second
+
+// ParseRequestPriority returns the request priority with the given name.
+func ParseRequestPriority(name string) (RequestPriority, error) {
+	switch priority := RequestPriority(name); priority {
+	case PriorityNone, PriorityRenewals, PriorityIssuances:
+		return priority, nil
+	}
+	return "", fmt.Errorf("unknown request priority %q, expected one of none, renewals or issuances", name)
+}
+
+// isRenewal returns whether a CertificateRequest renews a Certificate, that
+// is whether it was made for a revision of its Certificate after the first.
+// Requests made without a Certificate are first issuances.
+func isRenewal(certificateRequest *cmapi.CertificateRequest) bool {
+	revision, err := strconv.Atoi(certificateRequest.Annotations[cmapi.CertificateRequestRevisionAnnotationKey])
+	return err == nil && revision > 1
+}
+
+// prioritized returns whether a CertificateRequest is of the class processed
+// first under a priority.
+func (priority RequestPriority) prioritized(certificateRequest *cmapi.CertificateRequest) bool {
+	switch priority {
+	case PriorityRenewals:
+		return isRenewal(certificateRequest)
+	case PriorityIssuances:
+		return !isRenewal(certificateRequest)
+	}
+	return true
+}
+
+// enabled returns whether requests are prioritized, their events being then
+// queued by prioritizedHandler.
+func (priority RequestPriority) enabled() bool {
+	return priority != "" && priority != PriorityNone
+}
+
+// onlyIf lets all events through when enabled is true, and none otherwise.
+func onlyIf(enabled bool) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(client.Object) bool {
+		return enabled
+	})
+}
+
+// prioritizedHandler queues the CertificateRequests of the prioritized class
+// right away when they are created, and the other ones after
+// deprioritizedDelay. Updates, such as approvals, and deletions are always
+// queued right away, as are requeues asked for by reconciles.
+type prioritizedHandler struct {
+	priority RequestPriority
+}
+
+var _ handler.EventHandler = prioritizedHandler{}
+
+func (h prioritizedHandler) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	h.enqueue(e.Object, q)
+}
+
+func (h prioritizedHandler) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	if e.ObjectNew != nil {
+		q.Add(requestFor(e.ObjectNew))
+	}
+}
+
+func (h prioritizedHandler) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	if e.Object != nil {
+		q.Add(requestFor(e.Object))
+	}
+}
+
+func (h prioritizedHandler) Generic(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+	if e.Object != nil {
+		q.Add(requestFor(e.Object))
+	}
+}
+
+func (h prioritizedHandler) enqueue(obj client.Object, q workqueue.RateLimitingInterface) {
+	certificateRequest, ok := obj.(*cmapi.CertificateRequest)
+	if !ok {
+		return
+	}
+	if h.priority.prioritized(certificateRequest) {
+		q.Add(requestFor(certificateRequest))
+	} else {
+		q.AddAfter(requestFor(certificateRequest), deprioritizedDelay)
+	}
+}
+
+func requestFor(obj client.Object) reconcile.Request {
+	return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}}
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"testing"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestPrioritizedHandler(t *testing.T) {
+	renewal := &cmapi.CertificateRequest{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "test",
+		Name:        "renewal",
+		Annotations: map[string]string{cmapi.CertificateRequestRevisionAnnotationKey: "2"},
+	}}
+	issuance := &cmapi.CertificateRequest{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "test",
+		Name:        "issuance",
+		Annotations: map[string]string{cmapi.CertificateRequestRevisionAnnotationKey: "1"},
+	}}
+
+	tests := []struct {
+		name       string
+		send       func(h prioritizedHandler, q workqueue.RateLimitingInterface)
+		wantQueued bool
+	}{
+		{
+			name: "created request of the prioritized class",
+			send: func(h prioritizedHandler, q workqueue.RateLimitingInterface) {
+				h.Create(event.CreateEvent{Object: renewal}, q)
+			},
+			wantQueued: true,
+		},
+		{
+			name: "created request of another class",
+			send: func(h prioritizedHandler, q workqueue.RateLimitingInterface) {
+				h.Create(event.CreateEvent{Object: issuance}, q)
+			},
+		},
+		{
+			name: "updated request of another class",
+			send: func(h prioritizedHandler, q workqueue.RateLimitingInterface) {
+				h.Update(event.UpdateEvent{ObjectOld: issuance, ObjectNew: issuance}, q)
+			},
+			wantQueued: true,
+		},
+		{
+			name: "deleted request of another class",
+			send: func(h prioritizedHandler, q workqueue.RateLimitingInterface) {
+				h.Delete(event.DeleteEvent{Object: issuance}, q)
+			},
+			wantQueued: true,
+		},
+		{
+			name: "generic event for a request of another class",
+			send: func(h prioritizedHandler, q workqueue.RateLimitingInterface) {
+				h.Generic(event.GenericEvent{Object: issuance}, q)
+			},
+			wantQueued: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+			defer q.ShutDown()
+
+			tt.send(prioritizedHandler{priority: PriorityRenewals}, q)
+			if queued := q.Len() == 1; queued != tt.wantQueued {
+				t.Errorf("queued right away = %v, want %v", queued, tt.wantQueued)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package controllers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	horizonissuer "github.com/evertrust/horizon-issuer/internal/issuer/horizon"
+	"github.com/evertrust/horizon-issuer/internal/issuer/horizon/horizontest"
+	issuerutil "github.com/evertrust/horizon-issuer/internal/issuer/util"
+	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+var testScheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(testScheme))
+	utilruntime.Must(horizonapi.AddToScheme(testScheme))
+	utilruntime.Must(cmapi.AddToScheme(testScheme))
+}
+
+// testConfig connects to the envtest API server the controllers are tested
+// against when its binaries are available through KUBEBUILDER_ASSETS. The
+// controller-runtime fake client is used otherwise, so that the suite also
+// runs where no control plane can be started.
+var testConfig *rest.Config
+
+func TestMain(m *testing.M) {
+	var testEnv *envtest.Environment
+	if os.Getenv("KUBEBUILDER_ASSETS") != "" {
+		testEnv = &envtest.Environment{
+			CRDDirectoryPaths: []string{
+				filepath.Join("..", "..", "charts", "horizon-issuer", "crds"),
+				filepath.Join("testdata", "crds"),
+			},
+			ErrorIfCRDPathMissing: true,
+		}
+		var err error
+		if testConfig, err = testEnv.Start(); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to start envtest: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	code := m.Run()
+	if testEnv != nil {
+		if err := testEnv.Stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to stop envtest: %v\n", err)
+		}
+	}
+	os.Exit(code)
+}
+
+// Names of the objects created by the harness
+const (
+	testIssuerName      = "horizon"
+	testCredentialsName = "horizon-credentials"
+	testProfile         = "WebServers"
+	testUsername        = "issuer"
+	testPassword        = "secret"
+)
+
+// testHarness runs the Issuer and CertificateRequest reconcilers against a
+// fake Horizon server, in a namespace of its own. Reconciles are triggered
+// by the tests, so that every step of a request can be checked.
+type testHarness struct {
+	t         *testing.T
+	ctx       context.Context
+	namespace string
+	client    client.Client
+	clock     *clock.FakeClock
+	horizon   *horizontest.Server
+	recorder  *record.FakeRecorder
+	issuers   *IssuerReconciler
+	requests  *CertificateRequestReconciler
+}
+
+func newTestHarness(t *testing.T) *testHarness {
+	t.Helper()
+
+	h := &testHarness{
+		t:         t,
+		ctx:       ctrl.LoggerInto(context.Background(), ctrl.Log),
+		namespace: "test",
+		clock:     clock.NewFakeClock(time.Date(2021, time.October, 1, 12, 0, 0, 0, time.UTC)),
+		horizon:   horizontest.NewServer(),
+		recorder:  record.NewFakeRecorder(100),
+	}
+	t.Cleanup(h.horizon.Close)
+	h.horizon.Username, h.horizon.Password = testUsername, testPassword
+	h.horizon.Profiles = []string{testProfile}
+
+	if testConfig != nil {
+		var err error
+		if h.client, err = client.New(testConfig, client.Options{Scheme: testScheme}); err != nil {
+			t.Fatal(err)
+		}
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "test-"}}
+		if err := h.client.Create(h.ctx, namespace); err != nil {
+			t.Fatal(err)
+		}
+		h.namespace = namespace.Name
+		t.Cleanup(func() { _ = h.client.Delete(context.Background(), namespace) })
+	} else {
+		h.client = fake.NewClientBuilder().WithScheme(testScheme).Build()
+	}
+
+	clients := horizonissuer.NewClientCache(horizonissuer.TransportOptions{})
+	h.issuers = &IssuerReconciler{
+		Kind:                 "Issuer",
+		Client:               h.client,
+		Scheme:               testScheme,
+		HealthCheckerBuilder: horizonissuer.HorizonHealthCheckerFromClient,
+		Clients:              clients,
+		Finalizer:            true,
+		Clock:                h.clock,
+	}
+	h.requests = &CertificateRequestReconciler{
+		Client:    h.client,
+		Scheme:    testScheme,
+		Clock:     h.clock,
+		Clients:   clients,
+		APIReader: h.client,
+		Issuer: horizonissuer.HorizonIssuer{
+			Requeue: horizonissuer.RequeueSettings{
+				UnavailableRequeueAfter: 30 * time.Second,
+				SubmittedPollInterval:   10 * time.Second,
+				PollInterval:            15 * time.Second,
+			},
+			Recorder: h.recorder,
+			Clock:    h.clock,
+		},
+	}
+	return h
+}
+
+// create creates an object in the namespace of the harness. The fake client
+// doesn't set UIDs, which identify submissions, so they are set here.
+func (h *testHarness) create(obj client.Object) {
+	h.t.Helper()
+	if _, ok := obj.(*horizonapi.ClusterIssuer); !ok && obj.GetNamespace() == "" {
+		obj.SetNamespace(h.namespace)
+	}
+	if testConfig == nil && obj.GetUID() == "" {
+		obj.SetUID(uuid.NewUUID())
+	}
+	if err := h.client.Create(h.ctx, obj); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// update applies a change to an object, retrying on conflicts.
+func (h *testHarness) update(obj client.Object, mutate func()) {
+	h.t.Helper()
+	for attempt := 0; ; attempt++ {
+		if err := h.client.Get(h.ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			h.t.Fatal(err)
+		}
+		mutate()
+		err := h.client.Update(h.ctx, obj)
+		if err == nil {
+			return
+		}
+		if attempt == 3 {
+			h.t.Fatal(err)
+		}
+	}
+}
+
+// delete deletes an object. The fake client of this controller-runtime
+// version ignores finalizers, so the deletion timestamp is set instead while
+// finalizers remain, as the API server does.
+func (h *testHarness) delete(obj client.Object) {
+	h.t.Helper()
+	if testConfig == nil {
+		if err := h.client.Get(h.ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			h.t.Fatal(err)
+		}
+		if len(obj.GetFinalizers()) > 0 {
+			now := metav1.NewTime(h.clock.Now())
+			obj.SetDeletionTimestamp(&now)
+			if err := h.client.Update(h.ctx, obj); err != nil {
+				h.t.Fatal(err)
+			}
+			return
+		}
+	}
+	if err := h.client.Delete(h.ctx, obj); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// createIssuer creates an Issuer of the fake Horizon server along with its
+// credentials.
+func (h *testHarness) createIssuer(mutate func(spec *horizonapi.IssuerSpec)) *horizonapi.Issuer {
+	h.t.Helper()
+	h.create(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: testCredentialsName},
+		Data: map[string][]byte{
+			"username": []byte(testUsername),
+			"password": []byte(testPassword),
+		},
+	})
+	issuer := &horizonapi.Issuer{
+		ObjectMeta: metav1.ObjectMeta{Name: testIssuerName},
+		Spec: horizonapi.IssuerSpec{
+			URL:            h.horizon.URL,
+			Profile:        testProfile,
+			AuthSecretName: testCredentialsName,
+		},
+	}
+	if mutate != nil {
+		mutate(&issuer.Spec)
+	}
+	h.create(issuer)
+	return issuer
+}
+
+// settleIssuer reconciles an issuer as many times as it takes to go through
+// its finalizer and first seen steps, and returns its Ready condition.
+func (h *testHarness) settleIssuer(issuer *horizonapi.Issuer) *horizonapi.IssuerCondition {
+	h.t.Helper()
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := h.reconcileIssuer(); err != nil {
+			h.t.Fatal(err)
+		}
+	}
+	h.get(issuer)
+	ready := issuerutil.GetReadyCondition(&issuer.Status)
+	if ready == nil {
+		h.t.Fatalf("issuer %s has no Ready condition", issuer.Name)
+	}
+	return ready
+}
+
+// readyIssuer creates an issuer with createIssuer, and reconciles it until
+// it is ready.
+func (h *testHarness) readyIssuer(mutate func(spec *horizonapi.IssuerSpec)) *horizonapi.Issuer {
+	h.t.Helper()
+	issuer := h.createIssuer(mutate)
+	if ready := h.settleIssuer(issuer); ready.Status != horizonapi.ConditionTrue {
+		h.t.Fatalf("issuer is not ready: %s: %s", ready.Reason, ready.Message)
+	}
+	return issuer
+}
+
+func (h *testHarness) reconcileIssuer() (ctrl.Result, error) {
+	return h.issuers.Reconcile(h.ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: h.namespace, Name: testIssuerName}})
+}
+
+// createRequest creates a CertificateRequest for csrPem referencing the
+// issuer of the harness.
+func (h *testHarness) createRequest(name string, csrPem []byte, mutate func(*cmapi.CertificateRequest)) *cmapi.CertificateRequest {
+	h.t.Helper()
+	certificateRequest := &cmapi.CertificateRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: cmapi.CertificateRequestSpec{
+			Request: csrPem,
+			IssuerRef: cmmeta.ObjectReference{
+				Group: horizonapi.GroupVersion.Group,
+				Kind:  "Issuer",
+				Name:  testIssuerName,
+			},
+		},
+	}
+	if mutate != nil {
+		mutate(certificateRequest)
+	}
+	// The API server ignores the status of created objects
+	status := certificateRequest.Status
+	h.create(certificateRequest)
+	if len(status.Conditions) > 0 {
+		certificateRequest.Status = status
+		if err := h.client.Status().Update(h.ctx, certificateRequest); err != nil {
+			h.t.Fatal(err)
+		}
+	}
+	return certificateRequest
+}
+
+// reconcile reconciles a CertificateRequest, and refreshes it as left by the
+// reconcile unless it was deleted.
+func (h *testHarness) reconcile(certificateRequest *cmapi.CertificateRequest) (ctrl.Result, error) {
+	h.t.Helper()
+	result, err := h.requests.Reconcile(h.ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(certificateRequest)})
+	if getErr := h.client.Get(h.ctx, client.ObjectKeyFromObject(certificateRequest), certificateRequest); client.IgnoreNotFound(getErr) != nil {
+		h.t.Fatal(getErr)
+	}
+	return result, err
+}
+
+// get refreshes an object.
+func (h *testHarness) get(obj client.Object) {
+	h.t.Helper()
+	if err := h.client.Get(h.ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// expectReady checks the Ready condition of a CertificateRequest.
+func expectReady(t *testing.T, certificateRequest *cmapi.CertificateRequest, status cmmeta.ConditionStatus, reason string) *cmapi.CertificateRequestCondition {
+	t.Helper()
+	ready := cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady)
+	if ready == nil {
+		t.Fatalf("CertificateRequest %s has no Ready condition", certificateRequest.Name)
+	}
+	if ready.Status != status || ready.Reason != reason {
+		t.Fatalf("Ready condition of %s = %s/%s (%s), want %s/%s", certificateRequest.Name, ready.Status, ready.Reason, ready.Message, status, reason)
+	}
+	return ready
+}
+
+// newCSR returns a PEM-encoded CSR for the given DNS names, signed by a new
+// ECDSA key unless one is given.
+func newCSR(t *testing.T, key crypto.Signer, dnsNames ...string) []byte {
+	t.Helper()
+	if key == nil {
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	template := &x509.CertificateRequest{DNSNames: dnsNames}
+	if len(dnsNames) > 0 {
+		template.Subject = pkix.Name{CommonName: dnsNames[0]}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
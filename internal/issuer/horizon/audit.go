@@ -0,0 +1,84 @@
+package horizon
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditActionEnroll = "enroll"
+	AuditActionRenew  = "renew"
+	AuditActionRevoke = "revoke"
+	AuditActionCancel = "cancel"
+)
+
+// AuditEntry describes a mutation performed on Horizon. It never holds
+// credentials, nor the certificates and CSRs that were sent.
+type AuditEntry struct {
+	Time string `json:"time"`
+	// Issuer is the issuer the mutation was made through, as
+	// <kind>/<namespace>/<name>, namespace being empty for ClusterIssuers.
+	Issuer string `json:"issuer"`
+	// CertificateRequest is the request the mutation was made for, as
+	// <namespace>/<name>.
+	CertificateRequest string `json:"certificateRequest"`
+	Action             string `json:"action"`
+	Profile            string `json:"profile,omitempty"`
+	RequestId          string `json:"requestId,omitempty"`
+	// Outcome is "success" or "failure", in which case Error describes it.
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AuditLog records the mutations performed on Horizon as JSON lines, apart
+// from the controller logs. A nil AuditLog records nothing.
+type AuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAuditLog returns an audit log writing to w.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+// record writes an entry for a mutation made for a CertificateRequest,
+// which failed when err is not nil.
+func (a *AuditLog) record(certificateRequest *cmapi.CertificateRequest, action string, profile string, requestId string, err error) {
+	if a == nil {
+		return
+	}
+
+	ref := certificateRequest.Spec.IssuerRef
+	namespace := certificateRequest.Namespace
+	if ref.Kind == "ClusterIssuer" {
+		namespace = ""
+	}
+	entry := AuditEntry{
+		Time:               time.Now().UTC().Format(time.RFC3339Nano),
+		Issuer:             ref.Kind + "/" + namespace + "/" + ref.Name,
+		CertificateRequest: certificateRequest.Namespace + "/" + certificateRequest.Name,
+		Action:             action,
+		Profile:            profile,
+		RequestId:          requestId,
+		Outcome:            "success",
+	}
+	if err != nil {
+		entry.Outcome = "failure"
+		entry.Error = err.Error()
+	}
+
+	line, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, _ = a.w.Write(append(line, '\n'))
+}
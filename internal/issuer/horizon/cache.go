@@ -0,0 +1,107 @@
+package horizon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ClientCache keeps the Horizon clients built for issuers, so that their
+// connections are reused across reconciliations.
+type ClientCache struct {
+	mu        sync.Mutex
+	clients   map[clientKey]cachedClient
+	transport TransportOptions
+}
+
+// clientKey identifies the client of an issuer built for the credentials of
+// a purpose.
+type clientKey struct {
+	issuer  types.NamespacedName
+	purpose CredentialPurpose
+}
+
+type cachedClient struct {
+	// version identifies the issuer spec and credentials the client was built from
+	version string
+	client  *Client
+}
+
+// NewClientCache returns an empty cache, building clients with the given
+// connection pool settings.
+func NewClientCache(transport TransportOptions) *ClientCache {
+	return &ClientCache{
+		clients:   make(map[clientKey]cachedClient),
+		transport: transport,
+	}
+}
+
+// Get returns the client cached for the credentials of an issuer used for
+// purpose. A new client is built when the issuer spec or those credentials
+// changed since it was cached.
+func (c *ClientCache) Get(ctx context.Context, issuer client.Object, issuerSpec *horizonapi.IssuerSpec, credentials CredentialSource, purpose CredentialPurpose) (*Client, error) {
+	secretData, err := credentials.Credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// ClusterIssuers have no namespace, so their keys never collide with Issuers
+	key := clientKey{issuer: client.ObjectKeyFromObject(issuer), purpose: purpose}
+	version := fmt.Sprintf("%d/%s", issuer.GetGeneration(), credentialsHash(secretData))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.clients[key]; ok {
+		if cached.version == version {
+			return cached.client, nil
+		}
+		cached.client.Http.Transport.CloseIdleConnections()
+	}
+
+	horizonClient, err := newClient(issuerSpec, secretData, credentials, c.transport)
+	if err != nil {
+		return nil, err
+	}
+	c.clients[key] = cachedClient{version: version, client: horizonClient}
+	return horizonClient, nil
+}
+
+// Evict removes the clients cached for an issuer and closes their
+// connections.
+func (c *ClientCache) Evict(issuer types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, purpose := range []CredentialPurpose{CredentialsEnroll, CredentialsRead} {
+		key := clientKey{issuer: issuer, purpose: purpose}
+		if cached, ok := c.clients[key]; ok {
+			cached.client.Http.Transport.CloseIdleConnections()
+			delete(c.clients, key)
+		}
+	}
+}
+
+// credentialsHash returns a digest of credentials, so that changes can be
+// detected without keeping the credentials themselves around.
+func credentialsHash(secretData map[string][]byte) string {
+	keys := make([]string, 0, len(secretData))
+	for key := range secretData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%d:%s%d:", len(key), key, len(secretData[key]))
+		hash.Write(secretData[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
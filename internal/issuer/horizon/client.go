@@ -0,0 +1,670 @@
+package horizon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evertrust/horizon-go"
+	"github.com/evertrust/horizon-go/certificates"
+	"github.com/evertrust/horizon-go/requests"
+	"github.com/evertrust/horizon-go/rfc5280"
+)
+
+// Client wraps the horizon-go client so that requests are sent through its
+// authenticated HTTP client while the raw responses remain available to us.
+type Client struct {
+	horizon.Horizon
+	// Headers are added to every request sent to Horizon.
+	Headers http.Header
+	// inFlight bounds the number of concurrent enroll and polling calls, and
+	// is shared by the copies of the client. Calls are not bounded when nil.
+	inFlight chan struct{}
+	// networkRetries is the number of times a call is retried on transient
+	// network errors.
+	networkRetries int
+	// hmacKey signs every request sent to Horizon when set.
+	hmacKey []byte
+	// owners caches the lookups of owners in the Horizon directory, and is
+	// shared by the copies of the client.
+	owners *ownerCache
+	// token, if set, is the service account token the client authenticates
+	// with instead of API credentials. Requests are then sent through
+	// bearerHttp, which shares the transport of horizon-go.
+	token      *bearerToken
+	bearerHttp *http.Client
+}
+
+// networkRetryBackoff is the delay before the first retry of a call that
+// failed with a network error, doubled on each retry.
+const networkRetryBackoff = 500 * time.Millisecond
+
+// ErrConcurrencyLimit is returned when an issuer already has as many
+// calls to Horizon in flight as it allows.
+var ErrConcurrencyLimit = errors.New("too many concurrent requests to Horizon for this issuer")
+
+// acquire reserves an in-flight call, and returns the function releasing it.
+func (c *Client) acquire() (func(), error) {
+	if c.inFlight == nil {
+		return func() {}, nil
+	}
+	select {
+	case c.inFlight <- struct{}{}:
+		return func() { <-c.inFlight }, nil
+	default:
+		return nil, ErrConcurrencyLimit
+	}
+}
+
+// UnavailableError is returned when Horizon is temporarily unable to handle
+// requests, for instance while it is under maintenance.
+type UnavailableError struct {
+	// RetryAfter is the delay Horizon asked us to wait before retrying,
+	// or zero if it did not send a Retry-After header.
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("Horizon is temporarily unavailable, retry after %s", e.RetryAfter)
+	}
+	return "Horizon is temporarily unavailable"
+}
+
+// enrollTemplate extends the WebRA enroll template with fields that
+// horizon-go does not know about.
+type enrollTemplate struct {
+	requests.WebRARequestTemplate
+	KeyUsages         []string          `json:"keyUsages,omitempty"`
+	ExtendedKeyUsages []string          `json:"extendedKeyUsages,omitempty"`
+	VirtualCa         string            `json:"virtualCa,omitempty"`
+	Ca                bool              `json:"ca,omitempty"`
+	Parameters        map[string]string `json:"parameters,omitempty"`
+}
+
+// requestWorkflowRenew is the workflow renewing a certificate, which
+// horizon-go does not know about.
+const requestWorkflowRenew requests.RequestWorkflow = "renew"
+
+// renewTemplate is the template of decentralized renew requests, holding
+// the CSR of the renewed certificate.
+type renewTemplate struct {
+	Csr string `json:"csr"`
+}
+
+// DefaultModule is the Horizon module requests are submitted to when the
+// issuer does not select one.
+const DefaultModule = "webra"
+
+// KnownModules are the Horizon modules requests may be submitted to.
+var KnownModules = []string{DefaultModule, "est", "scep", "acme", "intune", "jamf", "wcce"}
+
+// moduleOrDefault returns module, or the default module when it is empty.
+func moduleOrDefault(module string) string {
+	if module == "" {
+		return DefaultModule
+	}
+	return module
+}
+
+// EnrollOptions controls how an enroll request is built.
+type EnrollOptions struct {
+	// Module is the Horizon module the request is submitted to, the
+	// default module when empty.
+	Module string
+	// OverrideSubject makes metadata DN elements replace those of the same
+	// type in the CSR, instead of only adding missing ones.
+	OverrideSubject bool
+	// CommonNameFromSan uses the first DNS SAN as the common name of CSRs
+	// that have none, unless the metadata sets one.
+	CommonNameFromSan bool
+	// UpnSanType is the Horizon SAN type UPN otherName SANs are sent as,
+	// DefaultUpnSanType when empty.
+	UpnSanType string
+}
+
+// DecentralizedEnroll submits a decentralized enroll request for the given
+// CSR on a profile. It behaves like requests.Client.DecentralizedEnroll, with
+// the subject of the CSR combined with the one from the metadata.
+func (c *Client) DecentralizedEnroll(ctx context.Context, profile string, csr []byte, metadata CertificateMetadata, options EnrollOptions) (*requests.HorizonRequest, error) {
+	release, err := c.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Horizon parses the CSR for us, this avoids doing local cryptographic operations
+	var parsedCsr rfc5280.CFCertificationRequest
+	baseUrl := c.Http.BaseUrl()
+	pkcs10Url := baseUrl.String() + "/api/v1/rfc5280/pkcs10/" + url.PathEscape(string(csr))
+	if err := c.do(ctx, http.MethodGet, pkcs10Url, nil, &parsedCsr); err != nil {
+		return nil, err
+	}
+
+	dnElements, err := withCommonName(parsedCsr, metadata.CommonName, options.CommonNameFromSan)
+	if err != nil {
+		return nil, &PermanentError{Err: err}
+	}
+
+	var typeCounts = make(map[string]int)
+
+	// Translate the parsed certificate DN elements into the request elements
+	var subject []requests.IndexedDNElement
+	for _, dnElement := range combineSubject(dnElements, metadata.Subject, options.OverrideSubject) {
+		typeCounts[dnElement.Type]++
+		subject = append(subject, requests.IndexedDNElement{
+			Element: fmt.Sprintf("%s.%d", strings.ToLower(dnElement.Type), typeCounts[dnElement.Type]),
+			Type:    dnElement.Type,
+			Value:   dnElement.Value,
+		})
+	}
+
+	upns, err := upnSans(csr)
+	if err != nil {
+		return nil, &PermanentError{Err: err}
+	}
+
+	// Translate the parsed certificate SAN elements into the request elements
+	var sans []requests.IndexedSANElement
+	for _, sanElement := range withUpnSans(parsedCsr.Sans, upns, options.UpnSanType) {
+		typeCounts[sanElement.SanType]++
+		sans = append(sans, requests.IndexedSANElement{
+			Element: fmt.Sprintf("%s.%d", strings.ToLower(sanElement.SanType), typeCounts[sanElement.SanType]),
+			Type:    sanElement.SanType,
+			Value:   sanElement.Value,
+		})
+	}
+
+	template := requests.WebRARequestTemplate{
+		Csr:     parsedCsr.Pem,
+		Subject: subject,
+		Sans:    sans,
+		Labels:  metadata.Labels,
+	}
+
+	if metadata.Owner != nil {
+		template.Owner = &requests.CertificateOwner{
+			Value:    *metadata.Owner,
+			Editable: false,
+		}
+	}
+
+	if metadata.Team != nil {
+		template.Team = &requests.CertificateTeam{
+			Value:    *metadata.Team,
+			Editable: false,
+		}
+	}
+
+	return c.submit(ctx, requests.HorizonRequest{
+		Workflow:         requests.RequestWorkflowEnroll,
+		Profile:          profile,
+		Module:           moduleOrDefault(options.Module),
+		RequesterComment: metadata.Description,
+		Template: enrollTemplate{
+			WebRARequestTemplate: template,
+			KeyUsages:            metadata.KeyUsages,
+			ExtendedKeyUsages:    metadata.ExtendedKeyUsages,
+			VirtualCa:            metadata.VirtualCa,
+			Ca:                   metadata.IsCA,
+			Parameters:           metadata.TemplateParameters,
+		},
+	})
+}
+
+// DecentralizedRenew submits a request renewing a PEM-encoded certificate
+// with a new CSR on a profile. Horizon keeps the subject, SANs and
+// metadata of the renewed certificate.
+func (c *Client) DecentralizedRenew(ctx context.Context, profile string, csr []byte, certificatePem string, metadata CertificateMetadata, options EnrollOptions) (*requests.HorizonRequest, error) {
+	release, err := c.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return c.submit(ctx, requests.HorizonRequest{
+		Workflow:         requestWorkflowRenew,
+		Profile:          profile,
+		Module:           moduleOrDefault(options.Module),
+		RequesterComment: metadata.Description,
+		CertificatePEM:   certificatePem,
+		Template:         renewTemplate{Csr: string(csr)},
+	})
+}
+
+// profileTemplate is the part of the enroll template of a profile the
+// issuer reads.
+type profileTemplate struct {
+	VirtualCas []string `json:"virtualCas"`
+	Parameters []struct {
+		Name string `json:"name"`
+	} `json:"parameters"`
+}
+
+// template fetches the enroll template of a profile of a module.
+func (c *Client) template(ctx context.Context, module string, profile string) (*profileTemplate, error) {
+	body, err := json.Marshal(requests.HorizonRequest{
+		Workflow: requests.RequestWorkflowEnroll,
+		Profile:  profile,
+		Module:   moduleOrDefault(module),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var request struct {
+		Template profileTemplate `json:"template"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.url("/api/v1/requests/template"), body, &request); err != nil {
+		return nil, err
+	}
+	return &request.Template, nil
+}
+
+// VirtualCas returns the virtual CAs the authenticated principal may enroll
+// certificates on through a profile of a module, as reported in its enroll
+// template.
+func (c *Client) VirtualCas(ctx context.Context, module string, profile string) ([]string, error) {
+	template, err := c.template(ctx, module, profile)
+	if err != nil {
+		return nil, err
+	}
+	return template.VirtualCas, nil
+}
+
+// TemplateParameters returns the keys of the template parameters accepted
+// by a profile of a module, or nil when its enroll template does not report
+// them.
+func (c *Client) TemplateParameters(ctx context.Context, module string, profile string) ([]string, error) {
+	template, err := c.template(ctx, module, profile)
+	if err != nil {
+		return nil, err
+	}
+	if template.Parameters == nil {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(template.Parameters))
+	for _, parameter := range template.Parameters {
+		keys = append(keys, parameter.Name)
+	}
+	return keys, nil
+}
+
+// LabelValues returns the values a Horizon label may take, or nil when the
+// label accepts any value.
+func (c *Client) LabelValues(ctx context.Context, label string) ([]string, error) {
+	var definition struct {
+		Suggestions []string `json:"suggestions"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.segmentUrl("/api/v1/labels/", label), nil, &definition); err != nil {
+		return nil, err
+	}
+	if len(definition.Suggestions) == 0 {
+		return nil, nil
+	}
+	return definition.Suggestions, nil
+}
+
+// Profiles returns the names of the profiles of a module on which the
+// authenticated principal may enroll certificates.
+func (c *Client) Profiles(ctx context.Context, module string) ([]string, error) {
+	body, err := json.Marshal(requests.HorizonRequest{
+		Workflow: requests.RequestWorkflowEnroll,
+		Module:   moduleOrDefault(module),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var profiles []struct {
+		Name string `json:"name"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.url("/api/v1/requests/profiles"), body, &profiles); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		names = append(names, profile.Name)
+	}
+	return names, nil
+}
+
+// Request is a request fetched from Horizon, along with fields horizon-go
+// does not know about.
+type Request struct {
+	requests.HorizonRequest
+	// RenewalDate is when Horizon recommends renewing the issued certificate,
+	// in milliseconds since the epoch, or zero when Horizon did not return it.
+	RenewalDate int64
+	// CertificateId is the Horizon ID of the issued certificate, or empty
+	// when Horizon did not return it.
+	CertificateId string
+}
+
+func (r *Request) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &r.HorizonRequest); err != nil {
+		return err
+	}
+	var extra struct {
+		Certificate *struct {
+			RenewalDate int64  `json:"renewalDate"`
+			Id          string `json:"_id"`
+		} `json:"certificate"`
+	}
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return err
+	}
+	if extra.Certificate != nil {
+		r.RenewalDate = extra.Certificate.RenewalDate
+		r.CertificateId = extra.Certificate.Id
+	}
+	return nil
+}
+
+// requestIdFormat matches the IDs of Horizon requests, which are object IDs
+// of 24 hexadecimal digits.
+var requestIdFormat = regexp.MustCompile(`^[0-9A-Fa-f]{24}$`)
+
+// GetRequest fetches a request from Horizon given its ID. IDs may come from
+// user-controlled annotations, so anything but a request ID is rejected
+// rather than sent in the URL.
+func (c *Client) GetRequest(ctx context.Context, id string) (*Request, error) {
+	if !requestIdFormat.MatchString(id) {
+		return nil, &PermanentError{Err: fmt.Errorf("invalid request ID %q, expected 24 hexadecimal digits", id)}
+	}
+
+	release, err := c.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var request Request
+	if err := c.do(ctx, http.MethodGet, c.segmentUrl("/api/v1/requests/", id), nil, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// findRequestPageSize bounds the number of matching requests FindRequest
+// chooses from.
+const findRequestPageSize = 10
+
+// labelName matches the names of labels that can be searched for, which are
+// not quoted in search queries.
+var labelName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// FindRequest searches Horizon for an enroll or renew request on a profile
+// carrying a label value, that is pending, approved or completed. When several requests
+// match, completed ones are preferred over approved ones, themselves
+// preferred over pending ones, and the most recent one is chosen among those.
+// It returns an empty ID when there is none, along with the number of
+// matching requests, counted up to findRequestPageSize.
+func (c *Client) FindRequest(ctx context.Context, module string, profile string, label string, value string) (string, int, error) {
+	if !labelName.MatchString(label) {
+		return "", 0, &PermanentError{Err: fmt.Errorf("invalid label name %q, only letters, digits, dashes and underscores are allowed", label)}
+	}
+
+	release, err := c.acquire()
+	if err != nil {
+		return "", 0, err
+	}
+	defer release()
+
+	query := fmt.Sprintf(
+		`module equals %s and profile equals %s and workflow in [%s, %s] and status in ["pending", "approved", "completed"] and labels.%s equals %s`,
+		strconv.Quote(moduleOrDefault(module)), strconv.Quote(profile),
+		strconv.Quote(string(requests.RequestWorkflowEnroll)), strconv.Quote(string(requestWorkflowRenew)),
+		label, strconv.Quote(value),
+	)
+	body, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"pageIndex": 1,
+		"pageSize":  findRequestPageSize,
+		"sortedBy":  []map[string]string{{"element": "registrationDate", "order": "Desc"}},
+		"withCount": false,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	var page struct {
+		Results []requests.HorizonRequest `json:"results"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.url("/api/v1/requests/search"), body, &page); err != nil {
+		return "", 0, err
+	}
+	if len(page.Results) == 0 {
+		return "", 0, nil
+	}
+	return preferredRequest(page.Results).Id, len(page.Results), nil
+}
+
+// requestStatusPreference ranks the statuses of requests FindRequest
+// chooses from, the highest being preferred.
+var requestStatusPreference = map[requests.RequestStatus]int{
+	requests.RequestStatusCompleted: 2,
+	requests.RequestStatusApproved:  1,
+	requests.RequestStatusPending:   0,
+}
+
+// preferredRequest returns the request of the most preferred status, the
+// most recent one among those, ties being broken by ID so that the choice
+// doesn't depend on the order of the results.
+func preferredRequest(results []requests.HorizonRequest) requests.HorizonRequest {
+	preferred := results[0]
+	for _, request := range results[1:] {
+		rank, preferredRank := requestStatusPreference[request.Status], requestStatusPreference[preferred.Status]
+		switch {
+		case rank != preferredRank:
+			if rank > preferredRank {
+				preferred = request
+			}
+		case request.RegistrationDate != preferred.RegistrationDate:
+			if request.RegistrationDate > preferred.RegistrationDate {
+				preferred = request
+			}
+		case request.Id > preferred.Id:
+			preferred = request
+		}
+	}
+	return preferred
+}
+
+// Revoke submits a revocation request for a PEM-encoded certificate to a
+// module.
+func (c *Client) Revoke(ctx context.Context, module string, certificatePem string, revocationReason certificates.RevocationReason) (*requests.HorizonRequest, error) {
+	return c.submit(ctx, requests.HorizonRequest{
+		Workflow:       requests.RequestWorkflowRevoke,
+		Module:         moduleOrDefault(module),
+		CertificatePEM: certificatePem,
+		Template:       requests.WebRARevokeTemplate{RevocationReason: revocationReason},
+	})
+}
+
+// Cancel cancels a pending request submitted to a module, which Horizon
+// designates by its workflow along with its ID.
+func (c *Client) Cancel(ctx context.Context, module string, workflow requests.RequestWorkflow, id string) error {
+	body, err := json.Marshal(requests.HorizonRequest{
+		Id:       id,
+		Workflow: workflow,
+		Module:   moduleOrDefault(module),
+	})
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, c.url("/api/v1/requests/cancel"), body, nil)
+}
+
+// Self fetches the principal Horizon authenticated us as.
+func (c *Client) Self(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, c.url("/api/v1/security/principals/self"), nil, nil)
+}
+
+func (c *Client) submit(ctx context.Context, request requests.HorizonRequest) (*requests.HorizonRequest, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.do(ctx, http.MethodPost, c.url("/api/v1/requests/submit"), body, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// RequestUiUrl returns the link to a request in the Horizon UI. Unlike API
+// URLs, it is relative to the path of the base URL, if any.
+func (c *Client) RequestUiUrl(id string) string {
+	uiUrl := c.Http.BaseUrl()
+	uiUrl.Path = strings.TrimSuffix(uiUrl.Path, "/") + "/ui/requests/" + id
+	uiUrl.RawPath = ""
+	uiUrl.RawQuery = ""
+	uiUrl.Fragment = ""
+	return uiUrl.String()
+}
+
+func (c *Client) url(path string) string {
+	baseUrl := c.Http.BaseUrl()
+	return baseUrl.ResolveReference(&url.URL{Path: path}).String()
+}
+
+// segmentUrl returns the URL of an API path ending with segment, which is
+// escaped so that it stays a single path segment.
+func (c *Client) segmentUrl(path string, segment string) string {
+	baseUrl := c.Http.BaseUrl()
+	return baseUrl.ResolveReference(&url.URL{Path: path + segment, RawPath: path + url.PathEscape(segment)}).String()
+}
+
+// do sends a request to Horizon and decodes the JSON response into out,
+// unless out is nil. Responses signaling that Horizon is temporarily
+// unavailable are turned into an UnavailableError.
+func (c *Client) do(ctx context.Context, method string, reqUrl string, body []byte, out interface{}) error {
+	res, err := c.send(ctx, method, reqUrl, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	retryAfter := res.Header.Get("Retry-After")
+	if res.StatusCode == http.StatusServiceUnavailable || (res.StatusCode >= 400 && retryAfter != "") {
+		return &UnavailableError{RetryAfter: parseRetryAfter(retryAfter, time.Now())}
+	}
+
+	if res.StatusCode >= 300 {
+		err := readError(res)
+		if isPermanentStatus(res.StatusCode) {
+			return &PermanentError{Err: err}
+		}
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// readError reads the error response sent by Horizon. Unlike horizon-go,
+// it doesn't exit when the error can't be decoded.
+func readError(res *http.Response) *HorizonError {
+	body, _ := io.ReadAll(res.Body)
+	horizonErr := &HorizonError{StatusCode: res.StatusCode}
+	if err := json.Unmarshal(body, horizonErr); err != nil || horizonErr.Code == "" {
+		return &HorizonError{
+			StatusCode: res.StatusCode,
+			Code:       "Unknown",
+			Message:    "Non-JSON error from Horizon",
+			Detail:     string(body),
+		}
+	}
+	return horizonErr
+}
+
+// send sends a request to Horizon, retrying it on transient network errors
+// until the retries are exhausted or the context is done.
+func (c *Client) send(ctx context.Context, method string, reqUrl string, body []byte) (*http.Response, error) {
+	backoff := networkRetryBackoff
+	for attempt := 0; ; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, reqUrl, reader)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range c.Headers {
+			for _, value := range values {
+				req.Header.Add(name, value)
+			}
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.hmacKey != nil {
+			c.sign(req, body, time.Now())
+		}
+
+		if c.token != nil {
+			token, err := c.token.get(ctx, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			if req.Header.Get("Authorization") == "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+
+		var res *http.Response
+		if c.token != nil {
+			res, err = c.bearerHttp.Do(req)
+		} else {
+			res, err = c.Http.Do(req)
+		}
+		if err == nil || attempt >= c.networkRetries || ctx.Err() != nil || !isTransientNetworkError(err, method) {
+			return res, err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// isTransientNetworkError returns whether a call that failed with err may
+// succeed if sent again right away. Calls that may have reached Horizon are
+// only retried when they are reads, so that requests are not submitted
+// twice.
+func isTransientNetworkError(err error, method string) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	if errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial") {
+		return true
+	}
+	var netErr net.Error
+	return method == http.MethodGet && errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// parseRetryAfter reads a Retry-After header value, which is either a
+// number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,279 @@
+package horizon
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evertrust/horizon-go/requests"
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	"github.com/evertrust/horizon-issuer/internal/issuer/horizon/horizontest"
+)
+
+// newTestClient returns a client of the Horizon instance served by handler.
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client, err := newClient(&horizonapi.IssuerSpec{URL: server.URL}, map[string][]byte{"username": []byte("user"), "password": []byte("password")}, nil, TransportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// newTestCSR returns a PEM-encoded CSR built from template, signed by key or
+// by a new ECDSA P-256 key when key is nil.
+func newTestCSR(t *testing.T, template *x509.CertificateRequest, key crypto.Signer) []byte {
+	t.Helper()
+	if key == nil {
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestDoStatusCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		wantErr    bool
+	}{
+		{name: "ok", statusCode: http.StatusOK},
+		{name: "no content", statusCode: http.StatusNoContent},
+		{name: "multiple choices", statusCode: http.StatusMultipleChoices, wantErr: true},
+		{name: "not modified", statusCode: http.StatusNotModified, wantErr: true},
+		{name: "bad request", statusCode: http.StatusBadRequest, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.statusCode)
+				if tt.statusCode != http.StatusNoContent && tt.statusCode != http.StatusNotModified {
+					_, _ = w.Write([]byte(`{"error":"ERR","message":"failure"}`))
+				}
+			}))
+
+			err := client.do(context.Background(), http.MethodGet, client.url("/api/v1/security/principals/self"), nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var horizonErr *HorizonError
+			if tt.wantErr && !errors.As(err, &horizonErr) {
+				t.Fatalf("do() error = %v, want a HorizonError", err)
+			}
+			if tt.wantErr && horizonErr.StatusCode != tt.statusCode {
+				t.Errorf("HorizonError.StatusCode = %d, want %d", horizonErr.StatusCode, tt.statusCode)
+			}
+		})
+	}
+}
+
+func TestFindRequest(t *testing.T) {
+	server := horizontest.NewServer()
+	t.Cleanup(server.Close)
+	client, err := newClient(&horizonapi.IssuerSpec{URL: server.URL}, nil, nil, TransportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	submit := func(workflow requests.RequestWorkflow, correlationId string) string {
+		t.Helper()
+		request, err := client.submit(ctx, requests.HorizonRequest{
+			Workflow: workflow,
+			Module:   DefaultModule,
+			Profile:  "WebServers",
+			Template: requests.WebRARequestTemplate{Labels: []requests.LabelElement{{Label: "correlation-id", Value: correlationId}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return request.Id
+	}
+	enrollId := submit(requests.RequestWorkflowEnroll, "enrolled")
+	renewId := submit(requestWorkflowRenew, "renewed")
+	submit(requests.RequestWorkflowRevoke, "revoked")
+
+	tests := []struct {
+		name          string
+		label         string
+		correlationId string
+		wantId        string
+		wantErr       bool
+	}{
+		{name: "enroll request", label: "correlation-id", correlationId: "enrolled", wantId: enrollId},
+		{name: "renew request", label: "correlation-id", correlationId: "renewed", wantId: renewId},
+		{name: "revoke request", label: "correlation-id", correlationId: "revoked"},
+		{name: "no request", label: "correlation-id", correlationId: "unknown"},
+		{name: "label breaking the query", label: `id equals "x" or labels.correlation-id`, correlationId: "enrolled", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, _, err := client.FindRequest(ctx, "", "WebServers", tt.label, tt.correlationId)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FindRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !IsPermanent(err) {
+				t.Errorf("FindRequest() error = %v, want a permanent error", err)
+			}
+			if id != tt.wantId {
+				t.Errorf("FindRequest() = %q, want %q", id, tt.wantId)
+			}
+		})
+	}
+}
+
+func TestPreferredRequest(t *testing.T) {
+	request := func(id string, status requests.RequestStatus, registrationDate int) requests.HorizonRequest {
+		return requests.HorizonRequest{Id: id, Status: status, RegistrationDate: registrationDate}
+	}
+	tests := []struct {
+		name    string
+		results []requests.HorizonRequest
+		wantId  string
+	}{
+		{
+			name:    "single request",
+			results: []requests.HorizonRequest{request("1", requests.RequestStatusPending, 100)},
+			wantId:  "1",
+		},
+		{
+			name: "completed request over more recent ones",
+			results: []requests.HorizonRequest{
+				request("1", requests.RequestStatusPending, 300),
+				request("2", requests.RequestStatusCompleted, 100),
+				request("3", requests.RequestStatusApproved, 200),
+			},
+			wantId: "2",
+		},
+		{
+			name: "approved request over a more recent pending one",
+			results: []requests.HorizonRequest{
+				request("1", requests.RequestStatusPending, 200),
+				request("2", requests.RequestStatusApproved, 100),
+			},
+			wantId: "2",
+		},
+		{
+			name: "most recent request of the same status",
+			results: []requests.HorizonRequest{
+				request("1", requests.RequestStatusPending, 100),
+				request("2", requests.RequestStatusPending, 300),
+				request("3", requests.RequestStatusPending, 200),
+			},
+			wantId: "2",
+		},
+		{
+			name: "highest ID of the same status and date",
+			results: []requests.HorizonRequest{
+				request("a", requests.RequestStatusCompleted, 100),
+				request("c", requests.RequestStatusCompleted, 100),
+				request("b", requests.RequestStatusCompleted, 100),
+			},
+			wantId: "c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := preferredRequest(tt.results).Id; got != tt.wantId {
+				t.Errorf("preferredRequest() = %s, want %s", got, tt.wantId)
+			}
+			// The choice doesn't depend on the order of the results
+			reversed := make([]requests.HorizonRequest, len(tt.results))
+			for i, request := range tt.results {
+				reversed[len(tt.results)-1-i] = request
+			}
+			if got := preferredRequest(reversed).Id; got != tt.wantId {
+				t.Errorf("preferredRequest() of the reversed results = %s, want %s", got, tt.wantId)
+			}
+		})
+	}
+}
+
+func TestFindRequestMatches(t *testing.T) {
+	server, client := newFakeHorizon(t)
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 3; i++ {
+		request, err := client.submit(ctx, requests.HorizonRequest{
+			Workflow: requests.RequestWorkflowEnroll,
+			Module:   DefaultModule,
+			Profile:  "WebServers",
+			Template: requests.WebRARequestTemplate{Labels: []requests.LabelElement{{Label: "correlation-id", Value: "twice"}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, request.Id)
+	}
+	if err := server.Approve(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Deny(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	id, matches, err := client.FindRequest(ctx, "", "WebServers", "correlation-id", "twice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != ids[0] || matches != 2 {
+		t.Errorf("FindRequest() = %s, %d, want the approved request %s of 2 matches", id, matches, ids[0])
+	}
+}
+
+func TestGetRequestId(t *testing.T) {
+	var paths []string
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		w.Write([]byte(`{"id":"6156f0a1e4b0c0a1b2c3d4e5","status":"pending"}`))
+	}))
+
+	tests := []struct {
+		name     string
+		id       string
+		wantPath string
+	}{
+		{name: "request ID", id: "6156f0a1e4b0c0a1b2c3d4e5", wantPath: "/api/v1/requests/6156f0a1e4b0c0a1b2c3d4e5"},
+		{name: "path traversal", id: "../../security/principals/self"},
+		{name: "escaped path traversal", id: "..%2F..%2Fsecurity%2Fprincipals%2Fself"},
+		{name: "query", id: "6156f0a1e4b0c0a1b2c3d4e5?query="},
+		{name: "empty ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths = nil
+			_, err := client.GetRequest(context.Background(), tt.id)
+			if tt.wantPath == "" {
+				if !IsPermanent(err) {
+					t.Errorf("err = %v, want a permanent error", err)
+				}
+				if len(paths) > 0 {
+					t.Errorf("sent requests to %q, want none", paths)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(paths) != 1 || paths[0] != tt.wantPath {
+				t.Errorf("sent requests to %q, want %s", paths, tt.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package horizon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	errGetAuthSecret  = errors.New("failed to get Secret containing Issuer credentials")
+	errReadAuthPath   = errors.New("failed to read Issuer credentials from file")
+	errAuthPath       = errors.New("invalid authPath")
+	errNoCredentials  = errors.New("either authSecretName or authPath must be set")
+	errAuthPathDenied = errors.New("authPath is only allowed on ClusterIssuers when the controller has a credentials directory")
+)
+
+// CredentialSource provides the credentials used to authenticate against
+// Horizon. Credentials are keyed like the data of the authentication secret,
+// for instance "username" and "password".
+type CredentialSource interface {
+	Credentials(ctx context.Context) (map[string][]byte, error)
+}
+
+// SecretCredentials reads credentials from a Kubernetes Secret.
+type SecretCredentials struct {
+	Reader client.Reader
+	Name   types.NamespacedName
+}
+
+func (s *SecretCredentials) Credentials(ctx context.Context) (map[string][]byte, error) {
+	var secret corev1.Secret
+	if err := s.Reader.Get(ctx, s.Name, &secret); err != nil {
+		return nil, fmt.Errorf("%w, secret name: %s, reason: %v", errGetAuthSecret, s.Name, err)
+	}
+	return secret.Data, nil
+}
+
+// FileCredentials reads credentials from a directory holding one file per
+// key, such as a Secret volume or a volume mounted by a CSI secret driver.
+type FileCredentials struct {
+	Dir string
+	// Root, if set, is the directory credentials must be read from. Dir and
+	// its entries may be symlinks, as in mounted volumes, but not to files
+	// outside of Root.
+	Root string
+}
+
+func (f *FileCredentials) Credentials(ctx context.Context) (map[string][]byte, error) {
+	dir, err := f.resolve(f.Dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errReadAuthPath, err)
+	}
+
+	data := make(map[string][]byte)
+	for _, entry := range entries {
+		// Mounted volumes contain hidden entries such as ..data
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		// Entries are usually symlinks, so their target is checked instead
+		path, err := f.resolve(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errReadAuthPath, err)
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		value, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errReadAuthPath, err)
+		}
+		data[entry.Name()] = value
+	}
+	return data, nil
+}
+
+// resolve returns the path path leads to once symlinks are followed, after
+// checking that it is within Root.
+func (f *FileCredentials) resolve(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errReadAuthPath, err)
+	}
+	if f.Root == "" {
+		return resolved, nil
+	}
+	root, err := filepath.EvalSymlinks(f.Root)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errReadAuthPath, err)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &PermanentError{Err: fmt.Errorf("%w: %s leads outside of the credentials directory", errAuthPath, path)}
+	}
+	return resolved, nil
+}
+
+// CredentialPurpose selects the credentials of an issuer used for a kind of
+// operation.
+type CredentialPurpose string
+
+const (
+	// CredentialsEnroll are used to submit, renew, revoke and cancel
+	// requests, and for health checks.
+	CredentialsEnroll CredentialPurpose = "enroll"
+	// CredentialsRead are used to poll and look up requests.
+	CredentialsRead CredentialPurpose = "read"
+)
+
+// purposeSecretName returns the name of the Secret dedicated to the
+// credentials of an issuer used for purpose, or an empty string when those
+// are the default credentials of the issuer.
+func purposeSecretName(issuerSpec *horizonapi.IssuerSpec, purpose CredentialPurpose) string {
+	switch purpose {
+	case CredentialsEnroll:
+		return issuerSpec.EnrollAuthSecretName
+	case CredentialsRead:
+		return issuerSpec.ReadAuthSecretName
+	}
+	return ""
+}
+
+// SeparateCredentials returns whether an issuer reads from Horizon with
+// other credentials than it enrolls with.
+func SeparateCredentials(issuerSpec *horizonapi.IssuerSpec) bool {
+	return purposeSecretName(issuerSpec, CredentialsRead) != purposeSecretName(issuerSpec, CredentialsEnroll)
+}
+
+// CredentialSourceFromIssuer returns where the credentials of an issuer used
+// for purpose are read from. The authPath of the issuer is resolved within
+// credentialsDir, and is rejected when credentialsDir is empty.
+func CredentialSourceFromIssuer(reader client.Reader, issuerSpec *horizonapi.IssuerSpec, secretNamespace string, credentialsDir string, purpose CredentialPurpose) (CredentialSource, error) {
+	if name := purposeSecretName(issuerSpec, purpose); name != "" {
+		return &SecretCredentials{
+			Reader: reader,
+			Name:   types.NamespacedName{Name: name, Namespace: secretNamespace},
+		}, nil
+	}
+
+	if issuerSpec.AuthPath != nil {
+		if credentialsDir == "" {
+			return nil, &PermanentError{Err: errAuthPathDenied}
+		}
+		path := filepath.Clean(*issuerSpec.AuthPath)
+		if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+			return nil, &PermanentError{Err: fmt.Errorf("%w: %s must be relative to the credentials directory", errAuthPath, *issuerSpec.AuthPath)}
+		}
+		return &FileCredentials{Dir: filepath.Join(credentialsDir, path), Root: credentialsDir}, nil
+	}
+
+	if issuerSpec.AuthSecretName == "" {
+		return nil, &PermanentError{Err: errNoCredentials}
+	}
+	return &SecretCredentials{
+		Reader: reader,
+		Name: types.NamespacedName{
+			Name:      issuerSpec.AuthSecretName,
+			Namespace: secretNamespace,
+		},
+	}, nil
+}
@@ -0,0 +1,110 @@
+package horizon
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+)
+
+// writeFile writes a file, creating its directory.
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func symlink(t *testing.T, target string, path string) {
+	t.Helper()
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileCredentials(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	writeFile(t, filepath.Join(outside, "password"), "stolen")
+
+	// Laid out as kubelet mounts Secret volumes
+	writeFile(t, filepath.Join(root, "volume", "..2021_10_01", "username"), "issuer")
+	writeFile(t, filepath.Join(root, "volume", "..2021_10_01", "password"), "secret")
+	symlink(t, "..2021_10_01", filepath.Join(root, "volume", "..data"))
+	symlink(t, filepath.Join("..data", "username"), filepath.Join(root, "volume", "username"))
+	symlink(t, filepath.Join("..data", "password"), filepath.Join(root, "volume", "password"))
+
+	writeFile(t, filepath.Join(root, "escaping", "username"), "issuer")
+	symlink(t, filepath.Join(outside, "password"), filepath.Join(root, "escaping", "password"))
+
+	symlink(t, outside, filepath.Join(root, "linked"))
+	symlink(t, "volume", filepath.Join(root, "alias"))
+
+	tests := []struct {
+		name     string
+		authPath string
+		want     map[string][]byte
+		wantErr  error
+	}{
+		{
+			name:     "mounted volume",
+			authPath: "volume",
+			want:     map[string][]byte{"username": []byte("issuer"), "password": []byte("secret")},
+		},
+		{
+			name:     "symlink to a directory within the credentials directory",
+			authPath: "alias",
+			want:     map[string][]byte{"username": []byte("issuer"), "password": []byte("secret")},
+		},
+		{name: "entry leading outside of the credentials directory", authPath: "escaping", wantErr: errAuthPath},
+		{name: "directory leading outside of the credentials directory", authPath: "linked", wantErr: errAuthPath},
+		{name: "missing directory", authPath: "missing", wantErr: errReadAuthPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := CredentialSourceFromIssuer(nil, &horizonapi.IssuerSpec{AuthPath: &tt.authPath}, "", root, CredentialsEnroll)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := source.Credentials(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errAuthPath) && !IsPermanent(err) {
+				t.Errorf("err = %v, want a permanent error", err)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("credentials = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentialSourceFromIssuerAuthPath(t *testing.T) {
+	tests := []struct {
+		name           string
+		authPath       string
+		credentialsDir string
+		wantErr        error
+	}{
+		{name: "relative path", authPath: "horizon", credentialsDir: "/credentials"},
+		{name: "no credentials directory", authPath: "horizon", wantErr: errAuthPathDenied},
+		{name: "absolute path", authPath: "/etc/horizon", credentialsDir: "/credentials", wantErr: errAuthPath},
+		{name: "parent directory", authPath: "../horizon", credentialsDir: "/credentials", wantErr: errAuthPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CredentialSourceFromIssuer(nil, &horizonapi.IssuerSpec{AuthPath: &tt.authPath}, "", tt.credentialsDir, CredentialsEnroll)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package horizon
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+var errInvalidCsr = errors.New("the CertificateRequest does not hold a valid CSR")
+
+// ValidateCSR checks that a CertificateRequest holds a PEM-encoded CSR that
+// parses and is signed by its own key, so that a request Horizon would
+// reject is not submitted.
+func ValidateCSR(csrPem []byte) error {
+	if len(csrPem) == 0 {
+		return fmt.Errorf("%w: the request is empty", errInvalidCsr)
+	}
+	block, _ := pem.Decode(csrPem)
+	if block == nil {
+		return fmt.Errorf("%w: no PEM block was found", errInvalidCsr)
+	}
+	if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+		return fmt.Errorf("%w: the PEM block is a %s", errInvalidCsr, block.Type)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidCsr, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidCsr, err)
+	}
+	return nil
+}
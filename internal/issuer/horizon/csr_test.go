@@ -0,0 +1,44 @@
+package horizon
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+func TestValidateCSR(t *testing.T) {
+	csrPem := newTestCSR(t, &x509.CertificateRequest{DNSNames: []string{"www.example.com"}}, nil)
+	block, _ := pem.Decode(csrPem)
+	tampered := append([]byte(nil), block.Bytes...)
+	tampered[len(tampered)-1] ^= 0xff
+	certificate := newTestCA(t, "Test CA", nil).certificate
+
+	tests := []struct {
+		name    string
+		csrPem  []byte
+		wantErr bool
+	}{
+		{name: "CSR", csrPem: csrPem},
+		{name: "legacy PEM type", csrPem: pem.EncodeToMemory(&pem.Block{Type: "NEW CERTIFICATE REQUEST", Bytes: block.Bytes})},
+		{name: "empty request", wantErr: true},
+		{name: "not PEM", csrPem: []byte("not a CSR"), wantErr: true},
+		{name: "truncated PEM", csrPem: csrPem[:len(csrPem)/2], wantErr: true},
+		{name: "truncated CSR", csrPem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: block.Bytes[:len(block.Bytes)/2]}), wantErr: true},
+		{name: "certificate", csrPem: []byte(encodePEM(certificate)), wantErr: true},
+		{name: "CSR in a certificate block", csrPem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: block.Bytes}), wantErr: true},
+		{name: "invalid signature", csrPem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: tampered}), wantErr: true},
+		{name: "CSR with leading text", csrPem: append([]byte("Generated by a tool\n"), csrPem...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCSR(tt.csrPem)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateCSR() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, errInvalidCsr) {
+				t.Errorf("ValidateCSR() error = %v, want %v", err, errInvalidCsr)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package horizon
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+)
+
+// maxDescriptionLength is the length, in characters, to which descriptions
+// are truncated so that Horizon accepts them.
+const maxDescriptionLength = 255
+
+// ExpandDescription replaces the {namespace}, {name} and {certificate}
+// placeholders of a description with the namespace and name of the
+// CertificateRequest and the name of its Certificate, and truncates it to
+// the length accepted by Horizon.
+func ExpandDescription(description string, certificateRequest *cmapi.CertificateRequest) string {
+	description = strings.NewReplacer(
+		"{namespace}", certificateRequest.Namespace,
+		"{name}", certificateRequest.Name,
+		"{certificate}", certificateRequest.Annotations[cmapi.CertificateNameKey],
+	).Replace(description)
+
+	if utf8.RuneCountInString(description) <= maxDescriptionLength {
+		return description
+	}
+	return string([]rune(description)[:maxDescriptionLength])
+}
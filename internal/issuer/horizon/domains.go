@@ -0,0 +1,80 @@
+package horizon
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	errDomainNotAllowed = errors.New("DNS name is not allowed by the issuer")
+	errSanNotAllowed    = errors.New("SAN is not allowed by an issuer restricting domains")
+)
+
+// hostname matches common names that are DNS names, which certificates may
+// be valid for just like the DNS SANs of the CSR.
+var hostname = regexp.MustCompile(`^(\*\.)?([A-Za-z0-9_-]+\.)*[A-Za-z0-9_-]+\.?$`)
+
+// ValidateDomains checks that the DNS names requested in a CSR, including
+// its common name when it is a DNS name, match one of the allowed domains. A
+// domain such as "*.example.com" matches any name under example.com, but not
+// example.com itself, other domains matching exactly. IP addresses and URIs
+// can't be matched against domains, so CSRs requesting any are rejected,
+// email addresses being left to the Horizon policy. All names are allowed
+// when allowedDomains is empty.
+func ValidateDomains(csrPem []byte, allowedDomains []string) error {
+	if len(allowedDomains) == 0 {
+		return nil
+	}
+
+	block, _ := pem.Decode(csrPem)
+	if block == nil {
+		return errors.New("failed to decode the CSR PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse the CSR: %v", err)
+	}
+
+	if len(csr.IPAddresses) > 0 {
+		return fmt.Errorf("%w: IP address %s", errSanNotAllowed, csr.IPAddresses[0])
+	}
+	if len(csr.URIs) > 0 {
+		return fmt.Errorf("%w: URI %s", errSanNotAllowed, csr.URIs[0])
+	}
+
+	names := csr.DNSNames
+	if hostname.MatchString(csr.Subject.CommonName) {
+		names = append([]string{csr.Subject.CommonName}, names...)
+	}
+	for _, name := range names {
+		if !domainAllowed(name, allowedDomains) {
+			return fmt.Errorf("%w: %s, allowed domains are %s", errDomainNotAllowed, name, strings.Join(allowedDomains, ", "))
+		}
+	}
+	return nil
+}
+
+// domainAllowed returns whether a DNS name matches one of the allowed
+// domains, ignoring case and trailing dots.
+func domainAllowed(name string, allowedDomains []string) bool {
+	name = normalizeDomain(name)
+	for _, domain := range allowedDomains {
+		domain = normalizeDomain(domain)
+		if strings.HasPrefix(domain, "*.") {
+			if strings.HasSuffix(name, domain[1:]) && len(name) > len(domain)-1 {
+				return true
+			}
+		} else if name == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
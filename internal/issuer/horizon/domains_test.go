@@ -0,0 +1,67 @@
+package horizon
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestValidateDomains(t *testing.T) {
+	allowed := []string{"*.apps.example.com", "example.com"}
+	tests := []struct {
+		name           string
+		commonName     string
+		dnsNames       []string
+		ipAddresses    []net.IP
+		uris           []string
+		emailAddresses []string
+		allowedDomains []string
+		wantErr        error
+	}{
+		{name: "any name when no domain is allowed", dnsNames: []string{"www.example.org"}},
+		{name: "any SAN when no domain is allowed", ipAddresses: []net.IP{net.ParseIP("10.0.0.1")}, uris: []string{"spiffe://example.org/app"}},
+		{name: "no DNS name", allowedDomains: allowed},
+		{name: "exact match", dnsNames: []string{"example.com"}, allowedDomains: allowed},
+		{name: "exact match ignores case and trailing dots", dnsNames: []string{"Example.COM."}, allowedDomains: allowed},
+		{name: "subdomain of an exact domain", dnsNames: []string{"www.example.com"}, allowedDomains: allowed, wantErr: errDomainNotAllowed},
+		{name: "wildcard match", dnsNames: []string{"shop.apps.example.com"}, allowedDomains: allowed},
+		{name: "wildcard match of a deeper name", dnsNames: []string{"eu.shop.apps.example.com"}, allowedDomains: allowed},
+		{name: "wildcard name", dnsNames: []string{"*.apps.example.com"}, allowedDomains: allowed},
+		{name: "wildcard domain itself", dnsNames: []string{"apps.example.com"}, allowedDomains: allowed, wantErr: errDomainNotAllowed},
+		{name: "wildcard suffix without a dot", dnsNames: []string{"shopapps.example.com"}, allowedDomains: allowed, wantErr: errDomainNotAllowed},
+		{name: "one name out of the allowed domains", dnsNames: []string{"example.com", "www.example.org"}, allowedDomains: allowed, wantErr: errDomainNotAllowed},
+		{name: "common name among the allowed domains", commonName: "shop.apps.example.com", dnsNames: []string{"shop.apps.example.com"}, allowedDomains: allowed},
+		{name: "common name out of the allowed domains", commonName: "evil.example.org", dnsNames: []string{"shop.apps.example.com"}, allowedDomains: allowed, wantErr: errDomainNotAllowed},
+		{name: "common name out of the allowed domains without SANs", commonName: "evil.example.org", allowedDomains: allowed, wantErr: errDomainNotAllowed},
+		{name: "wildcard common name out of the allowed domains", commonName: "*.example.org", allowedDomains: allowed, wantErr: errDomainNotAllowed},
+		{name: "single label common name", commonName: "evil", allowedDomains: allowed, wantErr: errDomainNotAllowed},
+		{name: "common name that is not a DNS name", commonName: "Web Server", dnsNames: []string{"example.com"}, allowedDomains: allowed},
+		{name: "IP address", dnsNames: []string{"example.com"}, ipAddresses: []net.IP{net.ParseIP("10.0.0.1")}, allowedDomains: allowed, wantErr: errSanNotAllowed},
+		{name: "URI", dnsNames: []string{"example.com"}, uris: []string{"https://evil.example.org"}, allowedDomains: allowed, wantErr: errSanNotAllowed},
+		{name: "email address", dnsNames: []string{"example.com"}, emailAddresses: []string{"admin@example.org"}, allowedDomains: allowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := &x509.CertificateRequest{
+				Subject:        pkix.Name{CommonName: tt.commonName},
+				DNSNames:       tt.dnsNames,
+				IPAddresses:    tt.ipAddresses,
+				EmailAddresses: tt.emailAddresses,
+			}
+			for _, uri := range tt.uris {
+				parsed, err := url.Parse(uri)
+				if err != nil {
+					t.Fatal(err)
+				}
+				template.URIs = append(template.URIs, parsed)
+			}
+			err := ValidateDomains(newTestCSR(t, template, nil), tt.allowedDomains)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateDomains() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
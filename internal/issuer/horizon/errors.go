@@ -0,0 +1,158 @@
+package horizon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Reasons of Ready conditions describing why Horizon could not be used.
+const (
+	ReasonAuthFailed      = "AuthFailed"
+	ReasonNetworkError    = "NetworkError"
+	ReasonPolicyRejected  = "PolicyRejected"
+	ReasonProfileNotFound = "ProfileNotFound"
+	// ReasonVirtualCaUnavailable is used when the virtual CA selected by an
+	// issuer cannot be used by its credentials.
+	ReasonVirtualCaUnavailable = "VirtualCaUnavailable"
+	// ReasonUnknownModule is used when an issuer selects a Horizon module
+	// that requests cannot be submitted to.
+	ReasonUnknownModule = "UnknownModule"
+	// ReasonUnknownTemplateParameter is used when an issuer sets a template
+	// parameter its profile does not accept.
+	ReasonUnknownTemplateParameter = "UnknownTemplateParameter"
+	// ReasonUnknownCategory is used when the category of an issuer is not
+	// one of the values of its Horizon label.
+	ReasonUnknownCategory = "UnknownCategory"
+)
+
+// ErrVirtualCaUnavailable is returned by health checks when the selected
+// virtual CA is not available on the profile.
+var ErrVirtualCaUnavailable = errors.New("virtual CA is not available")
+
+// ErrUnknownModule is returned by health checks when the selected module is
+// not a known Horizon module.
+var ErrUnknownModule = errors.New("unknown Horizon module")
+
+// ErrUnknownTemplateParameter is returned by health checks when a template
+// parameter is not accepted by the profile.
+var ErrUnknownTemplateParameter = errors.New("template parameter is not accepted by the profile")
+
+// ErrUnknownCategory is returned by health checks when the category is not
+// one of the values of the category label.
+var ErrUnknownCategory = errors.New("unknown certificate category")
+
+// PermanentError wraps errors that retrying won't solve, such as a request
+// rejected by Horizon or an invalid issuer configuration. Other errors are
+// considered transient.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent returns whether err, or any error it wraps, is permanent.
+func IsPermanent(err error) bool {
+	var permanentErr *PermanentError
+	return errors.As(err, &permanentErr)
+}
+
+// HorizonError is an error response from Horizon.
+type HorizonError struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int `json:"-"`
+	// Code and Message are the Horizon error code, such as "SEC-AUTH", and
+	// its description. Detail holds additional information, if any.
+	Code    string `json:"error"`
+	Message string `json:"message"`
+	Detail  string `json:"detail"`
+}
+
+func (e *HorizonError) Error() string {
+	msg := fmt.Sprintf("(HTTP %d) Horizon returned a %s error: %s", e.StatusCode, e.Code, e.Message)
+	if e.Detail != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Detail)
+	}
+	return msg
+}
+
+// isPermanentStatus returns whether an HTTP error status means that the
+// request will keep failing. Authentication errors are not permanent, as
+// they go away once credentials are fixed.
+func isPermanentStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return statusCode >= 400 && statusCode < 500
+}
+
+// ErrorReason returns the reason of the Ready condition describing err, or
+// fallback when err does not come from Horizon or its connection.
+func ErrorReason(err error, fallback string) string {
+	if errors.Is(err, ErrVirtualCaUnavailable) {
+		return ReasonVirtualCaUnavailable
+	}
+	if errors.Is(err, ErrUnknownModule) {
+		return ReasonUnknownModule
+	}
+	if errors.Is(err, ErrUnknownTemplateParameter) {
+		return ReasonUnknownTemplateParameter
+	}
+	if errors.Is(err, ErrUnknownCategory) {
+		return ReasonUnknownCategory
+	}
+
+	var horizonErr *HorizonError
+	if errors.As(err, &horizonErr) {
+		switch {
+		case horizonErr.StatusCode == http.StatusUnauthorized || horizonErr.StatusCode == http.StatusForbidden:
+			return ReasonAuthFailed
+		case horizonErr.StatusCode == http.StatusNotFound:
+			return ReasonProfileNotFound
+		case isPermanentStatus(horizonErr.StatusCode):
+			return ReasonPolicyRejected
+		}
+		return fallback
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return ReasonNetworkError
+	}
+	return fallback
+}
+
+// wrappedError describes an error with a sentinel error, while keeping
+// both of them available to errors.Is and errors.As.
+type wrappedError struct {
+	sentinel error
+	err      error
+}
+
+func (e *wrappedError) Error() string {
+	return fmt.Sprintf("%v: %v", e.sentinel, e.err)
+}
+
+func (e *wrappedError) Unwrap() error {
+	return e.err
+}
+
+func (e *wrappedError) Is(target error) bool {
+	return e.sentinel == target
+}
+
+// WrapError wraps err with a sentinel error. Unlike fmt.Errorf, both errors
+// remain in the chain, so that err can still be classified.
+func WrapError(sentinel error, err error) error {
+	return &wrappedError{sentinel: sentinel, err: err}
+}
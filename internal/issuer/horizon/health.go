@@ -1,7 +1,10 @@
 package horizon
 
 import (
-	"github.com/evertrust/horizon-go"
+	"context"
+	"fmt"
+	"sort"
+
 	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
 )
 
@@ -9,25 +12,128 @@ type HealthChecker interface {
 	Check() error
 }
 
-type HealthCheckerBuilder func(*horizonapi.IssuerSpec, map[string][]byte) (*HorizonHealthChecker, error)
+type HealthCheckerBuilder func(*Client, *horizonapi.IssuerSpec) (*HorizonHealthChecker, error)
 
-func HorizonHealthCheckerFromIssuer(issuerSpec *horizonapi.IssuerSpec, secretData map[string][]byte) (*HorizonHealthChecker, error) {
-	client, err := HorizonClientFromIssuer(issuerSpec, secretData)
-	if err != nil {
-		return nil, err
+// HorizonHealthCheckerFromClient builds a health checker using the client
+// of an issuer, so that it shares its connections.
+func HorizonHealthCheckerFromClient(client *Client, issuerSpec *horizonapi.IssuerSpec) (*HorizonHealthChecker, error) {
+	checker := &HorizonHealthChecker{Client: *client, Module: issuerSpec.Module, Profile: issuerSpec.Profile}
+	if issuerSpec.VirtualCa != nil {
+		checker.VirtualCa = *issuerSpec.VirtualCa
 	}
-
-	return &HorizonHealthChecker{Client: *client}, nil
+	for key := range issuerSpec.TemplateParameters {
+		checker.TemplateParameters = append(checker.TemplateParameters, key)
+	}
+	sort.Strings(checker.TemplateParameters)
+	if issuerSpec.Category != "" {
+		checker.Category = issuerSpec.Category
+		checker.CategoryLabel = categoryLabel(*issuerSpec)
+	}
+	return checker, nil
 }
 
 type HorizonHealthChecker struct {
-	Client horizon.Horizon
+	Client Client
+	// Module must be one of the known modules when set.
+	Module string
+	// Profile and VirtualCa are checked when both are set, so that an
+	// unavailable virtual CA is reported before enrolling.
+	Profile   string
+	VirtualCa string
+	// ReadClient, when set, is checked to authenticate as well, for issuers
+	// reading from Horizon with other credentials than they enroll with.
+	ReadClient *Client
+	// TemplateParameters are the keys of the template parameters of the
+	// issuer, checked against those accepted by the profile when Horizon
+	// reports them.
+	TemplateParameters []string
+	// Category is checked against the values of CategoryLabel when set and
+	// Horizon restricts them.
+	Category      string
+	CategoryLabel string
 }
 
 func (o *HorizonHealthChecker) Check() error {
-	_, err := o.Client.Http.Get("/api/v1/security/principals/self")
+	ctx := context.Background()
+	if o.Module != "" && !knownModule(o.Module) {
+		return fmt.Errorf("%w: %s, known modules: %v", ErrUnknownModule, o.Module, KnownModules)
+	}
+	if err := o.Client.Self(ctx); err != nil {
+		return err
+	}
+	if o.ReadClient != nil {
+		if err := o.ReadClient.Self(ctx); err != nil {
+			return fmt.Errorf("read credentials: %w", err)
+		}
+	}
+	if err := o.checkTemplateParameters(ctx); err != nil {
+		return err
+	}
+	if err := o.checkCategory(ctx); err != nil {
+		return err
+	}
+	if o.VirtualCa == "" || o.Profile == "" {
+		return nil
+	}
+
+	virtualCas, err := o.Client.VirtualCas(ctx, o.Module, o.Profile)
 	if err != nil {
 		return err
 	}
+	for _, virtualCa := range virtualCas {
+		if virtualCa == o.VirtualCa {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s on profile %s, available virtual CAs: %v", ErrVirtualCaUnavailable, o.VirtualCa, o.Profile, virtualCas)
+}
+
+// checkTemplateParameters checks the template parameters against those
+// accepted by the profile. Nothing is checked when Horizon does not report
+// them.
+func (o *HorizonHealthChecker) checkTemplateParameters(ctx context.Context) error {
+	if len(o.TemplateParameters) == 0 || o.Profile == "" {
+		return nil
+	}
+	accepted, err := o.Client.TemplateParameters(ctx, o.Module, o.Profile)
+	if err != nil || accepted == nil {
+		return err
+	}
+	for _, key := range o.TemplateParameters {
+		found := false
+		for _, acceptedKey := range accepted {
+			found = found || key == acceptedKey
+		}
+		if !found {
+			return fmt.Errorf("%w: %s on profile %s, accepted parameters: %v", ErrUnknownTemplateParameter, key, o.Profile, accepted)
+		}
+	}
 	return nil
 }
+
+func knownModule(module string) bool {
+	for _, known := range KnownModules {
+		if module == known {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCategory checks the category against the values of its label.
+// Nothing is checked when the label accepts any value.
+func (o *HorizonHealthChecker) checkCategory(ctx context.Context) error {
+	if o.Category == "" {
+		return nil
+	}
+	values, err := o.Client.LabelValues(ctx, o.CategoryLabel)
+	if err != nil || values == nil {
+		return err
+	}
+	for _, value := range values {
+		if value == o.Category {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s, values of label %s: %v", ErrUnknownCategory, o.Category, o.CategoryLabel, values)
+}
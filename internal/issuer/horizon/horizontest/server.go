@@ -0,0 +1,650 @@
+// Package horizontest provides a fake Horizon server implementing the subset
+// of the Horizon API used by the issuer, so that the controllers can be
+// exercised without a real Horizon instance.
+package horizontest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evertrust/horizon-go/certificates"
+	"github.com/evertrust/horizon-go/requests"
+	"github.com/evertrust/horizon-go/rfc5280"
+)
+
+// Endpoint identifies one of the Horizon API endpoints served by the fake.
+type Endpoint string
+
+const (
+	EndpointSelf       Endpoint = "self"
+	EndpointPkcs10     Endpoint = "pkcs10"
+	EndpointSubmit     Endpoint = "submit"
+	EndpointGetRequest Endpoint = "get-request"
+	EndpointTemplate   Endpoint = "template"
+	EndpointProfiles   Endpoint = "profiles"
+	EndpointSearch     Endpoint = "search"
+	EndpointCancel     Endpoint = "cancel"
+	EndpointIdentity   Endpoint = "identity"
+)
+
+// Failure describes an error response returned by an endpoint instead of
+// its regular answer.
+type Failure struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+	// Code and Message are returned in Horizon's JSON error format.
+	Code    string
+	Message string
+	// RetryAfter, if set, is sent as the Retry-After header.
+	RetryAfter string
+	// Times is the number of requests the failure applies to before the
+	// endpoint recovers. Zero means the endpoint keeps failing.
+	Times int
+}
+
+// Server is a fake Horizon instance backed by an httptest.Server. Submitted
+// requests are kept in memory and stay pending until they are issued,
+// approved or denied through the Server methods, unless AutoIssue is set.
+type Server struct {
+	*httptest.Server
+
+	// Username and Password are the expected API credentials. Requests are
+	// not authenticated when both are empty.
+	Username string
+	Password string
+
+	// AutoIssue makes enroll requests complete as soon as they are submitted.
+	AutoIssue bool
+
+	// VirtualCas are the virtual CAs reported in enroll templates.
+	VirtualCas []string
+
+	// Profiles are the profiles reported as available for enrollment.
+	Profiles []string
+
+	// Identities are the identities of the Horizon directory.
+	Identities []string
+
+	mu       sync.Mutex
+	delay    time.Duration
+	failures map[Endpoint]*Failure
+	requests map[string]*requests.HorizonRequest
+	calls    map[Endpoint]int
+	nextId   int
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+	caPem  string
+}
+
+// NewServer starts a fake Horizon server over plain HTTP.
+func NewServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewTLSServer starts a fake Horizon server over HTTPS, using a certificate
+// that can be trusted through s.Certificate().
+func NewTLSServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+func newServer() *Server {
+	s := &Server{
+		failures: make(map[Endpoint]*Failure),
+		requests: make(map[string]*requests.HorizonRequest),
+		calls:    make(map[Endpoint]int),
+	}
+	if err := s.initCA(); err != nil {
+		panic(fmt.Sprintf("horizontest: unable to create the CA: %v", err))
+	}
+	return s
+}
+
+// CAPem returns the PEM-encoded CA certificate used to sign issued certificates.
+func (s *Server) CAPem() string {
+	return s.caPem
+}
+
+// SetDelay delays every subsequent answer of the server by d.
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Fail makes the endpoint answer with the given failure.
+func (s *Server) Fail(endpoint Endpoint, failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[endpoint] = &failure
+}
+
+// Recover clears any failure configured on the endpoint.
+func (s *Server) Recover(endpoint Endpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, endpoint)
+}
+
+// Calls returns the number of requests received by an endpoint.
+func (s *Server) Calls(endpoint Endpoint) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[endpoint]
+}
+
+// Request returns a copy of a request submitted to the server.
+func (s *Server) Request(id string) (requests.HorizonRequest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	request, ok := s.requests[id]
+	if !ok {
+		return requests.HorizonRequest{}, false
+	}
+	return *request, true
+}
+
+// Requests returns copies of all the requests submitted to the server.
+func (s *Server) Requests() []requests.HorizonRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []requests.HorizonRequest
+	for i := 1; i <= s.nextId; i++ {
+		if request, ok := s.requests[requestId(i)]; ok {
+			all = append(all, *request)
+		}
+	}
+	return all
+}
+
+// Approve marks a pending request as approved without issuing it yet.
+func (s *Server) Approve(id string) error {
+	return s.setStatus(id, requests.RequestStatusApproved)
+}
+
+// Deny marks a request as denied.
+func (s *Server) Deny(id string) error {
+	return s.setStatus(id, requests.RequestStatusDenied)
+}
+
+// Cancel marks a request as canceled.
+func (s *Server) Cancel(id string) error {
+	return s.setStatus(id, requests.RequestStatusCanceled)
+}
+
+// Issue signs the CSR of an enroll request and marks it as completed.
+func (s *Server) Issue(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	request, ok := s.requests[id]
+	if !ok {
+		return fmt.Errorf("no request with id %s", id)
+	}
+	return s.issue(request)
+}
+
+func (s *Server) setStatus(id string, status requests.RequestStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	request, ok := s.requests[id]
+	if !ok {
+		return fmt.Errorf("no request with id %s", id)
+	}
+	request.Status = status
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var endpoint Endpoint
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/security/principals/self":
+		endpoint = EndpointSelf
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/rfc5280/pkcs10/"):
+		endpoint = EndpointPkcs10
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/requests/submit":
+		endpoint = EndpointSubmit
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/requests/template":
+		endpoint = EndpointTemplate
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/requests/profiles":
+		endpoint = EndpointProfiles
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/requests/search":
+		endpoint = EndpointSearch
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/requests/cancel":
+		endpoint = EndpointCancel
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/requests/"):
+		endpoint = EndpointGetRequest
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/security/identities/"):
+		endpoint = EndpointIdentity
+	default:
+		writeError(w, http.StatusNotFound, "NotFound", "Unknown endpoint "+r.URL.Path)
+		return
+	}
+
+	s.mu.Lock()
+	s.calls[endpoint]++
+	delay := s.delay
+	failure := s.failures[endpoint]
+	if failure != nil && failure.Times > 0 {
+		failure.Times--
+		if failure.Times == 0 {
+			delete(s.failures, endpoint)
+		}
+	}
+	s.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if failure != nil {
+		if failure.RetryAfter != "" {
+			w.Header().Set("Retry-After", failure.RetryAfter)
+		}
+		writeError(w, failure.StatusCode, failure.Code, failure.Message)
+		return
+	}
+
+	if (s.Username != "" || s.Password != "") &&
+		(r.Header.Get("x-api-id") != s.Username || r.Header.Get("x-api-key") != s.Password) {
+		writeError(w, http.StatusUnauthorized, "SEC-AUTH", "Invalid credentials")
+		return
+	}
+
+	switch endpoint {
+	case EndpointSelf:
+		writeJSON(w, map[string]string{"identifier": s.Username})
+	case EndpointPkcs10:
+		s.handlePkcs10(w, strings.TrimPrefix(r.URL.Path, "/api/v1/rfc5280/pkcs10/"))
+	case EndpointSubmit:
+		s.handleSubmit(w, r)
+	case EndpointGetRequest:
+		s.handleGetRequest(w, strings.TrimPrefix(r.URL.Path, "/api/v1/requests/"))
+	case EndpointTemplate:
+		s.handleTemplate(w, r)
+	case EndpointProfiles:
+		s.handleProfiles(w)
+	case EndpointSearch:
+		s.handleSearch(w, r)
+	case EndpointCancel:
+		s.handleCancel(w, r)
+	case EndpointIdentity:
+		s.handleIdentity(w, strings.TrimPrefix(r.URL.Path, "/api/v1/security/identities/"))
+	}
+}
+
+func (s *Server) handlePkcs10(w http.ResponseWriter, csrPem string) {
+	csr, err := parseCsr(csrPem)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "RFC5280-PKCS10", err.Error())
+		return
+	}
+
+	parsed := rfc5280.CFCertificationRequest{
+		Dn:  csr.Subject.String(),
+		Pem: csrPem,
+	}
+	for _, name := range csr.Subject.Names {
+		parsed.DnElements = append(parsed.DnElements, rfc5280.CFDistinguishedName{
+			Type:  dnType(name),
+			Value: fmt.Sprintf("%v", name.Value),
+		})
+	}
+	for _, dnsName := range csr.DNSNames {
+		parsed.Sans = append(parsed.Sans, rfc5280.SubjectAlternateName{SanType: "DNSNAME", Value: dnsName})
+	}
+	for _, ip := range csr.IPAddresses {
+		parsed.Sans = append(parsed.Sans, rfc5280.SubjectAlternateName{SanType: "IPADDRESS", Value: ip.String()})
+	}
+	for _, email := range csr.EmailAddresses {
+		parsed.Sans = append(parsed.Sans, rfc5280.SubjectAlternateName{SanType: "RFC822NAME", Value: email})
+	}
+	for _, uri := range csr.URIs {
+		parsed.Sans = append(parsed.Sans, rfc5280.SubjectAlternateName{SanType: "URI", Value: uri.String()})
+	}
+
+	writeJSON(w, parsed)
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var request requests.HorizonRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "REQ-FORMAT", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextId++
+	request.Id = requestId(s.nextId)
+	request.Status = requests.RequestStatusPending
+	request.RegistrationDate = int(time.Now().UnixNano() / int64(time.Millisecond))
+	request.LastModificationDate = request.RegistrationDate
+	s.requests[request.Id] = &request
+
+	switch request.Workflow {
+	case requests.RequestWorkflowEnroll, "renew":
+		if s.AutoIssue {
+			if err := s.issue(&request); err != nil {
+				writeError(w, http.StatusBadRequest, "WEBRA-ENROLL", err.Error())
+				return
+			}
+		}
+	default:
+		request.Status = requests.RequestStatusCompleted
+	}
+
+	writeJSON(w, request)
+}
+
+// requestId returns the ID of the nth submitted request, formatted like the
+// object IDs Horizon identifies requests with.
+func requestId(n int) string {
+	return fmt.Sprintf("%024x", n)
+}
+
+func (s *Server) handleGetRequest(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, ok := s.requests[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "REQ-NOT-FOUND", "No request with id "+id)
+		return
+	}
+	writeJSON(w, request)
+}
+
+func (s *Server) handleIdentity(w http.ResponseWriter, identifier string) {
+	for _, identity := range s.Identities {
+		if identity == identifier {
+			writeJSON(w, map[string]string{"identifier": identity})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "SEC-IDENTITY-NOT-FOUND", "No identity "+identifier)
+}
+
+// handleCancel cancels a pending or approved request, which must be
+// designated with its workflow as Horizon does.
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	var cancel requests.HorizonRequest
+	if err := json.NewDecoder(r.Body).Decode(&cancel); err != nil {
+		writeError(w, http.StatusBadRequest, "REQ-FORMAT", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, ok := s.requests[cancel.Id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "REQ-NOT-FOUND", "No request with id "+cancel.Id)
+		return
+	}
+	if cancel.Workflow != request.Workflow {
+		writeError(w, http.StatusBadRequest, "REQ-WORKFLOW", fmt.Sprintf("Request %s is a %s request, not a %s one", request.Id, request.Workflow, cancel.Workflow))
+		return
+	}
+	if request.Status != requests.RequestStatusPending && request.Status != requests.RequestStatusApproved {
+		writeError(w, http.StatusBadRequest, "REQ-STATUS", fmt.Sprintf("Request %s is %s and cannot be canceled", request.Id, request.Status))
+		return
+	}
+	request.Status = requests.RequestStatusCanceled
+	writeJSON(w, request)
+}
+
+func (s *Server) handleTemplate(w http.ResponseWriter, r *http.Request) {
+	var request requests.HorizonRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "REQ-FORMAT", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request.Template = map[string]interface{}{"virtualCas": s.VirtualCas}
+	writeJSON(w, request)
+}
+
+func (s *Server) handleProfiles(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profiles := make([]map[string]string, 0, len(s.Profiles))
+	for _, name := range s.Profiles {
+		profiles = append(profiles, map[string]string{"name": name})
+	}
+	writeJSON(w, profiles)
+}
+
+// searchLabel matches the label criteria of search queries, and
+// searchWorkflows their workflow criterion.
+var (
+	searchLabel     = regexp.MustCompile(`labels\.([^ ]+) equals "([^"]*)"`)
+	searchWorkflows = regexp.MustCompile(`workflow in \[([^\]]*)\]`)
+)
+
+// handleSearch only understands the label and workflow criteria of the
+// query, and returns the matching requests that are pending, approved or
+// completed.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var search struct {
+		Query string `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+		writeError(w, http.StatusBadRequest, "REQ-FORMAT", err.Error())
+		return
+	}
+	criteria := searchLabel.FindAllStringSubmatch(search.Query, -1)
+	var workflows []string
+	if match := searchWorkflows.FindStringSubmatch(search.Query); match != nil {
+		for _, workflow := range strings.Split(match[1], ",") {
+			workflows = append(workflows, strings.Trim(strings.TrimSpace(workflow), `"`))
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := []*requests.HorizonRequest{}
+	for _, request := range s.requests {
+		switch request.Status {
+		case requests.RequestStatusPending, requests.RequestStatusApproved, requests.RequestStatusCompleted:
+		default:
+			continue
+		}
+		if hasWorkflow(request, workflows) && hasLabels(request, criteria) {
+			results = append(results, request)
+		}
+	}
+	writeJSON(w, map[string]interface{}{"results": results})
+}
+
+// hasWorkflow returns whether a request has one of the given workflows, or
+// any workflow when none are given.
+func hasWorkflow(request *requests.HorizonRequest, workflows []string) bool {
+	if len(workflows) == 0 {
+		return true
+	}
+	for _, workflow := range workflows {
+		if string(request.Workflow) == workflow {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLabels(request *requests.HorizonRequest, criteria [][]string) bool {
+	// Labels are part of the template, which was decoded as a generic map
+	raw, err := json.Marshal(request.Template)
+	if err != nil {
+		return false
+	}
+	var template requests.WebRARequestTemplate
+	if err := json.Unmarshal(raw, &template); err != nil {
+		return false
+	}
+	for _, criterion := range criteria {
+		found := false
+		for _, label := range template.Labels {
+			if label.Label == criterion[1] && label.Value == criterion[2] {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// issue must be called with s.mu held.
+func (s *Server) issue(request *requests.HorizonRequest) error {
+	// The template was decoded as a generic map, round-trip it to read the CSR
+	raw, err := json.Marshal(request.Template)
+	if err != nil {
+		return err
+	}
+	var template requests.WebRARequestTemplate
+	if err := json.Unmarshal(raw, &template); err != nil {
+		return err
+	}
+	csr, err := parseCsr(template.Csr)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	leaf := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        csr.Subject,
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		EmailAddresses: csr.EmailAddresses,
+		URIs:           csr.URIs,
+		NotBefore:      now.Add(-time.Minute),
+		NotAfter:       now.Add(90 * 24 * time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		return err
+	}
+	certificatePem := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+	request.Status = requests.RequestStatusCompleted
+	request.CertificatePEM = certificatePem
+	request.Certificate = &certificates.Certificate{
+		Module:      request.Module,
+		Profile:     request.Profile,
+		Certificate: certificatePem,
+		Dn:          csr.Subject.String(),
+		Serial:      fmt.Sprintf("%x", serial),
+		Issuer:      s.caCert.Subject.String(),
+		NotBefore:   int(leaf.NotBefore.UnixNano() / int64(time.Millisecond)),
+		NotAfter:    int(leaf.NotAfter.UnixNano() / int64(time.Millisecond)),
+	}
+	return nil
+}
+
+func (s *Server) initCA() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Horizon Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	s.caKey = key
+	s.caCert = cert
+	s.caPem = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return nil
+}
+
+func parseCsr(csrPem string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(csrPem))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in CSR")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return csr, csr.CheckSignature()
+}
+
+var dnTypes = map[string]string{
+	"2.5.4.3":  "CN",
+	"2.5.4.5":  "SERIALNUMBER",
+	"2.5.4.6":  "C",
+	"2.5.4.7":  "L",
+	"2.5.4.8":  "ST",
+	"2.5.4.9":  "STREET",
+	"2.5.4.10": "O",
+	"2.5.4.11": "OU",
+	"2.5.4.17": "POSTALCODE",
+}
+
+func dnType(name pkix.AttributeTypeAndValue) string {
+	if t, ok := dnTypes[name.Type.String()]; ok {
+		return t
+	}
+	return name.Type.String()
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error":   code,
+		"message": message,
+	})
+}
@@ -2,48 +2,348 @@ package horizon
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/evertrust/horizon-go"
+	"github.com/evertrust/horizon-go/certificates"
 	"github.com/evertrust/horizon-go/requests"
+	"github.com/evertrust/horizon-go/rfc5280"
 	"github.com/evertrust/horizon-issuer/api/v1alpha1"
 	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
 	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
 	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	"hash/fnv"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/record"
+	"math"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+	"sync"
 	"time"
 )
 
 const IssuerNamespace = "horizon.evertrust.io"
+
+// EventReasonBrokenChain is the reason of the warning recorded when Horizon
+// returns a certificate chain that can't be ordered.
+const EventReasonBrokenChain = "BrokenChain"
+
+// EventReasonAmbiguousRequest is the reason of the warning recorded when
+// several Horizon requests carry the correlation ID of a request to adopt.
+const EventReasonAmbiguousRequest = "AmbiguousRequest"
+
 const (
 	RequestIdAnnotation = IssuerNamespace + "/request-id"
 	OwnerAnnotation     = IssuerNamespace + "/owner"
 	TeamAnnotation      = IssuerNamespace + "/team"
+	// LabelAnnotationPrefix is followed by the name of a label to set
+	LabelAnnotationPrefix = IssuerNamespace + "/label-"
+	// TemplateParameterAnnotationPrefix is followed by the key of a template
+	// parameter to set
+	TemplateParameterAnnotationPrefix = IssuerNamespace + "/template-parameter-"
+	// CommonNameAnnotation sets the common name of CSRs that have none
+	CommonNameAnnotation = IssuerNamespace + "/common-name"
+	// CorrelationIdAnnotation identifies a CertificateRequest across Kubernetes,
+	// the controller logs and Horizon
+	CorrelationIdAnnotation = IssuerNamespace + "/correlation-id"
+	// ForceReenrollAnnotation discards the Horizon request of a CertificateRequest
+	// so that its CSR is submitted again, when set to "true"
+	ForceReenrollAnnotation = IssuerNamespace + "/force-reenroll"
+	// VirtualCaAnnotation selects the virtual CA used to issue the certificate
+	VirtualCaAnnotation = IssuerNamespace + "/virtual-ca"
+	// CertificateUrlAnnotation links to the issued certificate request in the Horizon UI
+	CertificateUrlAnnotation = IssuerNamespace + "/certificate-url"
+	// SerialNumberAnnotation and NotAfterAnnotation describe the issued certificate
+	SerialNumberAnnotation = IssuerNamespace + "/serial-number"
+	NotAfterAnnotation     = IssuerNamespace + "/not-after"
+	// OcspServersAnnotation and CrlDistributionPointsAnnotation list the
+	// comma-separated revocation URLs of the issued certificate, if any
+	OcspServersAnnotation           = IssuerNamespace + "/ocsp-servers"
+	CrlDistributionPointsAnnotation = IssuerNamespace + "/crl-distribution-points"
+	// ThumbprintAnnotationPrefix is followed by the algorithm of the
+	// thumbprint of the issued certificate, such as sha256
+	ThumbprintAnnotationPrefix = IssuerNamespace + "/thumbprint-"
+	// CertificateIdAnnotation, ProfileAnnotation and IssuedAtAnnotation
+	// describe the issued certificate on issuers setting metadataAnnotations
+	CertificateIdAnnotation = IssuerNamespace + "/certificate-id"
+	ProfileAnnotation       = IssuerNamespace + "/profile"
+	IssuedAtAnnotation      = IssuerNamespace + "/issued-at"
+	// RenewalTimeAnnotation is when Horizon recommends renewing the issued certificate
+	RenewalTimeAnnotation = IssuerNamespace + "/renewal-time"
+	// AdoptRequestIdAnnotation makes a CertificateRequest adopt a request
+	// created on Horizon by other means instead of submitting its CSR
+	AdoptRequestIdAnnotation = IssuerNamespace + "/adopt-request-id"
+	// CategoryAnnotation overrides the category of the certificate
+	CategoryAnnotation = IssuerNamespace + "/category"
+	// DeliveryAnnotation tracks whether the certificate of an issued request
+	// was written to its Secret, when delivery is verified
+	DeliveryAnnotation = IssuerNamespace + "/delivery"
+	// IncludeChainAnnotation overrides, on a Certificate, whether the chain
+	// is stored along with the issued certificate
+	IncludeChainAnnotation = IssuerNamespace + "/include-chain"
+	// DescriptionAnnotation overrides the description of the request shown to approvers
+	DescriptionAnnotation = IssuerNamespace + "/description"
+
+	SubjectOrganizationAnnotation       = IssuerNamespace + "/subject-o"
+	SubjectOrganizationalUnitAnnotation = IssuerNamespace + "/subject-ou"
+	SubjectCountryAnnotation            = IssuerNamespace + "/subject-c"
+	SubjectLocalityAnnotation           = IssuerNamespace + "/subject-l"
+	SubjectProvinceAnnotation           = IssuerNamespace + "/subject-st"
 )
 
+// defaultUnavailableRequeueAfter is used when Horizon is unavailable and
+// neither Horizon nor the configuration suggest a delay before retrying.
+const defaultUnavailableRequeueAfter = 30 * time.Second
+
+// submissionTTL is how long submitted requests are remembered, which only
+// needs to cover the time it takes to persist their ID.
+const submissionTTL = time.Hour
+
+// concurrencyLimitRequeueAfter is the delay after which a request is retried
+// when its issuer has too many calls to Horizon in flight.
+const concurrencyLimitRequeueAfter = 5 * time.Second
+
+// defaultPollInterval is the delay between two polls of a pending request
+// when the configuration does not set one.
+const defaultPollInterval = 15 * time.Second
+
+// defaultSubmittedPollInterval is the delay before the first poll of a
+// submitted request when the configuration does not set one.
+const defaultSubmittedPollInterval = 10 * time.Second
+
+// RequeueSettings are the delays after which requests are reconciled again,
+// which may be changed at runtime.
+type RequeueSettings struct {
+	// UnavailableRequeueAfter is the delay after which a request is retried when
+	// Horizon is temporarily unavailable and did not send a Retry-After header.
+	UnavailableRequeueAfter time.Duration
+	// SubmittedPollInterval is the delay before the first poll of a request
+	// after its submission, short so that requests approved automatically
+	// are issued quickly.
+	SubmittedPollInterval time.Duration
+	// PollInterval is the delay between the next polls of a request, while
+	// it waits for an approval.
+	PollInterval time.Duration
+	// Jitter is the percentage by which the delay between two polls of a
+	// request varies, so that requests submitted together are not all
+	// polled at the same time.
+	Jitter int
+	// NotReadyRequeueAfter is the delay after which a request is retried
+	// while its issuer is not ready. Zero leaves the retries to the backoff
+	// of the controller.
+	NotReadyRequeueAfter time.Duration
+}
+
+// CertificateMetadata holds the information sent to Horizon along with
+// the CSR of a CertificateRequest.
+type CertificateMetadata struct {
+	Labels []requests.LabelElement
+	Owner  *string
+	Team   *string
+	// Description is shown to the approvers of the request on Horizon.
+	Description string
+	// Subject holds DN elements merged into the subject of the CSR.
+	Subject []rfc5280.CFDistinguishedName
+	// CommonName is used when the CSR has no common name.
+	CommonName string
+	// KeyUsages and ExtendedKeyUsages are passed explicitly to Horizon,
+	// as the CSR may not reflect all the usages of the Certificate.
+	KeyUsages         []string
+	ExtendedKeyUsages []string
+	// VirtualCa is the virtual CA of the profile used to issue the
+	// certificate, or empty to let Horizon choose.
+	VirtualCa string
+	// IsCA requests a CA certificate, on profiles allowing it.
+	IsCA bool
+	// TemplateParameters are passed verbatim in the enroll template.
+	TemplateParameters map[string]string
+	// Category is sent as the category label of the issuer, replacing
+	// other values of that label, unless empty.
+	Category string
+	// RenewedCertificate is the PEM-encoded certificate renewed by the
+	// request, or empty to enroll a new certificate.
+	RenewedCertificate string
+}
+
 type HorizonIssuer struct {
-	Client horizon.Horizon
+	Client Client
+	// ReadClient polls and looks up requests, for issuers reading from
+	// Horizon with other credentials than they enroll with. Client is used
+	// when nil.
+	ReadClient *Client
+	// Requeue holds the delays after which requests are reconciled again.
+	// It is read through requeueSettings, as Reconfigure may replace it
+	// while requests are reconciled.
+	Requeue   RequeueSettings
+	requeueMu sync.RWMutex
+	// AdoptRequests searches Horizon for a request carrying the correlation
+	// ID label before submitting a request whose correlation ID was already
+	// set, so that losing the request ID annotation doesn't submit it twice.
+	AdoptRequests bool
+	// Audit records the enrollments, renewals, revocations and cancellations
+	// performed on Horizon. Nothing is recorded when nil.
+	Audit *AuditLog
+	// Recorder records warnings on CertificateRequests, such as broken
+	// certificate chains. Nothing is recorded when nil.
+	Recorder record.EventRecorder
+	// Clock is the source of the times submissions and owner lookups are
+	// remembered from. The real clock is used when nil.
+	Clock clock.Clock
+
+	// submissions remembers the requests submitted to Horizon by submission
+	// key, so that a request whose ID failed to be persisted on the
+	// CertificateRequest isn't submitted twice by this process. Requests
+	// submitted by a previous process are found by their correlation ID when
+	// AdoptRequests is set.
+	mu          sync.Mutex
+	submissions map[string]submission
 }
 
-func (r *HorizonIssuer) SubmitRequest(ctx context.Context, client client.Client, issuer v1alpha1.IssuerSpec, labels []requests.LabelElement, owner *string, team *string, certificateRequest *cmapi.CertificateRequest) (result ctrl.Result, err error) {
-	logger := log.FromContext(ctx)
+type submission struct {
+	requestId   string
+	submittedAt time.Time
+}
 
-	logger.Info(fmt.Sprintf("Submitting request %s to profile %s", certificateRequest.UID, issuer.Profile))
-	request, err := r.Client.Requests.DecentralizedEnroll(
-		issuer.Profile,
-		certificateRequest.Spec.Request,
-		labels,
-		owner,
-		team,
-	)
+func (r *HorizonIssuer) SubmitRequest(ctx context.Context, client client.Client, issuer v1alpha1.IssuerSpec, metadata CertificateMetadata, certificateRequest *cmapi.CertificateRequest) (result ctrl.Result, err error) {
+	// The correlation ID is generated once, and persisted before the request
+	// is submitted, so that retries reuse it
+	correlationId := certificateRequest.Annotations[CorrelationIdAnnotation]
+	previouslyCorrelated := correlationId != ""
+	if correlationId == "" {
+		correlationId = string(uuid.NewUUID())
+		setAnnotation(certificateRequest, CorrelationIdAnnotation, correlationId)
+	}
+	if issuer.CorrelationIdLabel != "" {
+		metadata.Labels = withLabel(metadata.Labels, issuer.CorrelationIdLabel, correlationId)
+	}
+	if metadata.Category != "" {
+		metadata.Labels = withLabel(metadata.Labels, categoryLabel(issuer), metadata.Category)
+	}
+	logger := log.FromContext(ctx).WithValues("correlationId", correlationId)
+
+	if requestId := certificateRequest.Annotations[AdoptRequestIdAnnotation]; requestId != "" {
+		return r.adoptRequest(ctx, issuer, requestId, certificateRequest)
+	}
+
+	key := submissionKey(certificateRequest, issuer.Profile)
+	if requestId, ok := r.submitted(key); ok {
+		logger.Info(fmt.Sprintf("Request %s was already submitted as %s, not submitting it again", certificateRequest.UID, requestId))
+		return r.handleSubmittedRequest(requestId, certificateRequest)
+	}
+
+	// A request that was correlated before may have been submitted with its
+	// request ID lost since, in which case it is adopted
+	if r.AdoptRequests && previouslyCorrelated && issuer.CorrelationIdLabel != "" {
+		requestId, matches, err := r.Reader().FindRequest(ctx, issuer.Module, issuer.Profile, issuer.CorrelationIdLabel, correlationId)
+		var unavailableErr *UnavailableError
+		if errors.As(err, &unavailableErr) {
+			return r.handleUnavailable(ctx, unavailableErr, certificateRequest)
+		}
+		if errors.Is(err, ErrConcurrencyLimit) {
+			return ctrl.Result{RequeueAfter: r.jitter(concurrencyLimitRequeueAfter, certificateRequest)}, nil
+		}
+		if err != nil {
+			return ctrl.Result{}, WrapError(errors.New("unable to search Horizon for a previous request"), err)
+		}
+		if requestId != "" {
+			logger.Info(fmt.Sprintf("Request %s was found on Horizon as %s, adopting it", certificateRequest.UID, requestId))
+			r.recordSubmission(key, requestId)
+			result, err := r.handleSubmittedRequest(requestId, certificateRequest)
+			// Several requests carrying the same correlation ID means it was
+			// submitted more than once, which the operator should look into
+			if matches > 1 {
+				message := fmt.Sprintf("Found %d requests carrying correlation ID %s on Horizon, adopted %s", matches, correlationId, requestId)
+				logger.Info(message)
+				if r.Recorder != nil {
+					r.Recorder.Event(certificateRequest, corev1.EventTypeWarning, EventReasonAmbiguousRequest, message)
+				}
+				cmutil.SetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady, cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, message)
+			}
+			return result, err
+		}
+	}
+
+	// Persisting the correlation ID first marks the request as possibly
+	// submitted, should its ID fail to be persisted once it is submitted, so
+	// that retries look for it on Horizon rather than submit it again
+	if !previouslyCorrelated {
+		if err := persistAnnotations(ctx, client, certificateRequest); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to persist the correlation ID before submitting the request: %w", err)
+		}
+	}
+
+	options := EnrollOptions{
+		Module:            issuer.Module,
+		OverrideSubject:   issuer.OverrideSubject == v1alpha1.SubjectOverrideAlways,
+		CommonNameFromSan: issuer.CommonNameFromSan,
+		UpnSanType:        issuer.UpnSanType,
+	}
+	var request *requests.HorizonRequest
+	action := AuditActionEnroll
+	if metadata.RenewedCertificate != "" {
+		action = AuditActionRenew
+		logger.Info(fmt.Sprintf("Submitting request %s to profile %s as a renewal", certificateRequest.UID, issuer.Profile))
+		request, err = r.Client.DecentralizedRenew(ctx, issuer.Profile, certificateRequest.Spec.Request, metadata.RenewedCertificate, metadata, options)
+	} else {
+		logger.Info(fmt.Sprintf("Submitting request %s to profile %s", certificateRequest.UID, issuer.Profile))
+		request, err = r.Client.DecentralizedEnroll(ctx, issuer.Profile, certificateRequest.Spec.Request, metadata, options)
+	}
+	if !errors.Is(err, ErrConcurrencyLimit) {
+		var requestId string
+		if request != nil {
+			requestId = request.Id
+		}
+		r.Audit.record(certificateRequest, action, issuer.Profile, requestId, err)
+	}
+	var unavailableErr *UnavailableError
+	if errors.As(err, &unavailableErr) {
+		return r.handleUnavailable(ctx, unavailableErr, certificateRequest)
+	}
+	if errors.Is(err, ErrConcurrencyLimit) {
+		return ctrl.Result{RequeueAfter: r.jitter(concurrencyLimitRequeueAfter, certificateRequest)}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, WrapError(errors.New("unable to sign the CSR using Horizon"), err)
+	}
+	r.recordSubmission(key, request.Id)
+
+	return r.handleSubmittedRequest(request.Id, certificateRequest)
+}
+
+// adoptRequest makes a CertificateRequest poll a request that was created
+// on Horizon by other means, once checked that it is an enroll or renew
+// request of the profile of the issuer.
+func (r *HorizonIssuer) adoptRequest(ctx context.Context, issuer v1alpha1.IssuerSpec, requestId string, certificateRequest *cmapi.CertificateRequest) (ctrl.Result, error) {
+	request, err := r.Reader().GetRequest(ctx, requestId)
+	var unavailableErr *UnavailableError
+	if errors.As(err, &unavailableErr) {
+		return r.handleUnavailable(ctx, unavailableErr, certificateRequest)
+	}
+	if errors.Is(err, ErrConcurrencyLimit) {
+		return ctrl.Result{RequeueAfter: r.jitter(concurrencyLimitRequeueAfter, certificateRequest)}, nil
+	}
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("%w: %v", errors.New("unable to sign the CSR using Horizon"), err)
+		return ctrl.Result{}, WrapError(fmt.Errorf("unable to fetch request %s to adopt from Horizon", requestId), err)
 	}
 
+	if (request.Workflow != requests.RequestWorkflowEnroll && request.Workflow != requestWorkflowRenew) || request.Profile != issuer.Profile {
+		return ctrl.Result{}, &PermanentError{Err: fmt.Errorf("request %s to adopt is a %s request on profile %s, expected an enroll or renew request on profile %s", requestId, request.Workflow, request.Profile, issuer.Profile)}
+	}
+
+	log.FromContext(ctx).Info(fmt.Sprintf("Adopting request %s for %s", requestId, certificateRequest.UID))
+	return r.handleSubmittedRequest(requestId, certificateRequest)
+}
+
+func (r *HorizonIssuer) handleSubmittedRequest(requestId string, certificateRequest *cmapi.CertificateRequest) (result ctrl.Result, err error) {
 	// Update the request with the Horizon request ID
-	certificateRequest.Annotations[RequestIdAnnotation] = request.Id
+	setAnnotation(certificateRequest, RequestIdAnnotation, requestId)
 
 	cmutil.SetCertificateRequestCondition(
 		certificateRequest,
@@ -53,63 +353,342 @@ func (r *HorizonIssuer) SubmitRequest(ctx context.Context, client client.Client,
 		"Submitted request to Horizon",
 	)
 
+	pollInterval := r.requeueSettings().SubmittedPollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultSubmittedPollInterval
+	}
+
 	return ctrl.Result{
 		Requeue:      true,
-		RequeueAfter: time.Minute,
+		RequeueAfter: r.jitter(pollInterval, certificateRequest),
 	}, nil
 }
 
-func (r *HorizonIssuer) UpdateRequest(ctx context.Context, certificateRequest *cmapi.CertificateRequest) (result ctrl.Result, err error) {
+func (r *HorizonIssuer) UpdateRequest(ctx context.Context, issuer v1alpha1.IssuerSpec, certificateRequest *cmapi.CertificateRequest) (result ctrl.Result, err error) {
 	logger := log.FromContext(ctx)
 
-	request, err := r.Client.Requests.Get(certificateRequest.Annotations[RequestIdAnnotation])
+	request, err := r.Reader().GetRequest(ctx, certificateRequest.Annotations[RequestIdAnnotation])
+	var unavailableErr *UnavailableError
+	if errors.As(err, &unavailableErr) {
+		return r.handleUnavailable(ctx, unavailableErr, certificateRequest)
+	}
+	if errors.Is(err, ErrConcurrencyLimit) {
+		return ctrl.Result{RequeueAfter: r.jitter(concurrencyLimitRequeueAfter, certificateRequest)}, nil
+	}
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("%w: %v", errors.New("unable to fetch request from Horizon"), err)
+		return ctrl.Result{}, WrapError(errors.New("unable to fetch request from Horizon"), err)
 	}
 
 	logger.Info(fmt.Sprintf("Handling %s request %s", request.Status, certificateRequest.UID))
 	switch request.Status {
 	case requests.RequestStatusCompleted:
-		return r.handleCompletedRequest(request, certificateRequest)
-	case requests.RequestStatusPending, requests.RequestStatusApproved:
-		return r.handlePendingRequest()
+		return r.handleCompletedRequest(ctx, issuer, request, certificateRequest)
+	case requests.RequestStatusPending:
+		return r.handlePendingRequest(certificateRequest)
+	case requests.RequestStatusApproved:
+		// Approved requests are issued shortly after, keep polling them
+		setApproved(certificateRequest)
+		return r.handlePendingRequest(certificateRequest)
 	case requests.RequestStatusDenied, requests.RequestStatusCanceled:
-		return r.handleDeniedRequest(certificateRequest)
+		return r.handleDeniedRequest(request, certificateRequest)
+	}
+
+	return ctrl.Result{}, &PermanentError{Err: errors.New("invalid request status " + string(request.Status))}
+}
+
+// leafPEM returns the first certificate of an ordered chain.
+func leafPEM(chainPEM []byte) ([]byte, error) {
+	leaf, err := pki.DecodeX509CertificateBytes(chainPEM)
+	if err != nil {
+		return nil, err
 	}
+	return pki.EncodeX509(leaf)
+}
 
-	return ctrl.Result{}, errors.New("invalid request status " + string(request.Status))
+// issuedLeaf returns the certificate of a chain returned by Horizon that is
+// for the public key of the CSR, as chains are not always ordered, falling
+// back to the first one.
+func issuedLeaf(chain []*x509.Certificate, csrPem []byte) *x509.Certificate {
+	for _, certificate := range chain {
+		if sameKey, err := reusesKey(csrPem, certificate); err == nil && sameKey {
+			return certificate
+		}
+	}
+	return chain[0]
+}
+
+// normalizePEM returns PEM data with Unix line endings and a trailing
+// newline, which strict parsers require. Chains parsed by pki are already
+// re-encoded this way, so this only matters for certificates stored as
+// returned by Horizon.
+func normalizePEM(data string) []byte {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	if data != "" && !strings.HasSuffix(data, "\n") {
+		data += "\n"
+	}
+	return []byte(data)
 }
 
-func (r *HorizonIssuer) RevokeCertificate(ctx context.Context, certificateRequest *cmapi.CertificateRequest) error {
+// RevokeCertificate revokes the certificate issued for a CertificateRequest,
+// on the module of the issuer.
+func (r *HorizonIssuer) RevokeCertificate(ctx context.Context, issuer v1alpha1.IssuerSpec, certificateRequest *cmapi.CertificateRequest) error {
 	logger := log.FromContext(ctx)
 
 	logger.Info(fmt.Sprintf("Sending revocation request for request %s", certificateRequest.UID))
-	_, err := r.Client.Requests.Revoke(string(certificateRequest.Status.Certificate), "UNSPECIFIED")
+	request, err := r.Client.Revoke(ctx, issuer.Module, string(certificateRequest.Status.Certificate), certificates.RevocationReasonUnspecified)
+	var requestId string
+	if request != nil {
+		requestId = request.Id
+	}
+	r.Audit.record(certificateRequest, AuditActionRevoke, "", requestId, err)
 	return err
 
 }
 
-func (r *HorizonIssuer) handlePendingRequest() (result ctrl.Result, err error) {
+// IsRevoked returns whether the certificate issued for a Horizon request
+// was revoked.
+func (r *HorizonIssuer) IsRevoked(ctx context.Context, requestId string) (bool, error) {
+	request, err := r.Reader().GetRequest(ctx, requestId)
+	if err != nil {
+		return false, WrapError(errors.New("unable to fetch request from Horizon"), err)
+	}
+	return request.Certificate != nil && request.Certificate.RevocationDate > 0, nil
+}
+
+// CancelRequest cancels the Horizon request of a CertificateRequest that was
+// not issued. The request is fetched first, as renewals are canceled with
+// their own workflow, and requests that are no longer pending are left as is.
+func (r *HorizonIssuer) CancelRequest(ctx context.Context, issuer v1alpha1.IssuerSpec, certificateRequest *cmapi.CertificateRequest) error {
+	logger := log.FromContext(ctx)
+
+	requestId := certificateRequest.Annotations[RequestIdAnnotation]
+	request, err := r.Reader().GetRequest(ctx, requestId)
+	if err != nil {
+		return WrapError(errors.New("unable to fetch request to cancel from Horizon"), err)
+	}
+	if request.Status != requests.RequestStatusPending && request.Status != requests.RequestStatusApproved {
+		logger.Info(fmt.Sprintf("Request %s of abandoned request %s is %s, not canceling it", requestId, certificateRequest.UID, request.Status))
+		return nil
+	}
+
+	logger.Info(fmt.Sprintf("Canceling %s request %s of abandoned request %s", request.Workflow, requestId, certificateRequest.UID))
+	err = r.Client.Cancel(ctx, issuer.Module, request.Workflow, requestId)
+	r.Audit.record(certificateRequest, AuditActionCancel, issuer.Profile, requestId, err)
+	return err
+}
+
+// Reader returns the client polling and looking up requests, which is the
+// enroll client unless the issuer reads with other credentials.
+func (r *HorizonIssuer) Reader() *Client {
+	if r.ReadClient != nil {
+		return r.ReadClient
+	}
+	return &r.Client
+}
+
+// setAnnotation sets an annotation on a CertificateRequest, which may have
+// been created without any annotation.
+func setAnnotation(certificateRequest *cmapi.CertificateRequest, key string, value string) {
+	if certificateRequest.Annotations == nil {
+		certificateRequest.Annotations = make(map[string]string)
+	}
+	certificateRequest.Annotations[key] = value
+}
+
+// persistAnnotations updates the annotations of a CertificateRequest on the
+// API server, leaving its other changes to be persisted later.
+func persistAnnotations(ctx context.Context, client client.Client, certificateRequest *cmapi.CertificateRequest) error {
+	persisted := certificateRequest.DeepCopy()
+	if err := client.Update(ctx, persisted); err != nil {
+		return err
+	}
+	certificateRequest.ResourceVersion = persisted.ResourceVersion
+	return nil
+}
+
+// setListAnnotation sets an annotation to comma-separated values, or removes
+// it when there are none.
+func setListAnnotation(certificateRequest *cmapi.CertificateRequest, key string, values []string) {
+	if len(values) == 0 {
+		delete(certificateRequest.Annotations, key)
+		return
+	}
+	setAnnotation(certificateRequest, key, strings.Join(values, ","))
+}
+
+// submissionKey identifies the submission of a CertificateRequest, which
+// remains the same across retries.
+func submissionKey(certificateRequest *cmapi.CertificateRequest, profile string) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n", certificateRequest.UID, profile)
+	hash.Write(certificateRequest.Spec.Request)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ForgetSubmission discards the Horizon request of a CertificateRequest, so
+// that its CSR can be submitted again. Its correlation ID is discarded too,
+// so that the discarded request isn't found and adopted again.
+func (r *HorizonIssuer) ForgetSubmission(certificateRequest *cmapi.CertificateRequest, profile string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.submissions, submissionKey(certificateRequest, profile))
+	for _, annotation := range []string{RequestIdAnnotation, CorrelationIdAnnotation, CertificateUrlAnnotation, SerialNumberAnnotation, NotAfterAnnotation, OcspServersAnnotation, CrlDistributionPointsAnnotation, RenewalTimeAnnotation, CertificateIdAnnotation, ProfileAnnotation, IssuedAtAnnotation, ForceReenrollAnnotation} {
+		delete(certificateRequest.Annotations, annotation)
+	}
+	for annotation := range certificateRequest.Annotations {
+		if strings.HasPrefix(annotation, ThumbprintAnnotationPrefix) {
+			delete(certificateRequest.Annotations, annotation)
+		}
+	}
+}
+
+// submitted returns the ID of the request submitted with the given key, if any.
+func (r *HorizonIssuer) submitted(key string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.submissions[key]
+	if !ok || r.now().Sub(s.submittedAt) > submissionTTL {
+		return "", false
+	}
+	return s.requestId, true
+}
+
+func (r *HorizonIssuer) recordSubmission(key string, requestId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.submissions == nil {
+		r.submissions = make(map[string]submission)
+	}
+	now := r.now()
+	for k, s := range r.submissions {
+		if now.Sub(s.submittedAt) > submissionTTL {
+			delete(r.submissions, k)
+		}
+	}
+	r.submissions[key] = submission{requestId: requestId, submittedAt: now}
+}
+
+func (r *HorizonIssuer) now() time.Time {
+	if r.Clock == nil {
+		return time.Now()
+	}
+	return r.Clock.Now()
+}
+
+// OwnerExists returns whether an owner is a known identity of the Horizon
+// directory, looked up with the reader client.
+func (r *HorizonIssuer) OwnerExists(ctx context.Context, owner string) (bool, error) {
+	return r.Reader().OwnerExists(ctx, owner, r.now())
+}
+
+func (r *HorizonIssuer) handlePendingRequest(certificateRequest *cmapi.CertificateRequest) (result ctrl.Result, err error) {
+	pollInterval := r.requeueSettings().PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
 	// We requeue the request since it still needs to be approved
 	return ctrl.Result{
 		Requeue:      true,
-		RequeueAfter: time.Minute / 4,
+		RequeueAfter: r.jitter(pollInterval, certificateRequest),
 	}, nil
 }
 
-func (r *HorizonIssuer) handleDeniedRequest(certificateRequest *cmapi.CertificateRequest) (result ctrl.Result, err error) {
+// Reconfigure replaces the requeue settings, for the reconciles that follow.
+func (r *HorizonIssuer) Reconfigure(settings RequeueSettings) {
+	r.requeueMu.Lock()
+	defer r.requeueMu.Unlock()
+	r.Requeue = settings
+}
+
+func (r *HorizonIssuer) requeueSettings() RequeueSettings {
+	r.requeueMu.RLock()
+	defer r.requeueMu.RUnlock()
+	return r.Requeue
+}
+
+// NotReadyRequeueAfter returns the delay after which a CertificateRequest
+// is retried while its issuer is not ready, varied like polling delays, or
+// zero to leave the retries to the backoff of the controller.
+func (r *HorizonIssuer) NotReadyRequeueAfter(certificateRequest *cmapi.CertificateRequest) time.Duration {
+	delay := r.requeueSettings().NotReadyRequeueAfter
+	if delay <= 0 {
+		return 0
+	}
+	return r.jitter(delay, certificateRequest)
+}
+
+// jitter varies a polling delay by up to the Jitter percentage. The
+// variation is derived from the UID of the request, so that each request
+// keeps polling at its own pace instead of drifting back in line with others.
+func (r *HorizonIssuer) jitter(delay time.Duration, certificateRequest *cmapi.CertificateRequest) time.Duration {
+	requeueJitter := r.requeueSettings().Jitter
+	if requeueJitter <= 0 {
+		return delay
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(certificateRequest.UID))
+	// factor is spread evenly between -1 and 1
+	factor := float64(hash.Sum32())/math.MaxUint32*2 - 1
+	return delay + time.Duration(factor*float64(requeueJitter)/100*float64(delay))
+}
+
+// handleUnavailable keeps the request pending while Horizon is unavailable
+// and requeues it after the delay suggested by Horizon, if any.
+func (r *HorizonIssuer) handleUnavailable(ctx context.Context, err *UnavailableError, certificateRequest *cmapi.CertificateRequest) (ctrl.Result, error) {
+	requeueAfter := err.RetryAfter
+	if requeueAfter <= 0 {
+		requeueAfter = r.requeueSettings().UnavailableRequeueAfter
+	}
+	if requeueAfter <= 0 {
+		requeueAfter = defaultUnavailableRequeueAfter
+	}
+
+	log.FromContext(ctx).Info(fmt.Sprintf("Horizon is temporarily unavailable, retrying request %s in %s", certificateRequest.UID, requeueAfter))
+	cmutil.SetCertificateRequestCondition(
+		certificateRequest,
+		cmapi.CertificateRequestConditionReady,
+		cmmeta.ConditionFalse,
+		cmapi.CertificateRequestReasonPending,
+		"Horizon temporarily unavailable",
+	)
+
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+func (r *HorizonIssuer) handleDeniedRequest(request *Request, certificateRequest *cmapi.CertificateRequest) (result ctrl.Result, err error) {
+	message := "Request denied on Horizon"
+	if request.Status == requests.RequestStatusCanceled {
+		message = "Request canceled on Horizon"
+	}
+
+	// cert-manager does not allow denying an approved request, in which
+	// case the request is failed instead
+	if cmutil.CertificateRequestIsApproved(certificateRequest) {
+		return ctrl.Result{}, &PermanentError{Err: errors.New(message)}
+	}
+
 	cmutil.SetCertificateRequestCondition(
 		certificateRequest,
 		cmapi.CertificateRequestConditionDenied,
 		cmmeta.ConditionTrue,
 		"horizon.evertrust.io",
-		"Request denied on Horizon",
+		message,
 	)
 
 	return ctrl.Result{}, nil
 }
 
-func (r *HorizonIssuer) handleCompletedRequest(request *requests.HorizonRequest, certificateRequest *cmapi.CertificateRequest) (result ctrl.Result, err error) {
+// setApproved mirrors the approval of a request on Horizon onto the
+// CertificateRequest. The Approved condition may already have been set by an
+// approver, in which case it is left untouched.
+func setApproved(certificateRequest *cmapi.CertificateRequest) {
+	if cmutil.CertificateRequestIsApproved(certificateRequest) {
+		return
+	}
 	cmutil.SetCertificateRequestCondition(
 		certificateRequest,
 		cmapi.CertificateRequestConditionApproved,
@@ -117,8 +696,82 @@ func (r *HorizonIssuer) handleCompletedRequest(request *requests.HorizonRequest,
 		"horizon.evertrust.io",
 		"Request approved on Horizon",
 	)
+}
+
+func (r *HorizonIssuer) handleCompletedRequest(ctx context.Context, issuer v1alpha1.IssuerSpec, request *Request, certificateRequest *cmapi.CertificateRequest) (result ctrl.Result, err error) {
+	setApproved(certificateRequest)
+	setAnnotation(certificateRequest, CertificateUrlAnnotation, r.Client.RequestUiUrl(request.Id))
+
+	// The certificate is issued anyway, so failing to describe it only loses
+	// the annotations
+	chain, err := pki.DecodeX509CertificateChainBytes([]byte(request.Certificate.Certificate))
+	var certificate *x509.Certificate
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Unable to parse the issued certificate")
+	} else {
+		certificate = issuedLeaf(chain, certificateRequest.Spec.Request)
+		// Profiles that can't issue CA certificates may silently issue a
+		// leaf certificate instead, which can't be used to sign anything
+		if certificateRequest.Spec.IsCA && !certificate.IsCA {
+			return ctrl.Result{}, &PermanentError{Err: fmt.Errorf("Horizon issued a certificate that is not a CA, check that profile %s allows issuing CA certificates", request.Profile)}
+		}
+		// Installing a certificate that is not the one requested would break
+		// the workload, as its key would not match the private key
+		if err := VerifyCertificate(certificate, certificateRequest.Spec.Request); err != nil {
+			return ctrl.Result{}, &PermanentError{Err: err}
+		}
+		setAnnotation(certificateRequest, SerialNumberAnnotation, fmt.Sprintf("%x", certificate.SerialNumber))
+		setAnnotation(certificateRequest, NotAfterAnnotation, certificate.NotAfter.UTC().Format(time.RFC3339))
+		setListAnnotation(certificateRequest, OcspServersAnnotation, certificate.OCSPServer)
+		setListAnnotation(certificateRequest, CrlDistributionPointsAnnotation, certificate.CRLDistributionPoints)
+		algorithm, thumbprint := Thumbprint(certificate, issuer.ThumbprintAlgorithm)
+		setAnnotation(certificateRequest, ThumbprintAnnotationPrefix+string(algorithm), thumbprint)
+		if issuer.MetadataAnnotations {
+			setAnnotation(certificateRequest, IssuedAtAnnotation, certificate.NotBefore.UTC().Format(time.RFC3339))
+		}
+	}
+	if issuer.MetadataAnnotations {
+		setAnnotation(certificateRequest, ProfileAnnotation, request.Profile)
+		if request.CertificateId != "" {
+			setAnnotation(certificateRequest, CertificateIdAnnotation, request.CertificateId)
+		}
+	}
 
-	certificateRequest.Status.Certificate = []byte(request.Certificate.Certificate)
+	// Horizon may return the certificate along with its chain, in which case
+	// the chain is ordered and its top-most certificate set as the CA. The
+	// chain is only kept in the certificate, which cert-manager writes to
+	// tls.crt, when the issuer asks for it.
+	// A certificate that can't be parsed is stored as returned
+	certificateRequest.Status.Certificate = normalizePEM(request.Certificate.Certificate)
+	if certificate != nil {
+		bundle, err := pki.ParseSingleCertificateChain(append([]*x509.Certificate(nil), chain...))
+		if err != nil {
+			// A broken or incomplete chain still holds a usable leaf, but
+			// strict clients may fail to validate it
+			log.FromContext(ctx).Error(err, "Unable to order the issued certificate chain")
+			if r.Recorder != nil {
+				r.Recorder.Eventf(certificateRequest, corev1.EventTypeWarning, EventReasonBrokenChain, "Horizon returned a broken or incomplete certificate chain, the CA is not set: %v", err)
+			}
+			if !issuer.IncludeChain {
+				if certificateRequest.Status.Certificate, err = pki.EncodeX509(certificate); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+		} else {
+			certificateRequest.Status.CA = bundle.CAPEM
+			certificateRequest.Status.Certificate = bundle.ChainPEM
+			if !issuer.IncludeChain {
+				certificateRequest.Status.Certificate, err = leafPEM(bundle.ChainPEM)
+				if err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+		}
+	}
+	if request.RenewalDate > 0 {
+		renewalTime := time.Unix(0, request.RenewalDate*int64(time.Millisecond))
+		setAnnotation(certificateRequest, RenewalTimeAnnotation, renewalTime.UTC().Format(time.RFC3339))
+	}
 
 	cmutil.SetCertificateRequestCondition(
 		certificateRequest,
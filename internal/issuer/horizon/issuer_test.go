@@ -0,0 +1,443 @@
+package horizon
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evertrust/horizon-go/certificates"
+	"github.com/evertrust/horizon-go/requests"
+	"github.com/evertrust/horizon-issuer/api/v1alpha1"
+	"github.com/evertrust/horizon-issuer/internal/issuer/horizon/horizontest"
+	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/record"
+)
+
+// testCA is a CA of a test PKI.
+type testCA struct {
+	certificate *x509.Certificate
+	key         crypto.Signer
+}
+
+// newTestCA returns a CA signed by parent, or a root CA when parent is nil.
+func newTestCA(t *testing.T, name string, parent *testCA) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	ca := &testCA{key: key}
+	ca.certificate = parent.sign(t, template, key.Public(), key)
+	return ca
+}
+
+// sign issues a certificate for publicKey. A nil CA self-signs it with key.
+func (ca *testCA) sign(t *testing.T, template *x509.Certificate, publicKey crypto.PublicKey, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(24 * time.Hour)
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca.certificate, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, publicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+// issue issues a leaf certificate for the key and DNS names of a CSR.
+func (ca *testCA) issue(t *testing.T, csrPem []byte) *x509.Certificate {
+	t.Helper()
+	block, _ := pem.Decode(csrPem)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca.sign(t, &x509.Certificate{
+		Subject:     csr.Subject,
+		DNSNames:    csr.DNSNames,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, csr.PublicKey, nil)
+}
+
+// encodePEM concatenates the PEM encoding of certificates.
+func encodePEM(certificates ...*x509.Certificate) string {
+	var data []byte
+	for _, certificate := range certificates {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})...)
+	}
+	return string(data)
+}
+
+// completeRequest runs handleCompletedRequest for a CertificateRequest of
+// csrPem, Horizon returning certificatePem.
+func completeRequest(t *testing.T, issuer *HorizonIssuer, spec v1alpha1.IssuerSpec, csrPem []byte, certificatePem string) (*cmapi.CertificateRequest, error) {
+	t.Helper()
+	if issuer.Client.Http == nil {
+		issuer.Client = *newTestClient(t, http.NotFoundHandler())
+	}
+	certificateRequest := &cmapi.CertificateRequest{Spec: cmapi.CertificateRequestSpec{Request: csrPem}}
+	request := &Request{HorizonRequest: requests.HorizonRequest{
+		Id:          "1",
+		Status:      requests.RequestStatusCompleted,
+		Certificate: &certificates.Certificate{Certificate: certificatePem},
+	}}
+	_, err := issuer.handleCompletedRequest(context.Background(), spec, request, certificateRequest)
+	return certificateRequest, err
+}
+
+func TestNormalizePEM(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "empty"},
+		{name: "normalized", data: "-----BEGIN X-----\nAA==\n-----END X-----\n", want: "-----BEGIN X-----\nAA==\n-----END X-----\n"},
+		{name: "CRLF line endings", data: "-----BEGIN X-----\r\nAA==\r\n-----END X-----\r\n", want: "-----BEGIN X-----\nAA==\n-----END X-----\n"},
+		{name: "no trailing newline", data: "-----BEGIN X-----\nAA==\n-----END X-----", want: "-----BEGIN X-----\nAA==\n-----END X-----\n"},
+		{name: "both", data: "-----BEGIN X-----\r\nAA==\r\n-----END X-----", want: "-----BEGIN X-----\nAA==\n-----END X-----\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(normalizePEM(tt.data)); got != tt.want {
+				t.Errorf("normalizePEM() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleCompletedRequestNormalizesPEM(t *testing.T) {
+	root := newTestCA(t, "Root CA", nil)
+	intermediate := newTestCA(t, "Intermediate CA", root)
+	csrPem := newTestCSR(t, &x509.CertificateRequest{DNSNames: []string{"www.example.com"}}, nil)
+	leaf := intermediate.issue(t, csrPem)
+
+	returned := strings.TrimSuffix(strings.ReplaceAll(encodePEM(leaf, intermediate.certificate, root.certificate), "\n", "\r\n"), "\r\n")
+	certificateRequest, err := completeRequest(t, &HorizonIssuer{}, v1alpha1.IssuerSpec{}, csrPem, returned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmutil.CertificateRequestHasCondition(certificateRequest, cmapi.CertificateRequestCondition{Type: cmapi.CertificateRequestConditionReady, Status: cmmeta.ConditionTrue}) {
+		t.Fatalf("request is not issued: %+v", certificateRequest.Status.Conditions)
+	}
+	for field, data := range map[string][]byte{"certificate": certificateRequest.Status.Certificate, "CA": certificateRequest.Status.CA} {
+		if bytes.Contains(data, []byte("\r")) || !bytes.HasSuffix(data, []byte("\n")) {
+			t.Errorf("%s = %q, want Unix line endings and a trailing newline", field, data)
+		}
+	}
+	if want := encodePEM(leaf); string(certificateRequest.Status.Certificate) != want {
+		t.Errorf("certificate = %q, want the single leaf block %q", certificateRequest.Status.Certificate, want)
+	}
+}
+
+// newFakeHorizon starts a fake Horizon server, and returns it along with a
+// client of it.
+func newFakeHorizon(t *testing.T) (*horizontest.Server, *Client) {
+	t.Helper()
+	server := horizontest.NewServer()
+	t.Cleanup(server.Close)
+	client, err := newClient(&v1alpha1.IssuerSpec{URL: server.URL}, nil, nil, TransportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+// submitTestRequest submits a request of workflow for csrPem to a fake
+// Horizon server, and returns its ID.
+func submitTestRequest(t *testing.T, client *Client, workflow requests.RequestWorkflow, csrPem []byte) string {
+	t.Helper()
+	request, err := client.submit(context.Background(), requests.HorizonRequest{
+		Workflow: workflow,
+		Module:   DefaultModule,
+		Profile:  "WebServers",
+		Template: requests.WebRARequestTemplate{Csr: string(csrPem)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request.Id
+}
+
+// trackedRequest returns a CertificateRequest for csrPem tracking the
+// Horizon request requestId.
+func trackedRequest(requestId string, csrPem []byte) *cmapi.CertificateRequest {
+	certificateRequest := &cmapi.CertificateRequest{Spec: cmapi.CertificateRequestSpec{Request: csrPem}}
+	certificateRequest.UID = "4b5c2a5e-0d6f-4f5e-9a43-2f6d1b0f3c11"
+	certificateRequest.Annotations = map[string]string{RequestIdAnnotation: requestId}
+	return certificateRequest
+}
+
+func TestCancelRequest(t *testing.T) {
+	server, client := newFakeHorizon(t)
+	issuer := &HorizonIssuer{Client: *client}
+
+	tests := []struct {
+		name       string
+		workflow   requests.RequestWorkflow
+		issued     bool
+		wantStatus requests.RequestStatus
+	}{
+		{name: "enroll request", workflow: requests.RequestWorkflowEnroll, wantStatus: requests.RequestStatusCanceled},
+		{name: "renew request", workflow: requestWorkflowRenew, wantStatus: requests.RequestStatusCanceled},
+		{name: "completed request", workflow: requests.RequestWorkflowEnroll, issued: true, wantStatus: requests.RequestStatusCompleted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csrPem := newTestCSR(t, &x509.CertificateRequest{DNSNames: []string{"www.example.com"}}, nil)
+			requestId := submitTestRequest(t, client, tt.workflow, csrPem)
+			if tt.issued {
+				if err := server.Issue(requestId); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := issuer.CancelRequest(context.Background(), v1alpha1.IssuerSpec{}, trackedRequest(requestId, csrPem)); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := server.Request(requestId)
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestUpdateRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		// update brings the request to the status under test
+		update           func(server *horizontest.Server, id string) error
+		approved         bool
+		wantRequeueAfter time.Duration
+		wantPermanent    bool
+		wantCondition    cmapi.CertificateRequestCondition
+	}{
+		{
+			name:             "pending request",
+			update:           func(*horizontest.Server, string) error { return nil },
+			wantRequeueAfter: 15 * time.Second,
+		},
+		{
+			name:             "approved request",
+			update:           (*horizontest.Server).Approve,
+			wantRequeueAfter: 15 * time.Second,
+			wantCondition:    cmapi.CertificateRequestCondition{Type: cmapi.CertificateRequestConditionApproved, Status: cmmeta.ConditionTrue},
+		},
+		{
+			name:          "completed request",
+			update:        (*horizontest.Server).Issue,
+			wantCondition: cmapi.CertificateRequestCondition{Type: cmapi.CertificateRequestConditionReady, Status: cmmeta.ConditionTrue, Reason: cmapi.CertificateRequestReasonIssued},
+		},
+		{
+			name:          "denied request",
+			update:        (*horizontest.Server).Deny,
+			wantCondition: cmapi.CertificateRequestCondition{Type: cmapi.CertificateRequestConditionDenied, Status: cmmeta.ConditionTrue},
+		},
+		{
+			name:          "request canceled once approved",
+			update:        (*horizontest.Server).Cancel,
+			approved:      true,
+			wantPermanent: true,
+		},
+		{
+			name: "unavailable Horizon",
+			update: func(server *horizontest.Server, _ string) error {
+				server.Fail(horizontest.EndpointGetRequest, horizontest.Failure{StatusCode: 503, Code: "UNAVAILABLE", Message: "Maintenance", RetryAfter: "42"})
+				return nil
+			},
+			wantRequeueAfter: 42 * time.Second,
+			wantCondition:    cmapi.CertificateRequestCondition{Type: cmapi.CertificateRequestConditionReady, Status: cmmeta.ConditionFalse, Reason: cmapi.CertificateRequestReasonPending},
+		},
+		{
+			name: "unknown request",
+			update: func(server *horizontest.Server, _ string) error {
+				server.Fail(horizontest.EndpointGetRequest, horizontest.Failure{StatusCode: 404, Code: "REQ-NOT-FOUND", Message: "No such request"})
+				return nil
+			},
+			wantPermanent: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := newFakeHorizon(t)
+			issuer := &HorizonIssuer{Client: *client, Requeue: RequeueSettings{PollInterval: 15 * time.Second}}
+			csrPem := newTestCSR(t, &x509.CertificateRequest{DNSNames: []string{"www.example.com"}}, nil)
+			requestId := submitTestRequest(t, client, requests.RequestWorkflowEnroll, csrPem)
+			if err := tt.update(server, requestId); err != nil {
+				t.Fatal(err)
+			}
+			certificateRequest := trackedRequest(requestId, csrPem)
+			if tt.approved {
+				cmutil.SetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionApproved, cmmeta.ConditionTrue, "cert-manager.io", "Approved")
+			}
+
+			result, err := issuer.UpdateRequest(context.Background(), v1alpha1.IssuerSpec{}, certificateRequest)
+			if tt.wantPermanent {
+				if !IsPermanent(err) {
+					t.Fatalf("UpdateRequest() error = %v, want a permanent error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.RequeueAfter != tt.wantRequeueAfter {
+				t.Errorf("RequeueAfter = %s, want %s", result.RequeueAfter, tt.wantRequeueAfter)
+			}
+			if tt.wantCondition.Type != "" && !cmutil.CertificateRequestHasCondition(certificateRequest, tt.wantCondition) {
+				t.Errorf("conditions = %+v, want %s=%s (%s)", certificateRequest.Status.Conditions, tt.wantCondition.Type, tt.wantCondition.Status, tt.wantCondition.Reason)
+			}
+			wantIssued := tt.wantCondition.Reason == cmapi.CertificateRequestReasonIssued
+			if issued := len(certificateRequest.Status.Certificate) > 0; issued != wantIssued {
+				t.Errorf("certificate issued = %v, want %v", issued, wantIssued)
+			}
+		})
+	}
+}
+
+func TestHandleCompletedRequestChain(t *testing.T) {
+	root := newTestCA(t, "Root CA", nil)
+	intermediate := newTestCA(t, "Intermediate CA", root)
+	csrPem := newTestCSR(t, &x509.CertificateRequest{DNSNames: []string{"www.example.com"}}, nil)
+	leaf := intermediate.issue(t, csrPem)
+
+	tests := []struct {
+		name            string
+		returned        string
+		includeChain    bool
+		wantCertificate string
+		wantCA          string
+		wantBroken      bool
+	}{
+		{
+			name:            "ordered chain",
+			returned:        encodePEM(leaf, intermediate.certificate, root.certificate),
+			wantCertificate: encodePEM(leaf),
+			wantCA:          encodePEM(root.certificate),
+		},
+		{
+			name:            "shuffled chain",
+			returned:        encodePEM(root.certificate, leaf, intermediate.certificate),
+			wantCertificate: encodePEM(leaf),
+			wantCA:          encodePEM(root.certificate),
+		},
+		{
+			name:            "shuffled chain kept in the certificate",
+			returned:        encodePEM(intermediate.certificate, root.certificate, leaf),
+			includeChain:    true,
+			wantCertificate: encodePEM(leaf, intermediate.certificate),
+			wantCA:          encodePEM(root.certificate),
+		},
+		{
+			name:            "chain without root",
+			returned:        encodePEM(intermediate.certificate, leaf),
+			wantCertificate: encodePEM(leaf),
+			wantCA:          encodePEM(intermediate.certificate),
+		},
+		{
+			name:            "chain missing an intermediate",
+			returned:        encodePEM(root.certificate, leaf),
+			wantCertificate: encodePEM(leaf),
+			wantBroken:      true,
+		},
+		{
+			name:            "chain missing an intermediate kept in the certificate",
+			returned:        encodePEM(root.certificate, leaf),
+			includeChain:    true,
+			wantCertificate: encodePEM(root.certificate, leaf),
+			wantBroken:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			certificateRequest, err := completeRequest(t, &HorizonIssuer{Recorder: recorder}, v1alpha1.IssuerSpec{IncludeChain: tt.includeChain}, csrPem, tt.returned)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmutil.CertificateRequestHasCondition(certificateRequest, cmapi.CertificateRequestCondition{Type: cmapi.CertificateRequestConditionReady, Status: cmmeta.ConditionTrue}) {
+				t.Fatalf("request is not issued: %+v", certificateRequest.Status.Conditions)
+			}
+			if got := string(certificateRequest.Status.Certificate); got != tt.wantCertificate {
+				t.Errorf("certificate = %q, want %q", got, tt.wantCertificate)
+			}
+			if got := string(certificateRequest.Status.CA); got != tt.wantCA {
+				t.Errorf("CA = %q, want %q", got, tt.wantCA)
+			}
+
+			var events []string
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			broken := len(events) == 1 && strings.HasPrefix(events[0], corev1.EventTypeWarning+" "+EventReasonBrokenChain+" ")
+			if broken != tt.wantBroken || (!tt.wantBroken && len(events) > 0) {
+				t.Errorf("events = %q, want a %s warning: %v", events, EventReasonBrokenChain, tt.wantBroken)
+			}
+		})
+	}
+}
+
+func TestSubmissionTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    bool
+	}{
+		{name: "just submitted", want: true},
+		{name: "submitted for the TTL", elapsed: submissionTTL, want: true},
+		{name: "submitted for longer than the TTL", elapsed: submissionTTL + time.Nanosecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClock := clock.NewFakeClock(time.Date(2021, time.October, 1, 12, 0, 0, 0, time.UTC))
+			issuer := &HorizonIssuer{Clock: fakeClock}
+			issuer.recordSubmission("key", "6156f0a1e4b0c0a1b2c3d4e5")
+
+			fakeClock.Step(tt.elapsed)
+			requestId, ok := issuer.submitted("key")
+			if ok != tt.want {
+				t.Fatalf("submitted() = %q, %v, want %v", requestId, ok, tt.want)
+			}
+
+			// Expired submissions are evicted as new ones are recorded
+			issuer.recordSubmission("other", "6156f0a1e4b0c0a1b2c3d4e6")
+			if _, ok := issuer.submissions["key"]; ok != tt.want {
+				t.Errorf("submission kept = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
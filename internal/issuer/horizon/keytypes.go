@@ -0,0 +1,143 @@
+package horizon
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+)
+
+var (
+	errKeyTypeNotAllowed = errors.New("key type not allowed by the issuer")
+	errNoProfile         = errors.New("no profile to enroll the key with")
+)
+
+// ProfileForKeyType returns the profile a PEM-encoded CSR is enrolled with,
+// which is the one of ProfileByKeyType matching the algorithm of its public
+// key, or Profile otherwise. The CSR is only parsed when ProfileByKeyType is
+// set.
+func ProfileForKeyType(issuerSpec *horizonapi.IssuerSpec, csrPem []byte) (string, error) {
+	if len(issuerSpec.ProfileByKeyType) == 0 {
+		return issuerSpec.Profile, nil
+	}
+
+	block, _ := pem.Decode(csrPem)
+	if block == nil {
+		return "", errors.New("failed to decode the CSR PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the CSR: %v", err)
+	}
+
+	var algorithm horizonapi.KeyAlgorithm
+	switch key := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		algorithm = horizonapi.RSAKeyAlgorithm
+	case *ecdsa.PublicKey:
+		algorithm = horizonapi.ECDSAKeyAlgorithm
+	case ed25519.PublicKey:
+		algorithm = horizonapi.Ed25519KeyAlgorithm
+	default:
+		algorithm = horizonapi.KeyAlgorithm(fmt.Sprintf("%T", key))
+	}
+
+	if profile := issuerSpec.ProfileByKeyType[algorithm]; profile != "" {
+		return profile, nil
+	}
+	if issuerSpec.Profile == "" {
+		return "", fmt.Errorf("%w: %s keys are not listed in profileByKeyType, and the issuer sets no default profile", errNoProfile, algorithm)
+	}
+	return issuerSpec.Profile, nil
+}
+
+// ValidateKeyType checks that the public key of a PEM-encoded CSR matches
+// one of the allowed key types. Any key is valid when none are given.
+func ValidateKeyType(csrPem []byte, allowedKeyTypes []horizonapi.AllowedKeyType) error {
+	if len(allowedKeyTypes) == 0 {
+		return nil
+	}
+
+	block, _ := pem.Decode(csrPem)
+	if block == nil {
+		return errors.New("failed to decode the CSR PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse the CSR: %v", err)
+	}
+
+	var description string
+	switch key := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		size := key.N.BitLen()
+		description = fmt.Sprintf("RSA %d", size)
+		for _, allowed := range allowedKeyTypes {
+			if allowed.Algorithm == horizonapi.RSAKeyAlgorithm && size >= allowed.MinSize {
+				return nil
+			}
+		}
+	case *ecdsa.PublicKey:
+		curve := horizonapi.ECDSACurve(key.Curve.Params().Name)
+		description = fmt.Sprintf("ECDSA %s", curve)
+		for _, allowed := range allowedKeyTypes {
+			if allowed.Algorithm == horizonapi.ECDSAKeyAlgorithm && curveAllowed(curve, allowed.Curves) {
+				return nil
+			}
+		}
+	case ed25519.PublicKey:
+		description = "Ed25519"
+		for _, allowed := range allowedKeyTypes {
+			if allowed.Algorithm == horizonapi.Ed25519KeyAlgorithm {
+				return nil
+			}
+		}
+	default:
+		description = fmt.Sprintf("%T", key)
+	}
+
+	return fmt.Errorf("%w: %s key, allowed keys are %s", errKeyTypeNotAllowed, description, describeKeyTypes(allowedKeyTypes))
+}
+
+func curveAllowed(curve horizonapi.ECDSACurve, curves []horizonapi.ECDSACurve) bool {
+	if len(curves) == 0 {
+		return true
+	}
+	for _, c := range curves {
+		if c == curve {
+			return true
+		}
+	}
+	return false
+}
+
+// describeKeyTypes returns a human readable list of key types, such as
+// "RSA 2048+, ECDSA P-256".
+func describeKeyTypes(keyTypes []horizonapi.AllowedKeyType) string {
+	var description string
+	for i, keyType := range keyTypes {
+		if i > 0 {
+			description += ", "
+		}
+		description += string(keyType.Algorithm)
+		switch {
+		case keyType.Algorithm == horizonapi.RSAKeyAlgorithm && keyType.MinSize > 0:
+			description += fmt.Sprintf(" %d+", keyType.MinSize)
+		case keyType.Algorithm == horizonapi.ECDSAKeyAlgorithm && len(keyType.Curves) > 0:
+			for j, curve := range keyType.Curves {
+				if j == 0 {
+					description += " "
+				} else {
+					description += "/"
+				}
+				description += string(curve)
+			}
+		}
+	}
+	return description
+}
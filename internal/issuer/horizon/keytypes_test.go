@@ -0,0 +1,126 @@
+package horizon
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"testing"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+)
+
+// testKeys are keys of each supported algorithm, generated once as RSA keys
+// are slow to generate.
+var testKeys = func() map[string]crypto.Signer {
+	keys := make(map[string]crypto.Signer)
+	for _, size := range []int{1024, 2048} {
+		key, err := rsa.GenerateKey(rand.Reader, size)
+		if err != nil {
+			panic(err)
+		}
+		keys[fmt.Sprintf("RSA %d", size)] = key
+	}
+	for name, curve := range map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384()} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		keys["ECDSA "+name] = key
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	keys["Ed25519"] = key
+	return keys
+}()
+
+func TestValidateKeyType(t *testing.T) {
+	rsa2048 := horizonapi.AllowedKeyType{Algorithm: horizonapi.RSAKeyAlgorithm, MinSize: 2048}
+	p256 := horizonapi.AllowedKeyType{Algorithm: horizonapi.ECDSAKeyAlgorithm, Curves: []horizonapi.ECDSACurve{"P-256"}}
+	anyECDSA := horizonapi.AllowedKeyType{Algorithm: horizonapi.ECDSAKeyAlgorithm}
+	ed25519Key := horizonapi.AllowedKeyType{Algorithm: horizonapi.Ed25519KeyAlgorithm}
+
+	tests := []struct {
+		name    string
+		key     string
+		allowed []horizonapi.AllowedKeyType
+		wantErr bool
+	}{
+		{name: "any key when none are allowed", key: "RSA 1024"},
+		{name: "RSA key of the minimum size", key: "RSA 2048", allowed: []horizonapi.AllowedKeyType{rsa2048}},
+		{name: "RSA key below the minimum size", key: "RSA 1024", allowed: []horizonapi.AllowedKeyType{rsa2048}, wantErr: true},
+		{name: "RSA key when only ECDSA is allowed", key: "RSA 2048", allowed: []horizonapi.AllowedKeyType{p256}, wantErr: true},
+		{name: "ECDSA key of an allowed curve", key: "ECDSA P-256", allowed: []horizonapi.AllowedKeyType{rsa2048, p256}},
+		{name: "ECDSA key of another curve", key: "ECDSA P-384", allowed: []horizonapi.AllowedKeyType{p256}, wantErr: true},
+		{name: "ECDSA key of any curve", key: "ECDSA P-384", allowed: []horizonapi.AllowedKeyType{anyECDSA}},
+		{name: "Ed25519 key", key: "Ed25519", allowed: []horizonapi.AllowedKeyType{ed25519Key}},
+		{name: "Ed25519 key when not allowed", key: "Ed25519", allowed: []horizonapi.AllowedKeyType{rsa2048, anyECDSA}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateKeyType(newTestCSR(t, &x509.CertificateRequest{}, testKeys[tt.key]), tt.allowed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateKeyType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, errKeyTypeNotAllowed) {
+				t.Errorf("ValidateKeyType() error = %v, want %v", err, errKeyTypeNotAllowed)
+			}
+		})
+	}
+}
+
+func TestValidateKeyTypeMessage(t *testing.T) {
+	allowed := []horizonapi.AllowedKeyType{
+		{Algorithm: horizonapi.RSAKeyAlgorithm, MinSize: 2048},
+		{Algorithm: horizonapi.ECDSAKeyAlgorithm, Curves: []horizonapi.ECDSACurve{"P-256", "P-384"}},
+	}
+	err := ValidateKeyType(newTestCSR(t, &x509.CertificateRequest{}, testKeys["RSA 1024"]), allowed)
+	want := "key type not allowed by the issuer: RSA 1024 key, allowed keys are RSA 2048+, ECDSA P-256/P-384"
+	if err == nil || err.Error() != want {
+		t.Errorf("ValidateKeyType() error = %v, want %s", err, want)
+	}
+}
+
+func TestProfileForKeyType(t *testing.T) {
+	byKeyType := map[horizonapi.KeyAlgorithm]string{
+		horizonapi.RSAKeyAlgorithm:     "rsa-profile",
+		horizonapi.ECDSAKeyAlgorithm:   "ecdsa-profile",
+		horizonapi.Ed25519KeyAlgorithm: "ed25519-profile",
+	}
+	rsaOnly := map[horizonapi.KeyAlgorithm]string{horizonapi.RSAKeyAlgorithm: "rsa-profile"}
+
+	tests := []struct {
+		name      string
+		key       string
+		profile   string
+		byKeyType map[horizonapi.KeyAlgorithm]string
+		want      string
+		wantErr   error
+	}{
+		{name: "no mapping", key: "RSA 2048", profile: "default", want: "default"},
+		{name: "RSA key", key: "RSA 2048", profile: "default", byKeyType: byKeyType, want: "rsa-profile"},
+		{name: "ECDSA key", key: "ECDSA P-256", profile: "default", byKeyType: byKeyType, want: "ecdsa-profile"},
+		{name: "Ed25519 key", key: "Ed25519", profile: "default", byKeyType: byKeyType, want: "ed25519-profile"},
+		{name: "unmapped key with a default profile", key: "ECDSA P-384", profile: "default", byKeyType: rsaOnly, want: "default"},
+		{name: "unmapped key without a default profile", key: "Ed25519", byKeyType: rsaOnly, wantErr: errNoProfile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuerSpec := &horizonapi.IssuerSpec{Profile: tt.profile, ProfileByKeyType: tt.byKeyType}
+			got, err := ProfileForKeyType(issuerSpec, newTestCSR(t, &x509.CertificateRequest{}, testKeys[tt.key]))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ProfileForKeyType() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ProfileForKeyType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
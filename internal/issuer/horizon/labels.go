@@ -0,0 +1,139 @@
+package horizon
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/evertrust/horizon-go/requests"
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+)
+
+// LabelsFromAnnotations returns the labels set through annotations, by
+// label name.
+func LabelsFromAnnotations(annotations map[string]string) map[string][]string {
+	labels := make(map[string][]string)
+	for annotation, value := range annotations {
+		if name := strings.TrimPrefix(annotation, LabelAnnotationPrefix); name != annotation && name != "" {
+			labels[name] = []string{value}
+		}
+	}
+	return labels
+}
+
+// TemplateParameters returns the template parameters of an issuer, with
+// those set through annotations taking precedence.
+func TemplateParameters(parameters map[string]string, annotations map[string]string) map[string]string {
+	merged := make(map[string]string, len(parameters))
+	for key, value := range parameters {
+		merged[key] = value
+	}
+	for annotation, value := range annotations {
+		if key := strings.TrimPrefix(annotation, TemplateParameterAnnotationPrefix); key != annotation && key != "" {
+			merged[key] = value
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// FilterLabels returns the labels whose name matches one of the allowed
+// patterns, all names being allowed when allowed is empty, and none of the
+// denied patterns. Patterns are names that may hold * wildcards, as in
+// path.Match.
+func FilterLabels(labels map[string][]string, allowed []string, denied []string) map[string][]string {
+	filtered := make(map[string][]string, len(labels))
+	for name, values := range labels {
+		if (len(allowed) == 0 || matchesAny(name, allowed)) && !matchesAny(name, denied) {
+			filtered[name] = values
+		}
+	}
+	return filtered
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelsFromNamespace returns the Horizon labels mapped from the labels of
+// a namespace, mapping being keyed by namespace label name.
+func LabelsFromNamespace(namespaceLabels map[string]string, mapping map[string]string) map[string][]string {
+	labels := make(map[string][]string)
+	for namespaceLabel, name := range mapping {
+		if value, ok := namespaceLabels[namespaceLabel]; ok && value != "" && name != "" {
+			labels[name] = []string{value}
+		}
+	}
+	return labels
+}
+
+// SingleValuedLabels returns labels holding one value each as multi-valued
+// labels, so that they can be merged with them.
+func SingleValuedLabels(labels map[string]string) map[string][]string {
+	multiValued := make(map[string][]string, len(labels))
+	for name, value := range labels {
+		multiValued[name] = []string{value}
+	}
+	return multiValued
+}
+
+// DefaultCategoryLabel is the name of the Horizon label receiving the
+// category of certificates when the issuer does not set one.
+const DefaultCategoryLabel = "category"
+
+// categoryLabel returns the name of the label receiving the category of the
+// certificates of an issuer.
+func categoryLabel(issuer horizonapi.IssuerSpec) string {
+	if issuer.CategoryLabel == "" {
+		return DefaultCategoryLabel
+	}
+	return issuer.CategoryLabel
+}
+
+// withLabel sets a label to a single value, replacing its previous values.
+func withLabel(elements []requests.LabelElement, name string, value string) []requests.LabelElement {
+	var result []requests.LabelElement
+	for _, element := range elements {
+		if element.Label != name {
+			result = append(result, element)
+		}
+	}
+	return append(result, requests.LabelElement{Label: name, Value: value})
+}
+
+// MergeLabels merges sets of labels, each taking precedence over the ones
+// before it. A label holding several values is sent as one element per
+// value. Labels are sorted by name, and values kept in order, so that
+// requests are stable.
+func MergeLabels(labelSets ...map[string][]string) []requests.LabelElement {
+	merged := make(map[string][]string)
+	for _, labels := range labelSets {
+		for name, values := range labels {
+			merged[name] = values
+		}
+	}
+
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var elements []requests.LabelElement
+	for _, name := range names {
+		for _, value := range merged[name] {
+			elements = append(elements, requests.LabelElement{
+				Label: name,
+				Value: value,
+			})
+		}
+	}
+	return elements
+}
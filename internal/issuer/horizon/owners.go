@@ -0,0 +1,84 @@
+package horizon
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ownerLookupTTL is how long the result of a lookup of an owner in the
+// Horizon directory is reused, whether the owner was found or not.
+const ownerLookupTTL = 10 * time.Minute
+
+// ownerCache holds the results of owner lookups, and is shared by the
+// copies of a client.
+type ownerCache struct {
+	mu      sync.Mutex
+	lookups map[string]ownerLookup
+}
+
+type ownerLookup struct {
+	exists    bool
+	checkedAt time.Time
+}
+
+func (o *ownerCache) get(owner string, now time.Time) (bool, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	lookup, ok := o.lookups[owner]
+	if !ok || now.Sub(lookup.checkedAt) >= ownerLookupTTL {
+		return false, false
+	}
+	return lookup.exists, true
+}
+
+// put records a lookup, and evicts the expired ones so that the cache does
+// not grow with every owner ever looked up.
+func (o *ownerCache) put(owner string, exists bool, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.lookups == nil {
+		o.lookups = make(map[string]ownerLookup)
+	}
+	for name, lookup := range o.lookups {
+		if now.Sub(lookup.checkedAt) >= ownerLookupTTL {
+			delete(o.lookups, name)
+		}
+	}
+	o.lookups[owner] = ownerLookup{exists: exists, checkedAt: now}
+}
+
+// OwnerExists returns whether an owner is a known identity of the Horizon
+// directory. Lookups are cached for ownerLookupTTL, from now.
+func (c *Client) OwnerExists(ctx context.Context, owner string, now time.Time) (bool, error) {
+	if c.owners != nil {
+		if exists, ok := c.owners.get(owner, now); ok {
+			return exists, nil
+		}
+	}
+
+	release, err := c.acquire()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	exists := true
+	err = c.do(ctx, http.MethodGet, c.segmentUrl("/api/v1/security/identities/", owner), nil, nil)
+	var horizonErr *HorizonError
+	if errors.As(err, &horizonErr) && horizonErr.StatusCode == http.StatusNotFound {
+		exists, err = false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if c.owners != nil {
+		c.owners.put(owner, exists, now)
+	}
+	return exists, nil
+}
@@ -0,0 +1,113 @@
+package horizon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	"github.com/evertrust/horizon-issuer/internal/issuer/horizon/horizontest"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestOwnerExists(t *testing.T) {
+	server := horizontest.NewServer()
+	t.Cleanup(server.Close)
+	server.Identities = []string{"jdoe", "CORP/jdoe", "j doe?#%"}
+	client, err := newClient(&horizonapi.IssuerSpec{URL: server.URL}, nil, nil, TransportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		owner string
+		want  bool
+	}{
+		{owner: "jdoe", want: true},
+		{owner: "CORP/jdoe", want: true},
+		{owner: "j doe?#%", want: true},
+		{owner: "CORP"},
+		{owner: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.owner, func(t *testing.T) {
+			calls := server.Calls(horizontest.EndpointIdentity)
+			for i := 0; i < 2; i++ {
+				exists, err := client.OwnerExists(context.Background(), tt.owner, time.Now())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if exists != tt.want {
+					t.Errorf("OwnerExists() = %v, want %v", exists, tt.want)
+				}
+			}
+			if got := server.Calls(horizontest.EndpointIdentity) - calls; got != 1 {
+				t.Errorf("owner was looked up %d times, want once", got)
+			}
+		})
+	}
+}
+
+func TestOwnerCache(t *testing.T) {
+	now := time.Now()
+	cache := &ownerCache{}
+	cache.put("jdoe", true, now)
+	cache.put("unknown", false, now.Add(time.Minute))
+
+	tests := []struct {
+		name       string
+		owner      string
+		at         time.Time
+		wantExists bool
+		wantOk     bool
+	}{
+		{name: "found owner", owner: "jdoe", at: now, wantExists: true, wantOk: true},
+		{name: "owner that was not found", owner: "unknown", at: now.Add(time.Minute), wantOk: true},
+		{name: "lookup about to expire", owner: "jdoe", at: now.Add(ownerLookupTTL - time.Nanosecond), wantExists: true, wantOk: true},
+		{name: "expired lookup", owner: "jdoe", at: now.Add(ownerLookupTTL)},
+		{name: "owner never looked up", owner: "other", at: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exists, ok := cache.get(tt.owner, tt.at)
+			if exists != tt.wantExists || ok != tt.wantOk {
+				t.Errorf("get() = %v, %v, want %v, %v", exists, ok, tt.wantExists, tt.wantOk)
+			}
+		})
+	}
+
+	// Expired lookups are evicted as new ones are recorded
+	cache.put("other", true, now.Add(ownerLookupTTL))
+	if _, ok := cache.lookups["jdoe"]; ok {
+		t.Error("expired lookup of jdoe was not evicted")
+	}
+	if len(cache.lookups) != 2 {
+		t.Errorf("cache holds %d lookups, want 2", len(cache.lookups))
+	}
+}
+
+func TestOwnerExistsTTL(t *testing.T) {
+	server, client := newFakeHorizon(t)
+	server.Identities = []string{"jdoe"}
+	fakeClock := clock.NewFakeClock(time.Date(2021, time.October, 1, 12, 0, 0, 0, time.UTC))
+	issuer := &HorizonIssuer{Client: *client, Clock: fakeClock}
+
+	lookup := func(wantCalls int) {
+		t.Helper()
+		exists, err := issuer.OwnerExists(context.Background(), "jdoe")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Error("OwnerExists() = false, want true")
+		}
+		if calls := server.Calls(horizontest.EndpointIdentity); calls != wantCalls {
+			t.Errorf("owner was looked up %d times, want %d", calls, wantCalls)
+		}
+	}
+	lookup(1)
+	fakeClock.Step(ownerLookupTTL - time.Nanosecond)
+	lookup(1)
+	fakeClock.Step(time.Nanosecond)
+	lookup(2)
+}
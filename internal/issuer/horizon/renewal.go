@@ -0,0 +1,67 @@
+package horizon
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/evertrust/horizon-issuer/api/v1alpha1"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+)
+
+// RenewedCertificate returns the PEM-encoded certificate that a CSR should
+// renew on Horizon given the renewal mode of the issuer, or an empty string
+// when the CSR should be enrolled as a new certificate. previousPem is the
+// certificate currently stored for the Certificate, if any.
+func RenewedCertificate(mode v1alpha1.RenewalMode, csrPem []byte, previousPem []byte) (string, error) {
+	if len(previousPem) == 0 {
+		return "", nil
+	}
+	switch mode {
+	case v1alpha1.RenewalModeRenew, v1alpha1.RenewalModeAuto:
+	default:
+		return "", nil
+	}
+
+	previous, err := pki.DecodeX509CertificateBytes(previousPem)
+	if err != nil {
+		// The secret may hold anything, a new certificate is enrolled then
+		return "", nil
+	}
+
+	if mode == v1alpha1.RenewalModeAuto {
+		sameKey, err := reusesKey(csrPem, previous)
+		if err != nil || !sameKey {
+			return "", err
+		}
+	}
+
+	encoded, err := pki.EncodeX509(previous)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// reusesKey returns whether a CSR is for the public key of a certificate.
+func reusesKey(csrPem []byte, certificate *x509.Certificate) (bool, error) {
+	block, _ := pem.Decode(csrPem)
+	if block == nil {
+		return false, errors.New("failed to decode the CSR PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse the CSR: %v", err)
+	}
+	csrKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return false, err
+	}
+	certificateKey, err := x509.MarshalPKIXPublicKey(certificate.PublicKey)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(csrKey, certificateKey), nil
+}
@@ -0,0 +1,88 @@
+package horizon
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/evertrust/horizon-go/rfc5280"
+)
+
+// DefaultUpnSanType is the Horizon SAN type UPNs are sent as when the issuer
+// does not set one.
+const DefaultUpnSanType = "OTHERNAME_UPN"
+
+var (
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidUpn            = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+)
+
+// otherName is the otherName form of a GeneralName, as defined in RFC 5280.
+type otherName struct {
+	TypeId asn1.ObjectIdentifier
+	Value  asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+// upnSans returns the UPNs found in the otherName SANs of a PEM-encoded CSR,
+// which Go and Horizon don't reliably report.
+func upnSans(csrPem []byte) ([]string, error) {
+	block, _ := pem.Decode(csrPem)
+	if block == nil {
+		return nil, errors.New("failed to decode the CSR PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the CSR: %v", err)
+	}
+
+	var upns []string
+	for _, extension := range csr.Extensions {
+		if !extension.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(extension.Value, &names); err != nil {
+			return nil, fmt.Errorf("failed to parse the CSR SANs: %v", err)
+		}
+		for _, name := range names {
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+				continue
+			}
+			var other otherName
+			if _, err := asn1.UnmarshalWithParams(name.FullBytes, &other, "tag:0"); err != nil {
+				return nil, fmt.Errorf("failed to parse an otherName SAN: %v", err)
+			}
+			if !other.TypeId.Equal(oidUpn) {
+				continue
+			}
+			var upn string
+			if _, err := asn1.Unmarshal(other.Value.Bytes, &upn); err != nil {
+				return nil, fmt.Errorf("failed to parse a UPN SAN: %v", err)
+			}
+			upns = append(upns, upn)
+		}
+	}
+	return upns, nil
+}
+
+// withUpnSans replaces the UPNs reported by Horizon, if any, with the ones
+// found in the CSR, sent as the given SAN type.
+func withUpnSans(sans []rfc5280.SubjectAlternateName, upns []string, sanType string) []rfc5280.SubjectAlternateName {
+	if sanType == "" {
+		sanType = DefaultUpnSanType
+	}
+
+	var result []rfc5280.SubjectAlternateName
+	for _, san := range sans {
+		if !strings.EqualFold(san.SanType, DefaultUpnSanType) && !strings.EqualFold(san.SanType, sanType) {
+			result = append(result, san)
+		}
+	}
+	for _, upn := range upns {
+		result = append(result, rfc5280.SubjectAlternateName{SanType: sanType, Value: upn})
+	}
+	return result
+}
@@ -0,0 +1,110 @@
+package horizon
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"reflect"
+	"testing"
+
+	"github.com/evertrust/horizon-go/rfc5280"
+)
+
+// upnExtension returns a SAN extension holding a DNS name and UPN otherNames.
+func upnExtension(t *testing.T, dnsName string, upns ...string) pkix.Extension {
+	t.Helper()
+	names := []asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte(dnsName)}}
+	for _, upn := range upns {
+		value, err := asn1.MarshalWithParams(upn, "utf8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The value is explicitly tagged, which is spelled out here as
+		// Marshal ignores the tags of raw values
+		name, err := asn1.MarshalWithParams(struct {
+			TypeId asn1.ObjectIdentifier
+			Value  asn1.RawValue
+		}{
+			TypeId: oidUpn,
+			Value:  asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: value},
+		}, "tag:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, asn1.RawValue{FullBytes: name})
+	}
+	value, err := asn1.Marshal(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oidSubjectAltName, Value: value}
+}
+
+func TestUpnSans(t *testing.T) {
+	tests := []struct {
+		name     string
+		template *x509.CertificateRequest
+		want     []string
+	}{
+		{name: "no SAN", template: &x509.CertificateRequest{}},
+		{name: "DNS names only", template: &x509.CertificateRequest{DNSNames: []string{"www.example.com"}}},
+		{
+			name:     "UPN otherName",
+			template: &x509.CertificateRequest{ExtraExtensions: []pkix.Extension{upnExtension(t, "host.corp.example.com", "jdoe@corp.example.com")}},
+			want:     []string{"jdoe@corp.example.com"},
+		},
+		{
+			name:     "several UPN otherNames",
+			template: &x509.CertificateRequest{ExtraExtensions: []pkix.Extension{upnExtension(t, "host.corp.example.com", "jdoe@corp.example.com", "JDoe@CORP")}},
+			want:     []string{"jdoe@corp.example.com", "JDoe@CORP"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upns, err := upnSans(newTestCSR(t, tt.template, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(upns, tt.want) {
+				t.Errorf("upnSans() = %q, want %q", upns, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithUpnSans(t *testing.T) {
+	dnsName := rfc5280.SubjectAlternateName{SanType: "DNSNAME", Value: "host.corp.example.com"}
+	tests := []struct {
+		name    string
+		sans    []rfc5280.SubjectAlternateName
+		upns    []string
+		sanType string
+		want    []rfc5280.SubjectAlternateName
+	}{
+		{
+			name: "UPNs are added with the default type",
+			sans: []rfc5280.SubjectAlternateName{dnsName},
+			upns: []string{"jdoe@corp.example.com"},
+			want: []rfc5280.SubjectAlternateName{dnsName, {SanType: DefaultUpnSanType, Value: "jdoe@corp.example.com"}},
+		},
+		{
+			name:    "UPNs reported by Horizon are replaced",
+			sans:    []rfc5280.SubjectAlternateName{{SanType: "othername_upn", Value: "JDOE@CORP.EXAMPLE.COM"}, dnsName},
+			upns:    []string{"jdoe@corp.example.com"},
+			sanType: "MS_UPN",
+			want:    []rfc5280.SubjectAlternateName{dnsName, {SanType: "MS_UPN", Value: "jdoe@corp.example.com"}},
+		},
+		{
+			name: "other SANs are kept",
+			sans: []rfc5280.SubjectAlternateName{dnsName, {SanType: "RFC822NAME", Value: "jdoe@example.com"}},
+			want: []rfc5280.SubjectAlternateName{dnsName, {SanType: "RFC822NAME", Value: "jdoe@example.com"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withUpnSans(tt.sans, tt.upns, tt.sanType); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withUpnSans() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
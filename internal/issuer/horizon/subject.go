@@ -0,0 +1,181 @@
+package horizon
+
+import (
+	"fmt"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/evertrust/horizon-go/rfc5280"
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+)
+
+// DN element types, as understood by Horizon.
+const (
+	DnTypeCommonName         = "CN"
+	DnTypeOrganization       = "O"
+	DnTypeOrganizationalUnit = "OU"
+	DnTypeCountry            = "C"
+	DnTypeLocality           = "L"
+	DnTypeProvince           = "ST"
+)
+
+// subjectAnnotations maps the annotations overriding subject components to
+// the DN element type they set.
+var subjectAnnotations = []struct {
+	annotation string
+	dnType     string
+}{
+	{SubjectOrganizationAnnotation, DnTypeOrganization},
+	{SubjectOrganizationalUnitAnnotation, DnTypeOrganizationalUnit},
+	{SubjectCountryAnnotation, DnTypeCountry},
+	{SubjectLocalityAnnotation, DnTypeLocality},
+	{SubjectProvinceAnnotation, DnTypeProvince},
+}
+
+// Upper bounds from RFC 5280 appendix A.1
+var subjectMaxLengths = map[string]int{
+	DnTypeOrganization:       64,
+	DnTypeOrganizationalUnit: 64,
+	DnTypeLocality:           128,
+	DnTypeProvince:           128,
+}
+
+var countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// sanTypeDnsName is the type of DNS SANs parsed by Horizon.
+const sanTypeDnsName = "DNSNAME"
+
+// SubjectFromSpec returns the DN elements configured on an issuer.
+func SubjectFromSpec(subject *horizonapi.Subject) []rfc5280.CFDistinguishedName {
+	if subject == nil {
+		return nil
+	}
+
+	var elements []rfc5280.CFDistinguishedName
+	add := func(dnType string, values []string) {
+		for _, value := range values {
+			elements = append(elements, rfc5280.CFDistinguishedName{Type: dnType, Value: value})
+		}
+	}
+	add(DnTypeOrganization, subject.Organizations)
+	add(DnTypeOrganizationalUnit, subject.OrganizationalUnits)
+	add(DnTypeCountry, subject.Countries)
+	add(DnTypeLocality, subject.Localities)
+	add(DnTypeProvince, subject.Provinces)
+	return elements
+}
+
+// SubjectFromAnnotations returns the DN elements set through annotations.
+func SubjectFromAnnotations(annotations map[string]string) []rfc5280.CFDistinguishedName {
+	var elements []rfc5280.CFDistinguishedName
+	for _, a := range subjectAnnotations {
+		if value, ok := annotations[a.annotation]; ok && value != "" {
+			elements = append(elements, rfc5280.CFDistinguishedName{Type: a.dnType, Value: value})
+		}
+	}
+	return elements
+}
+
+// MergeSubject returns the elements of base, where every DN type present in
+// overrides is replaced by the overriding elements.
+func MergeSubject(base []rfc5280.CFDistinguishedName, overrides []rfc5280.CFDistinguishedName) []rfc5280.CFDistinguishedName {
+	overridden := make(map[string]bool)
+	for _, element := range overrides {
+		overridden[element.Type] = true
+	}
+
+	var merged []rfc5280.CFDistinguishedName
+	for _, element := range base {
+		if !overridden[element.Type] {
+			merged = append(merged, element)
+		}
+	}
+	return append(merged, overrides...)
+}
+
+// ValidateSubject checks that DN elements are suitable for a certificate
+// subject, as far as their length and characters are concerned.
+func ValidateSubject(elements []rfc5280.CFDistinguishedName) error {
+	for _, element := range elements {
+		if element.Type == DnTypeCountry {
+			if !countryPattern.MatchString(element.Value) {
+				return fmt.Errorf("invalid subject country %q: must be a two-letter uppercase ISO 3166 code", element.Value)
+			}
+			continue
+		}
+
+		if element.Value == "" {
+			return fmt.Errorf("invalid subject %s: value must not be empty", element.Type)
+		}
+		if max, ok := subjectMaxLengths[element.Type]; ok && utf8.RuneCountInString(element.Value) > max {
+			return fmt.Errorf("invalid subject %s %q: must be at most %d characters long", element.Type, element.Value, max)
+		}
+		if !utf8.ValidString(element.Value) {
+			return fmt.Errorf("invalid subject %s %q: must be valid UTF-8", element.Type, element.Value)
+		}
+		for _, r := range element.Value {
+			if unicode.IsControl(r) {
+				return fmt.Errorf("invalid subject %s %q: must not contain control characters", element.Type, element.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// combineSubject combines the DN elements parsed from a CSR with additional
+// ones. Unless override is set, only the DN types missing from the CSR are
+// taken from extra.
+func combineSubject(csr []rfc5280.CFDistinguishedName, extra []rfc5280.CFDistinguishedName, override bool) []rfc5280.CFDistinguishedName {
+	if override {
+		return MergeSubject(csr, extra)
+	}
+
+	present := make(map[string]bool)
+	for _, element := range csr {
+		present[element.Type] = true
+	}
+	combined := append([]rfc5280.CFDistinguishedName{}, csr...)
+	for _, element := range extra {
+		if !present[element.Type] {
+			combined = append(combined, element)
+		}
+	}
+	return combined
+}
+
+// withCommonName returns the DN elements of a parsed CSR, with a common name
+// added when the CSR has none. The common name is the given one, or the first
+// DNS SAN when fromSan is set. It must be one of the SANs of the CSR.
+func withCommonName(csr rfc5280.CFCertificationRequest, commonName string, fromSan bool) ([]rfc5280.CFDistinguishedName, error) {
+	for _, element := range csr.DnElements {
+		if element.Type == DnTypeCommonName {
+			return csr.DnElements, nil
+		}
+	}
+
+	if commonName == "" && fromSan {
+		for _, san := range csr.Sans {
+			if san.SanType == sanTypeDnsName {
+				commonName = san.Value
+				break
+			}
+		}
+	}
+	if commonName == "" {
+		return csr.DnElements, nil
+	}
+
+	found := false
+	for _, san := range csr.Sans {
+		if san.Value == commonName {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("common name %q is not one of the SANs of the CSR", commonName)
+	}
+
+	return append([]rfc5280.CFDistinguishedName{{Type: DnTypeCommonName, Value: commonName}}, csr.DnElements...), nil
+}
@@ -0,0 +1,21 @@
+package horizon
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+
+	"github.com/evertrust/horizon-issuer/api/v1alpha1"
+)
+
+// Thumbprint returns the hexadecimal thumbprint of a certificate, hashing its
+// DER encoding with the given algorithm, or SHA-256 when unset.
+func Thumbprint(certificate *x509.Certificate, algorithm v1alpha1.ThumbprintAlgorithm) (v1alpha1.ThumbprintAlgorithm, string) {
+	if algorithm == v1alpha1.ThumbprintAlgorithmSHA1 {
+		sum := sha1.Sum(certificate.Raw)
+		return algorithm, hex.EncodeToString(sum[:])
+	}
+	sum := sha256.Sum256(certificate.Raw)
+	return v1alpha1.ThumbprintAlgorithmSHA256, hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,69 @@
+package horizon
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenRefreshMargin is how long before its expiry a service account token
+// is read again from its source. Kubernetes refreshes projected tokens once
+// 80% of their lifetime has elapsed, so the new one is usually there by then.
+const tokenRefreshMargin = 5 * time.Minute
+
+// bearerToken is the service account token a client authenticates with. It
+// is shared by the copies of the client.
+type bearerToken struct {
+	mu     sync.Mutex
+	value  string
+	expiry time.Time
+	// source, if set, is where the token is read again from when it is
+	// about to expire.
+	source CredentialSource
+}
+
+// get returns the token to send at now, reading it again from its source
+// when it expires within tokenRefreshMargin. Tokens with no readable expiry
+// are never read again, as the client is rebuilt when its credentials change.
+func (t *bearerToken) get(ctx context.Context, now time.Time) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.source == nil || t.expiry.IsZero() || now.Add(tokenRefreshMargin).Before(t.expiry) {
+		return t.value, nil
+	}
+	secretData, err := t.source.Credentials(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to refresh the service account token: %w", err)
+	}
+	value := strings.TrimSpace(string(secretData[TokenKey]))
+	if value == "" {
+		return "", &PermanentError{Err: fmt.Errorf("the %s key of the issuer credentials must hold the service account token", TokenKey)}
+	}
+	t.value, t.expiry = value, tokenExpiry(value)
+	return t.value, nil
+}
+
+// tokenExpiry returns the expiry of a JWT, read from its exp claim without
+// verifying it, or the zero time when the token has none.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
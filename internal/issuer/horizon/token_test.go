@@ -0,0 +1,100 @@
+package horizon
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+)
+
+// newTestToken returns an unsigned JWT expiring at expiry.
+func newTestToken(subject string, expiry time.Time) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." +
+		encode([]byte(fmt.Sprintf(`{"sub":%q,"exp":%d}`, subject, expiry.Unix()))) + "."
+}
+
+func TestTokenExpiry(t *testing.T) {
+	expiry := time.Unix(1700000000, 0)
+	tests := []struct {
+		name  string
+		token string
+		want  time.Time
+	}{
+		{name: "JWT", token: newTestToken("issuer", expiry), want: expiry},
+		{name: "JWT without expiry", token: "eyJhbGciOiJub25lIn0.eyJzdWIiOiJpc3N1ZXIifQ."},
+		{name: "opaque token", token: "secret"},
+		{name: "invalid payload", token: "a.!.c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenExpiry(tt.token); !got.Equal(tt.want) {
+				t.Errorf("tokenExpiry() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBearerTokenRefresh(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	first := newTestToken("first", now.Add(time.Hour))
+	refreshed := newTestToken("refreshed", now.Add(2*time.Hour))
+	writeFile(t, filepath.Join(dir, TokenKey), refreshed)
+
+	tests := []struct {
+		name  string
+		token *bearerToken
+		at    time.Time
+		want  string
+	}{
+		{name: "valid token", token: &bearerToken{value: first, expiry: tokenExpiry(first), source: &FileCredentials{Dir: dir}}, at: now, want: first},
+		{name: "token about to expire", token: &bearerToken{value: first, expiry: tokenExpiry(first), source: &FileCredentials{Dir: dir}}, at: now.Add(time.Hour - time.Minute), want: refreshed},
+		{name: "expired token", token: &bearerToken{value: first, expiry: tokenExpiry(first), source: &FileCredentials{Dir: dir}}, at: now.Add(2 * time.Hour), want: refreshed},
+		{name: "token without expiry", token: &bearerToken{value: "opaque", source: &FileCredentials{Dir: dir}}, at: now, want: "opaque"},
+		{name: "no source", token: &bearerToken{value: first, expiry: tokenExpiry(first)}, at: now.Add(2 * time.Hour), want: first},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.token.get(context.Background(), tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("get() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceAccountTokenHeaders(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		fmt.Fprint(w, `{"id":"6156f0a1e4b0c0a1b2c3d4e5","status":"pending"}`)
+	}))
+	t.Cleanup(server.Close)
+
+	token := newTestToken("issuer", time.Now().Add(time.Hour))
+	client, err := newClient(&horizonapi.IssuerSpec{URL: server.URL, AuthType: horizonapi.AuthTypeServiceAccountToken}, map[string][]byte{TokenKey: []byte(token + "\n")}, nil, TransportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetRequest(context.Background(), "6156f0a1e4b0c0a1b2c3d4e5"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := headers.Get("Authorization"), "Bearer "+token; got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+	for _, name := range []string{"x-api-id", "x-api-key"} {
+		if _, ok := headers[http.CanonicalHeaderKey(name)]; ok {
+			t.Errorf("%s header sent along with the token", name)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package horizon
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+)
+
+// Key usages, as understood by Horizon.
+const (
+	KeyUsageDigitalSignature = "digitalSignature"
+	KeyUsageNonRepudiation   = "nonRepudiation"
+	KeyUsageKeyEncipherment  = "keyEncipherment"
+	KeyUsageDataEncipherment = "dataEncipherment"
+	KeyUsageKeyAgreement     = "keyAgreement"
+	KeyUsageKeyCertSign      = "keyCertSign"
+	KeyUsageCRLSign          = "cRLSign"
+	KeyUsageEncipherOnly     = "encipherOnly"
+	KeyUsageDecipherOnly     = "decipherOnly"
+)
+
+// Extended key usages OIDs, which is how Horizon expects them.
+const (
+	ExtKeyUsageAny             = "2.5.29.37.0"
+	ExtKeyUsageServerAuth      = "1.3.6.1.5.5.7.3.1"
+	ExtKeyUsageClientAuth      = "1.3.6.1.5.5.7.3.2"
+	ExtKeyUsageCodeSigning     = "1.3.6.1.5.5.7.3.3"
+	ExtKeyUsageEmailProtection = "1.3.6.1.5.5.7.3.4"
+	ExtKeyUsageIPSECEndSystem  = "1.3.6.1.5.5.7.3.5"
+	ExtKeyUsageIPSECTunnel     = "1.3.6.1.5.5.7.3.6"
+	ExtKeyUsageIPSECUser       = "1.3.6.1.5.5.7.3.7"
+	ExtKeyUsageTimeStamping    = "1.3.6.1.5.5.7.3.8"
+	ExtKeyUsageOCSPSigning     = "1.3.6.1.5.5.7.3.9"
+	ExtKeyUsageMicrosoftSGC    = "1.3.6.1.4.1.311.10.3.3"
+	ExtKeyUsageNetscapeSGC     = "2.16.840.1.113730.4.1"
+)
+
+var keyUsages = map[cmapi.KeyUsage]string{
+	cmapi.UsageSigning:           KeyUsageDigitalSignature,
+	cmapi.UsageDigitalSignature:  KeyUsageDigitalSignature,
+	cmapi.UsageContentCommitment: KeyUsageNonRepudiation,
+	cmapi.UsageKeyEncipherment:   KeyUsageKeyEncipherment,
+	cmapi.UsageDataEncipherment:  KeyUsageDataEncipherment,
+	cmapi.UsageKeyAgreement:      KeyUsageKeyAgreement,
+	cmapi.UsageCertSign:          KeyUsageKeyCertSign,
+	cmapi.UsageCRLSign:           KeyUsageCRLSign,
+	cmapi.UsageEncipherOnly:      KeyUsageEncipherOnly,
+	cmapi.UsageDecipherOnly:      KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[cmapi.KeyUsage]string{
+	cmapi.UsageAny:             ExtKeyUsageAny,
+	cmapi.UsageServerAuth:      ExtKeyUsageServerAuth,
+	cmapi.UsageClientAuth:      ExtKeyUsageClientAuth,
+	cmapi.UsageCodeSigning:     ExtKeyUsageCodeSigning,
+	cmapi.UsageEmailProtection: ExtKeyUsageEmailProtection,
+	cmapi.UsageSMIME:           ExtKeyUsageEmailProtection,
+	cmapi.UsageIPsecEndSystem:  ExtKeyUsageIPSECEndSystem,
+	cmapi.UsageIPsecTunnel:     ExtKeyUsageIPSECTunnel,
+	cmapi.UsageIPsecUser:       ExtKeyUsageIPSECUser,
+	cmapi.UsageTimestamping:    ExtKeyUsageTimeStamping,
+	cmapi.UsageOCSPSigning:     ExtKeyUsageOCSPSigning,
+	cmapi.UsageMicrosoftSGC:    ExtKeyUsageMicrosoftSGC,
+	cmapi.UsageNetscapeSGC:     ExtKeyUsageNetscapeSGC,
+}
+
+// Bits of the key usage extension, in the order defined by RFC 5280
+var keyUsageBits = []string{
+	KeyUsageDigitalSignature,
+	KeyUsageNonRepudiation,
+	KeyUsageKeyEncipherment,
+	KeyUsageDataEncipherment,
+	KeyUsageKeyAgreement,
+	KeyUsageKeyCertSign,
+	KeyUsageCRLSign,
+	KeyUsageEncipherOnly,
+	KeyUsageDecipherOnly,
+}
+
+var (
+	oidExtensionKeyUsage         = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtensionExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+)
+
+// UsagesFromCertManager maps cert-manager usages to Horizon key usages and
+// extended key usages. Unknown usages are ignored.
+func UsagesFromCertManager(usages []cmapi.KeyUsage) (keyUsageList []string, extKeyUsageList []string) {
+	for _, usage := range usages {
+		if keyUsage, ok := keyUsages[usage]; ok {
+			keyUsageList = appendUnique(keyUsageList, keyUsage)
+		} else if extKeyUsage, ok := extKeyUsages[usage]; ok {
+			extKeyUsageList = appendUnique(extKeyUsageList, extKeyUsage)
+		}
+	}
+	return keyUsageList, extKeyUsageList
+}
+
+// UsagesFromCSR returns the key usages and extended key usages requested
+// in the extensions of a PEM-encoded CSR.
+func UsagesFromCSR(csrPem []byte) (keyUsageList []string, extKeyUsageList []string, err error) {
+	block, _ := pem.Decode(csrPem)
+	if block == nil {
+		return nil, nil, errors.New("failed to decode the CSR PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse the CSR: %v", err)
+	}
+
+	for _, extension := range csr.Extensions {
+		switch {
+		case extension.Id.Equal(oidExtensionKeyUsage):
+			var bits asn1.BitString
+			if _, err := asn1.Unmarshal(extension.Value, &bits); err != nil {
+				return nil, nil, fmt.Errorf("invalid key usage extension: %v", err)
+			}
+			for i, keyUsage := range keyUsageBits {
+				if bits.At(i) != 0 {
+					keyUsageList = append(keyUsageList, keyUsage)
+				}
+			}
+		case extension.Id.Equal(oidExtensionExtendedKeyUsage):
+			var oids []asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(extension.Value, &oids); err != nil {
+				return nil, nil, fmt.Errorf("invalid extended key usage extension: %v", err)
+			}
+			for _, oid := range oids {
+				extKeyUsageList = appendUnique(extKeyUsageList, oid.String())
+			}
+		}
+	}
+	return keyUsageList, extKeyUsageList, nil
+}
+
+var errUsagesNotAllowed = errors.New("usages not allowed by the issuer")
+
+// ValidateUsages checks that the usages of a CertificateRequest, or the
+// cert-manager default ones when it has none, are all allowed, and that it
+// only requests a CA certificate when allowed. Any usage is valid when none
+// are allowed, and CA certificates are allowed when allowCA is nil.
+func ValidateUsages(usages []cmapi.KeyUsage, isCA bool, allowedUsages []string, allowCA *bool) error {
+	if isCA && allowCA != nil && !*allowCA {
+		return fmt.Errorf("%w: CA certificates cannot be requested", errUsagesNotAllowed)
+	}
+	if len(allowedUsages) == 0 {
+		return nil
+	}
+	if len(usages) == 0 {
+		usages = cmapi.DefaultKeyUsages()
+	}
+
+	allowed := make(map[cmapi.KeyUsage]bool, len(allowedUsages))
+	for _, usage := range allowedUsages {
+		allowed[cmapi.KeyUsage(usage)] = true
+	}
+	var disallowed []string
+	for _, usage := range usages {
+		if !allowed[usage] {
+			disallowed = append(disallowed, string(usage))
+		}
+	}
+	if len(disallowed) > 0 {
+		return fmt.Errorf("%w: %s", errUsagesNotAllowed, strings.Join(disallowed, ", "))
+	}
+	return nil
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
@@ -0,0 +1,125 @@
+package horizon
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"reflect"
+	"testing"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+)
+
+func TestUsagesFromCertManager(t *testing.T) {
+	tests := []struct {
+		name             string
+		usages           []cmapi.KeyUsage
+		wantKeyUsages    []string
+		wantExtKeyUsages []string
+	}{
+		{name: "none"},
+		{
+			name:             "server auth",
+			usages:           []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageKeyEncipherment, cmapi.UsageServerAuth},
+			wantKeyUsages:    []string{KeyUsageDigitalSignature, KeyUsageKeyEncipherment},
+			wantExtKeyUsages: []string{ExtKeyUsageServerAuth},
+		},
+		{
+			name:             "client auth",
+			usages:           []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageClientAuth},
+			wantKeyUsages:    []string{KeyUsageDigitalSignature},
+			wantExtKeyUsages: []string{ExtKeyUsageClientAuth},
+		},
+		{
+			name:             "code signing",
+			usages:           []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageContentCommitment, cmapi.UsageCodeSigning},
+			wantKeyUsages:    []string{KeyUsageDigitalSignature, KeyUsageNonRepudiation},
+			wantExtKeyUsages: []string{ExtKeyUsageCodeSigning},
+		},
+		{
+			name:             "email protection by both names",
+			usages:           []cmapi.KeyUsage{cmapi.UsageEmailProtection, cmapi.UsageSMIME},
+			wantExtKeyUsages: []string{ExtKeyUsageEmailProtection},
+		},
+		{
+			name:          "signing and digital signature are the same usage",
+			usages:        []cmapi.KeyUsage{cmapi.UsageSigning, cmapi.UsageDigitalSignature},
+			wantKeyUsages: []string{KeyUsageDigitalSignature},
+		},
+		{
+			name:          "CA",
+			usages:        []cmapi.KeyUsage{cmapi.UsageCertSign, cmapi.UsageCRLSign},
+			wantKeyUsages: []string{KeyUsageKeyCertSign, KeyUsageCRLSign},
+		},
+		{
+			name:             "other extended key usages",
+			usages:           []cmapi.KeyUsage{cmapi.UsageTimestamping, cmapi.UsageOCSPSigning, cmapi.UsageIPsecTunnel, cmapi.UsageAny},
+			wantExtKeyUsages: []string{ExtKeyUsageTimeStamping, ExtKeyUsageOCSPSigning, ExtKeyUsageIPSECTunnel, ExtKeyUsageAny},
+		},
+		{
+			name:             "unknown usages are ignored",
+			usages:           []cmapi.KeyUsage{"unknown", cmapi.UsageServerAuth},
+			wantExtKeyUsages: []string{ExtKeyUsageServerAuth},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyUsages, extKeyUsages := UsagesFromCertManager(tt.usages)
+			if !reflect.DeepEqual(keyUsages, tt.wantKeyUsages) {
+				t.Errorf("key usages = %v, want %v", keyUsages, tt.wantKeyUsages)
+			}
+			if !reflect.DeepEqual(extKeyUsages, tt.wantExtKeyUsages) {
+				t.Errorf("extended key usages = %v, want %v", extKeyUsages, tt.wantExtKeyUsages)
+			}
+		})
+	}
+}
+
+func TestUsagesFromCSR(t *testing.T) {
+	// digitalSignature and keyEncipherment
+	keyUsage, err := asn1.Marshal(asn1.BitString{Bytes: []byte{0xa0}, BitLength: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extKeyUsage, err := asn1.Marshal([]asn1.ObjectIdentifier{{1, 3, 6, 1, 5, 5, 7, 3, 1}, {1, 3, 6, 1, 5, 5, 7, 3, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name             string
+		extensions       []pkix.Extension
+		wantKeyUsages    []string
+		wantExtKeyUsages []string
+	}{
+		{name: "no extension"},
+		{
+			name: "key usages and extended key usages",
+			extensions: []pkix.Extension{
+				{Id: oidExtensionKeyUsage, Critical: true, Value: keyUsage},
+				{Id: oidExtensionExtendedKeyUsage, Value: extKeyUsage},
+			},
+			wantKeyUsages:    []string{KeyUsageDigitalSignature, KeyUsageKeyEncipherment},
+			wantExtKeyUsages: []string{ExtKeyUsageServerAuth, ExtKeyUsageClientAuth},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csr := newTestCSR(t, &x509.CertificateRequest{ExtraExtensions: tt.extensions}, nil)
+			keyUsages, extKeyUsages, err := UsagesFromCSR(csr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(keyUsages, tt.wantKeyUsages) {
+				t.Errorf("key usages = %v, want %v", keyUsages, tt.wantKeyUsages)
+			}
+			if !reflect.DeepEqual(extKeyUsages, tt.wantExtKeyUsages) {
+				t.Errorf("extended key usages = %v, want %v", extKeyUsages, tt.wantExtKeyUsages)
+			}
+		})
+	}
+
+	if _, _, err := UsagesFromCSR([]byte("not a CSR")); err == nil {
+		t.Error("UsagesFromCSR() of an invalid CSR succeeded")
+	}
+}
@@ -1,30 +1,175 @@
 package horizon
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"github.com/evertrust/horizon-go"
 	horizonapi "github.com/evertrust/horizon-issuer/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
-func HorizonClientFromIssuer(issuerSpec *horizonapi.IssuerSpec, secretData map[string][]byte) (*horizon.Horizon, error) {
-	client := new(horizon.Horizon)
+// Keys of the issuer credentials holding the PEM-encoded client certificate
+// and private key used for mutual TLS.
+const (
+	ClientCertificateKey = "client.crt"
+	ClientKeyKey         = "client.key"
+)
+
+// HorizonCaBundleKey is the key of the issuer credentials holding the
+// PEM-encoded CA chain trusted for the Horizon endpoint, used when the issuer
+// spec has no CA bundle. Unlike the CA bundle of the spec, it replaces the
+// system trust store.
+const HorizonCaBundleKey = "horizonCaBundle"
+
+// TokenKey is the key of the issuer credentials holding the bearer token of
+// issuers authenticating with a service account token.
+const TokenKey = "token"
+
+// URLKey is the key of the issuer credentials holding the Horizon URL, used
+// when the issuer spec has none.
+const URLKey = "url"
+
+var errNoURL = errors.New("either url or the " + URLKey + " key of the issuer credentials must be set")
+
+// TransportOptions tunes the connection pool and TLS settings of Horizon
+// clients. Zero values keep the defaults of http.Transport and tls.Config.
+type TransportOptions struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	// MinTLSVersion and CipherSuites restrict the TLS connections to Horizon.
+	// Cipher suites don't apply to TLS 1.3.
+	MinTLSVersion uint16
+	CipherSuites  []uint16
+	// UserAgent is sent with every request, unless overridden by the
+	// additional headers of an issuer.
+	UserAgent string
+	// NetworkRetries is the number of times a call failing with a transient
+	// network error is retried before giving up.
+	NetworkRetries int
+}
+
+// HorizonClientFromIssuer builds a client for an issuer, authenticated with
+// the credentials read from the given source.
+func HorizonClientFromIssuer(ctx context.Context, issuerSpec *horizonapi.IssuerSpec, credentials CredentialSource, transport TransportOptions) (*Client, error) {
+	secretData, err := credentials.Credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(issuerSpec, secretData, credentials, transport)
+}
+
+// newClient builds a client from the credentials of an issuer. A service
+// account token is read again from credentials when it is about to expire.
+func newClient(issuerSpec *horizonapi.IssuerSpec, secretData map[string][]byte, credentials CredentialSource, transport TransportOptions) (*Client, error) {
+	client := new(Client)
 
-	baseUrl, err := url.Parse(issuerSpec.URL)
+	// The URL of the spec takes precedence over the one of the credentials
+	rawUrl := issuerSpec.URL
+	if rawUrl == "" {
+		rawUrl = strings.TrimSpace(string(secretData[URLKey]))
+	}
+	if rawUrl == "" {
+		return nil, &PermanentError{Err: errNoURL}
+	}
+	baseUrl, err := url.Parse(rawUrl)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %v", "Invalid base URL", err)
+		return nil, &PermanentError{Err: fmt.Errorf("%s: %v", "Invalid base URL", err)}
+	}
+	var username, password, token string
+	switch issuerSpec.AuthType {
+	case horizonapi.AuthTypeServiceAccountToken:
+		token = strings.TrimSpace(string(secretData[TokenKey]))
+		if token == "" {
+			return nil, &PermanentError{Err: fmt.Errorf("the %s key of the issuer credentials must hold the service account token", TokenKey)}
+		}
+	default:
+		username = string(secretData["username"])
+		password = string(secretData["password"])
 	}
-	username := string(secretData["username"])
-	password := string(secretData["password"])
 	client.Init(*baseUrl, username, password)
+	if token != "" {
+		// horizon-go would send empty API credentials along with the token
+		client.token = &bearerToken{value: token, expiry: tokenExpiry(token), source: credentials}
+		client.bearerHttp = &http.Client{Transport: &client.Http.Transport}
+	}
+	client.Http.Transport.MaxIdleConns = transport.MaxIdleConns
+	client.Http.Transport.MaxIdleConnsPerHost = transport.MaxIdleConnsPerHost
+	client.Http.Transport.IdleConnTimeout = transport.IdleConnTimeout
 
+	// The CA bundle of the spec takes precedence over the one of the credentials
 	if issuerSpec.CaBundle != nil {
 		client.Http.SetCaBundle(*issuerSpec.CaBundle)
+	} else if caBundle, ok := secretData[HorizonCaBundleKey]; ok {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return nil, &PermanentError{Err: fmt.Errorf("the %s key of the issuer credentials holds no PEM-encoded certificate", HorizonCaBundleKey)}
+		}
+		client.Http.Transport.TLSClientConfig.RootCAs = pool
 	}
 
 	if issuerSpec.SkipTLSVerify {
 		client.Http.SkipTLSVerify()
 	}
 
+	if issuerSpec.TLSServerName != "" {
+		if errs := validation.IsDNS1123Subdomain(strings.ToLower(issuerSpec.TLSServerName)); len(errs) > 0 {
+			return nil, &PermanentError{Err: fmt.Errorf("invalid TLS server name %s: %s", issuerSpec.TLSServerName, strings.Join(errs, ", "))}
+		}
+		client.Http.Transport.TLSClientConfig.ServerName = issuerSpec.TLSServerName
+	}
+
+	client.Http.Transport.TLSClientConfig.MinVersion = transport.MinTLSVersion
+	client.Http.Transport.TLSClientConfig.CipherSuites = transport.CipherSuites
+
+	// Present a client certificate when Horizon requires mutual TLS
+	clientCert, hasCert := secretData[ClientCertificateKey]
+	clientKey, hasKey := secretData[ClientKeyKey]
+	if hasCert != hasKey {
+		return nil, fmt.Errorf("both %s and %s must be set to use a client certificate", ClientCertificateKey, ClientKeyKey)
+	}
+	if hasCert {
+		keyPair, err := tls.X509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", "Invalid client certificate", err)
+		}
+		client.Http.Transport.TLSClientConfig.Certificates = []tls.Certificate{keyPair}
+	}
+
+	if issuerSpec.MaxConcurrentRequests > 0 {
+		client.inFlight = make(chan struct{}, issuerSpec.MaxConcurrentRequests)
+	}
+
+	client.networkRetries = transport.NetworkRetries
+	client.owners = &ownerCache{}
+
+	if key, ok := secretData[HMACKeyKey]; ok {
+		if len(key) == 0 {
+			return nil, &PermanentError{Err: fmt.Errorf("the %s key of the issuer credentials is empty", HMACKeyKey)}
+		}
+		client.hmacKey = key
+	}
+
+	client.Headers = make(http.Header)
+	if transport.UserAgent != "" {
+		client.Headers.Set("User-Agent", transport.UserAgent)
+	}
+	for name, value := range issuerSpec.AdditionalHeaders {
+		client.Headers.Set(name, value)
+	}
+	for name, key := range issuerSpec.AdditionalSecretHeaders {
+		value, ok := secretData[key]
+		if !ok {
+			return nil, fmt.Errorf("key %s of header %s not found in the issuer credentials", key, name)
+		}
+		client.Headers.Set(name, string(value))
+	}
+
 	return client, nil
 }
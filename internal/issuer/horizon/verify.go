@@ -0,0 +1,79 @@
+package horizon
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errCertificateMismatch = errors.New("Horizon returned a certificate that does not match the CSR")
+
+// VerifyCertificate checks that a certificate returned by Horizon is for the
+// public key of the CSR it was requested with, and holds at least the SANs
+// requested in that CSR. Horizon may add SANs, such as one for the common
+// name.
+func VerifyCertificate(certificate *x509.Certificate, csrPem []byte) error {
+	sameKey, err := reusesKey(csrPem, certificate)
+	if err != nil {
+		return err
+	}
+	if !sameKey {
+		return fmt.Errorf("%w: its public key differs from the one of the CSR", errCertificateMismatch)
+	}
+
+	block, _ := pem.Decode(csrPem)
+	if block == nil {
+		return errors.New("failed to decode the CSR PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse the CSR: %v", err)
+	}
+
+	var missing []string
+	for _, name := range csr.DNSNames {
+		if !containsFold(certificate.DNSNames, strings.TrimSuffix(name, ".")) {
+			missing = append(missing, "DNS:"+name)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		found := false
+		for _, issued := range certificate.IPAddresses {
+			found = found || issued.Equal(ip)
+		}
+		if !found {
+			missing = append(missing, "IP:"+ip.String())
+		}
+	}
+	for _, email := range csr.EmailAddresses {
+		if !containsFold(certificate.EmailAddresses, email) {
+			missing = append(missing, "email:"+email)
+		}
+	}
+	for _, uri := range csr.URIs {
+		found := false
+		for _, issued := range certificate.URIs {
+			found = found || issued.String() == uri.String()
+		}
+		if !found {
+			missing = append(missing, "URI:"+uri.String())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: it lacks the requested SANs %s", errCertificateMismatch, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// containsFold returns whether values holds value, ignoring case and
+// trailing dots.
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSuffix(v, "."), value) {
+			return true
+		}
+	}
+	return false
+}
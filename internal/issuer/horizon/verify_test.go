@@ -0,0 +1,132 @@
+package horizon
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/evertrust/horizon-issuer/api/v1alpha1"
+)
+
+func TestVerifyCertificate(t *testing.T) {
+	ca := newTestCA(t, "Test CA", nil)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, _ := url.Parse("spiffe://example.com/workload")
+	csrPem := newTestCSR(t, &x509.CertificateRequest{
+		DNSNames:       []string{"www.example.com", "api.example.com."},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		EmailAddresses: []string{"admin@example.com"},
+		URIs:           []*url.URL{uri},
+	}, key)
+	requested := func() *x509.Certificate {
+		return &x509.Certificate{
+			DNSNames:       []string{"www.example.com", "api.example.com"},
+			IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+			EmailAddresses: []string{"admin@example.com"},
+			URIs:           []*url.URL{uri},
+		}
+	}
+
+	tests := []struct {
+		name string
+		// template is changed from the one holding the requested SANs
+		template    func(*x509.Certificate)
+		key         *ecdsa.PrivateKey
+		wantMissing string
+		wantErr     bool
+	}{
+		{name: "requested certificate"},
+		{
+			name:     "additional SANs",
+			template: func(c *x509.Certificate) { c.DNSNames = append(c.DNSNames, "example.com") },
+		},
+		{
+			name:     "names differing in case and trailing dot",
+			template: func(c *x509.Certificate) { c.DNSNames = []string{"WWW.example.com.", "api.EXAMPLE.com"} },
+		},
+		{name: "other public key", key: otherKey, wantErr: true},
+		{
+			name:        "missing DNS name",
+			template:    func(c *x509.Certificate) { c.DNSNames = c.DNSNames[:1] },
+			wantMissing: "DNS:api.example.com.",
+		},
+		{
+			name:        "missing IP address",
+			template:    func(c *x509.Certificate) { c.IPAddresses = []net.IP{net.ParseIP("10.0.0.2")} },
+			wantMissing: "IP:10.0.0.1",
+		},
+		{
+			name:        "missing email address",
+			template:    func(c *x509.Certificate) { c.EmailAddresses = nil },
+			wantMissing: "email:admin@example.com",
+		},
+		{
+			name:        "missing URI",
+			template:    func(c *x509.Certificate) { c.URIs = nil },
+			wantMissing: "URI:spiffe://example.com/workload",
+		},
+		{
+			name:        "no SAN",
+			template:    func(c *x509.Certificate) { *c = x509.Certificate{} },
+			wantMissing: "DNS:www.example.com, DNS:api.example.com., IP:10.0.0.1, email:admin@example.com, URI:spiffe://example.com/workload",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := requested()
+			if tt.template != nil {
+				tt.template(template)
+			}
+			issuedKey := key
+			if tt.key != nil {
+				issuedKey = tt.key
+			}
+			certificate := ca.sign(t, template, issuedKey.Public(), nil)
+
+			err := VerifyCertificate(certificate, csrPem)
+			if tt.wantMissing == "" && !tt.wantErr {
+				if err != nil {
+					t.Fatalf("VerifyCertificate() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errCertificateMismatch) {
+				t.Fatalf("VerifyCertificate() error = %v, want %v", err, errCertificateMismatch)
+			}
+			if tt.wantMissing != "" && !strings.HasSuffix(err.Error(), "the requested SANs "+tt.wantMissing) {
+				t.Errorf("VerifyCertificate() error = %v, want the missing SANs %s", err, tt.wantMissing)
+			}
+		})
+	}
+
+	if err := VerifyCertificate(ca.sign(t, requested(), key.Public(), nil), []byte("not a CSR")); err == nil || errors.Is(err, errCertificateMismatch) {
+		t.Errorf("VerifyCertificate() error = %v for an invalid CSR, want a parsing error", err)
+	}
+}
+
+func TestHandleCompletedRequestVerifiesCertificate(t *testing.T) {
+	ca := newTestCA(t, "Test CA", nil)
+	csrPem := newTestCSR(t, &x509.CertificateRequest{DNSNames: []string{"www.example.com"}}, nil)
+	otherCsrPem := newTestCSR(t, &x509.CertificateRequest{DNSNames: []string{"www.example.com"}}, nil)
+
+	certificateRequest, err := completeRequest(t, &HorizonIssuer{}, v1alpha1.IssuerSpec{}, csrPem, encodePEM(ca.issue(t, otherCsrPem), ca.certificate))
+	if !errors.Is(err, errCertificateMismatch) || !IsPermanent(err) {
+		t.Fatalf("handleCompletedRequest() error = %v, want a permanent %v", err, errCertificateMismatch)
+	}
+	if len(certificateRequest.Status.Certificate) > 0 {
+		t.Error("the certificate issued for another key was installed")
+	}
+}
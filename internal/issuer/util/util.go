@@ -18,6 +18,7 @@ package util
 
 import (
 	"fmt"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -36,7 +37,9 @@ func GetSpecAndStatus(issuer client.Object) (*horizonapi.IssuerSpec, *horizonapi
 	}
 }
 
-func SetReadyCondition(status *horizonapi.IssuerStatus, conditionStatus horizonapi.ConditionStatus, reason, message string) {
+// SetReadyCondition sets the Ready condition of an issuer, observed at the
+// given generation of the issuer, now being the time of a transition.
+func SetReadyCondition(status *horizonapi.IssuerStatus, now time.Time, observedGeneration int64, conditionStatus horizonapi.ConditionStatus, reason, message string) {
 	ready := GetReadyCondition(status)
 	if ready == nil {
 		ready = &horizonapi.IssuerCondition{
@@ -46,11 +49,12 @@ func SetReadyCondition(status *horizonapi.IssuerStatus, conditionStatus horizona
 	}
 	if ready.Status != conditionStatus {
 		ready.Status = conditionStatus
-		now := metav1.Now()
-		ready.LastTransitionTime = &now
+		transitionTime := metav1.NewTime(now)
+		ready.LastTransitionTime = &transitionTime
 	}
 	ready.Reason = reason
 	ready.Message = message
+	ready.ObservedGeneration = observedGeneration
 
 	for i, c := range status.Conditions {
 		if c.Type == horizonapi.IssuerConditionReady {
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -25,8 +26,16 @@ import (
 	"github.com/evertrust/horizon-issuer/internal/version"
 	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
 	"io/ioutil"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -44,6 +53,9 @@ import (
 
 const inClusterNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
 
+// crdPollInterval is the delay between two checks of the CRDs at startup
+const crdPollInterval = 5 * time.Second
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -64,6 +76,27 @@ func main() {
 	var clusterResourceNamespace string
 	var probeAddr string
 	var printVersion bool
+	var unavailableRequeueAfter time.Duration
+	var notReadyRequeueAfter time.Duration
+	var requeueJitter int
+	var healthCheckTTL time.Duration
+	var tlsMinVersion string
+	var tlsCipherSuites string
+	var issuerFinalizer bool
+	var credentialsDir string
+	var enableIssuerControllers bool
+	var enableCertificateRequestController bool
+	var crdWaitTimeout time.Duration
+	var pollInterval time.Duration
+	var submittedPollInterval time.Duration
+	var adoptRequests bool
+	var auditLog string
+	var reloadableFlagsFile string
+	var enableDebugRequests bool
+	var enableDebugConfig bool
+	var verifySecretDelivery bool
+	var requestPriority string
+	var transportOptions horizon.TransportOptions
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.StringVar(&clusterResourceNamespace, "cluster-resource-namespace", "", "The namespace for secrets in which cluster-scoped resources are found.")
@@ -71,6 +104,56 @@ func main() {
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.BoolVar(&printVersion, "version", false, "Print version to stdout and exit")
+	flag.DurationVar(&unavailableRequeueAfter, "horizon-unavailable-requeue-after", 30*time.Second,
+		"The delay after which requests are retried when Horizon is temporarily unavailable and does not send a Retry-After header.")
+	flag.DurationVar(&notReadyRequeueAfter, "horizon-not-ready-requeue-after", 0,
+		"The delay after which requests are retried while their issuer is not ready, in addition to being retried as soon as it becomes ready. Zero retries them with the exponential backoff of the controller.")
+	flag.DurationVar(&submittedPollInterval, "horizon-submitted-poll-interval", 10*time.Second,
+		"The delay before the first poll of a request submitted to Horizon, to quickly issue requests approved automatically.")
+	flag.DurationVar(&pollInterval, "horizon-poll-interval", 15*time.Second,
+		"The delay between the next polls of a request pending on Horizon, while it waits for an approval.")
+	flag.IntVar(&requeueJitter, "horizon-requeue-jitter", 10,
+		"The percentage by which the delay between two polls of a pending request varies, to spread the load on Horizon.")
+	flag.DurationVar(&healthCheckTTL, "health-check-cache-ttl", 30*time.Second,
+		"How long the result of an issuer health check is reused by subsequent reconciles. Zero disables caching.")
+	flag.BoolVar(&adoptRequests, "horizon-adopt-requests", false,
+		"Search Horizon for a request carrying the correlation ID label of issuers setting correlationIdLabel before submitting a request whose request ID annotation was lost, and adopt it instead of submitting a duplicate. This costs a Horizon search per such submission.")
+	flag.StringVar(&auditLog, "audit-log", "",
+		"Where to write the audit log of the enrollments, renewals, revocations and cancellations performed on Horizon, as JSON lines: stdout, or the path of a file to append to. Disabled when empty.")
+	flag.StringVar(&reloadableFlagsFile, "reloadable-flags-file", "",
+		"A file holding flags that are applied at startup and reloaded when the controller receives SIGHUP, one per line. Only --zap-log-level, --horizon-submitted-poll-interval, --horizon-poll-interval, --horizon-requeue-jitter, --horizon-unavailable-requeue-after and --horizon-not-ready-requeue-after may be set. Disabled when empty.")
+	flag.BoolVar(&enableDebugRequests, "enable-debug-requests", false,
+		"Serve the managed CertificateRequests and the status of their Horizon requests on /debug/requests of the metrics endpoint.")
+	flag.BoolVar(&verifySecretDelivery, "verify-secret-delivery", false,
+		"Report issued certificates, through the Issued event and the horizon_issuer_issued_certificates_total metric, once cert-manager wrote them to the Secret of their Certificate rather than as soon as they are issued.")
+	flag.StringVar(&requestPriority, "certificaterequest-priority", string(controllers.PriorityNone),
+		"The class of certificate requests processed first when many are created or listed at once, such as at startup: renewals, issuances for first issuances, or none. The other ones are queued 10 seconds after they are first seen, so only the requests of the class processed within those 10 seconds go first. Updates are queued right away.")
+	flag.BoolVar(&enableDebugConfig, "enable-debug-config", false,
+		"Serve the flags of the controller and the effective settings of every issuer, header values redacted, on /debug/config of the metrics endpoint.")
+	flag.BoolVar(&issuerFinalizer, "issuer-finalizer", true,
+		"Add a finalizer to issuers so that resources held for them are released before they are deleted.")
+	flag.StringVar(&credentialsDir, "credentials-dir", "",
+		"The directory in which ClusterIssuers may read Horizon credentials from files through their authPath. File credentials are disabled when empty.")
+	flag.BoolVar(&enableIssuerControllers, "enable-issuer-controllers", true,
+		"Run the Issuer and ClusterIssuer controllers, which health check the Horizon issuers.")
+	flag.BoolVar(&enableCertificateRequestController, "enable-certificaterequest-controller", true,
+		"Run the CertificateRequest controller, which enrolls certificates on Horizon.")
+	flag.DurationVar(&crdWaitTimeout, "crd-wait-timeout", 2*time.Minute,
+		"How long to wait at startup for the Issuer, ClusterIssuer and CertificateRequest CRDs to be established. Zero disables waiting.")
+	flag.IntVar(&transportOptions.MaxIdleConns, "horizon-max-idle-conns", 100,
+		"The maximum number of idle connections to Horizon kept by each issuer client. Zero means no limit.")
+	flag.IntVar(&transportOptions.MaxIdleConnsPerHost, "horizon-max-idle-conns-per-host", 10,
+		"The maximum number of idle connections to each Horizon host kept by each issuer client.")
+	flag.DurationVar(&transportOptions.IdleConnTimeout, "horizon-idle-conn-timeout", 90*time.Second,
+		"How long idle connections to Horizon are kept open. Zero means no limit.")
+	flag.IntVar(&transportOptions.NetworkRetries, "horizon-network-retries", 1,
+		"The number of times a call to Horizon failing with a transient network error, such as a DNS failure, is retried within a reconcile.")
+	flag.StringVar(&transportOptions.UserAgent, "horizon-user-agent", "horizon-issuer/"+version.Version,
+		"The User-Agent header sent with requests to Horizon.")
+	flag.StringVar(&tlsMinVersion, "horizon-tls-min-version", "1.2",
+		"The minimum TLS version used to connect to Horizon, one of 1.0, 1.1, 1.2 or 1.3.")
+	flag.StringVar(&tlsCipherSuites, "horizon-tls-cipher-suites", "",
+		"A comma-separated list of the cipher suites allowed to connect to Horizon, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Go defaults are used when empty. Does not apply to TLS 1.3.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -82,10 +165,28 @@ func main() {
 		return
 	}
 
+	// The log level may be changed at runtime through the reloadable flags
+	level, ok := opts.Level.(uberzap.AtomicLevel)
+	if !ok {
+		level = uberzap.NewAtomicLevelAt(zapcore.InfoLevel)
+		if opts.Development {
+			level.SetLevel(zapcore.DebugLevel)
+		}
+		opts.Level = level
+	}
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	var err error
+	if transportOptions.MinTLSVersion, err = horizon.ParseTLSVersion(tlsMinVersion); err != nil {
+		setupLog.Error(err, "invalid --horizon-tls-min-version")
+		os.Exit(1)
+	}
+	if transportOptions.CipherSuites, err = horizon.ParseCipherSuites(tlsCipherSuites); err != nil {
+		setupLog.Error(err, "invalid --horizon-tls-cipher-suites")
+		os.Exit(1)
+	}
+
 	if clusterResourceNamespace == "" {
-		var err error
 		clusterResourceNamespace, err = getInClusterNamespace()
 		if err != nil {
 			if errors.Is(err, errNotInCluster) {
@@ -97,15 +198,43 @@ func main() {
 		}
 	}
 
+	if transportOptions.NetworkRetries < 0 {
+		setupLog.Error(fmt.Errorf("invalid value %d", transportOptions.NetworkRetries), "--horizon-network-retries must not be negative")
+		os.Exit(1)
+	}
+
+	priority, err := controllers.ParseRequestPriority(requestPriority)
+	if err != nil {
+		setupLog.Error(err, "invalid --certificaterequest-priority")
+		os.Exit(1)
+	}
+
+	if requeueJitter < 0 || requeueJitter > 100 {
+		setupLog.Error(fmt.Errorf("invalid value %d", requeueJitter), "--horizon-requeue-jitter must be between 0 and 100")
+		os.Exit(1)
+	}
+
 	setupLog.Info(
 		"starting",
 		"version", version.Version,
 		"enable-leader-election", enableLeaderElection,
 		"metrics-addr", metricsAddr,
 		"cluster-resource-namespace", clusterResourceNamespace,
+		"enable-issuer-controllers", enableIssuerControllers,
+		"enable-certificaterequest-controller", enableCertificateRequestController,
 	)
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	ctx := ctrl.SetupSignalHandler()
+	config := ctrl.GetConfigOrDie()
+
+	if crdWaitTimeout > 0 {
+		if err := waitForCRDs(ctx, config, crdWaitTimeout); err != nil {
+			setupLog.Error(err, "required CRDs are not available")
+			os.Exit(1)
+		}
+	}
+
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
 		Scheme:                     scheme,
 		MetricsBindAddress:         metricsAddr,
 		Port:                       9443,
@@ -119,36 +248,127 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.IssuerReconciler{
-		Kind:                     "Issuer",
-		Client:                   mgr.GetClient(),
-		Scheme:                   mgr.GetScheme(),
-		ClusterResourceNamespace: clusterResourceNamespace,
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Issuer")
-		os.Exit(1)
+	clients := horizon.NewClientCache(transportOptions)
+
+	if enableIssuerControllers {
+		issuerReconciler := &controllers.IssuerReconciler{
+			Kind:                     "Issuer",
+			Client:                   mgr.GetClient(),
+			Scheme:                   mgr.GetScheme(),
+			ClusterResourceNamespace: clusterResourceNamespace,
+			HealthCheckerBuilder:     horizon.HorizonHealthCheckerFromClient,
+			Clients:                  clients,
+			Finalizer:                issuerFinalizer,
+			CredentialsDir:           credentialsDir,
+			HealthCheckTTL:           healthCheckTTL,
+			Clock:                    clock.RealClock{},
+		}
+		if err = issuerReconciler.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Issuer")
+			os.Exit(1)
+		}
+
+		clusterIssuerReconciler := &controllers.IssuerReconciler{
+			Kind:                     "ClusterIssuer",
+			Client:                   mgr.GetClient(),
+			Scheme:                   mgr.GetScheme(),
+			ClusterResourceNamespace: clusterResourceNamespace,
+			HealthCheckerBuilder:     horizon.HorizonHealthCheckerFromClient,
+			Clients:                  clients,
+			Finalizer:                issuerFinalizer,
+			CredentialsDir:           credentialsDir,
+			HealthCheckTTL:           healthCheckTTL,
+			Clock:                    clock.RealClock{},
+		}
+		if err = clusterIssuerReconciler.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ClusterIssuer")
+			os.Exit(1)
+		}
+
+		if err := mgr.AddReadyzCheck("issuers", controllers.IssuersReady(issuerReconciler, clusterIssuerReconciler)); err != nil {
+			setupLog.Error(err, "unable to set up issuers ready check")
+			os.Exit(1)
+		}
 	}
 
-	if err = (&controllers.IssuerReconciler{
-		Kind:                     "ClusterIssuer",
-		Client:                   mgr.GetClient(),
-		Scheme:                   mgr.GetScheme(),
-		ClusterResourceNamespace: clusterResourceNamespace,
-		HealthCheckerBuilder:     horizon.HorizonHealthCheckerFromIssuer,
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ClusterIssuer")
-		os.Exit(1)
+	var audit *horizon.AuditLog
+	switch auditLog {
+	case "":
+	case "stdout":
+		audit = horizon.NewAuditLog(os.Stdout)
+	default:
+		auditFile, err := os.OpenFile(auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			setupLog.Error(err, "unable to open --audit-log")
+			os.Exit(1)
+		}
+		defer auditFile.Close()
+		audit = horizon.NewAuditLog(auditFile)
 	}
 
-	if err = (&controllers.CertificateRequestReconciler{
-		Client:                   mgr.GetClient(),
-		Scheme:                   mgr.GetScheme(),
-		ClusterResourceNamespace: clusterResourceNamespace,
-		Clock:                    clock.RealClock{},
-		Issuer:                   horizon.HorizonIssuer{},
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "CertificateRequest")
-		os.Exit(1)
+	flagsReloader := &reloader{
+		path:     reloadableFlagsFile,
+		logLevel: level.Level(),
+		level:    level,
+		requeue: horizon.RequeueSettings{
+			UnavailableRequeueAfter: unavailableRequeueAfter,
+			SubmittedPollInterval:   submittedPollInterval,
+			PollInterval:            pollInterval,
+			Jitter:                  requeueJitter,
+			NotReadyRequeueAfter:    notReadyRequeueAfter,
+		},
+	}
+
+	if enableCertificateRequestController {
+		certificateRequestReconciler := &controllers.CertificateRequestReconciler{
+			Client:                   mgr.GetClient(),
+			Scheme:                   mgr.GetScheme(),
+			ClusterResourceNamespace: clusterResourceNamespace,
+			Clock:                    clock.RealClock{},
+			Clients:                  clients,
+			CredentialsDir:           credentialsDir,
+			APIReader:                mgr.GetAPIReader(),
+			VerifyDelivery:           verifySecretDelivery,
+			Priority:                 priority,
+			Issuer: horizon.HorizonIssuer{
+				Requeue:       flagsReloader.requeue,
+				AdoptRequests: adoptRequests,
+				Audit:         audit,
+				Recorder:      mgr.GetEventRecorderFor("horizon-issuer"),
+			},
+		}
+		certificateRequestReconciler.Issuer.Clock = certificateRequestReconciler.Clock
+		if err = certificateRequestReconciler.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "CertificateRequest")
+			os.Exit(1)
+		}
+		flagsReloader.issuer = &certificateRequestReconciler.Issuer
+
+		if enableDebugRequests {
+			if err := mgr.AddMetricsExtraHandler("/debug/requests", certificateRequestReconciler.DebugRequestsHandler()); err != nil {
+				setupLog.Error(err, "unable to set up debug requests endpoint")
+				os.Exit(1)
+			}
+		}
+	}
+
+	if reloadableFlagsFile != "" {
+		if err := flagsReloader.reload(); err != nil {
+			setupLog.Error(err, "unable to load --reloadable-flags-file")
+			os.Exit(1)
+		}
+		go flagsReloader.watch(ctx)
+	}
+
+	if enableDebugConfig {
+		flags := make(map[string]string)
+		flag.VisitAll(func(f *flag.Flag) {
+			flags[f.Name] = f.Value.String()
+		})
+		if err := mgr.AddMetricsExtraHandler("/debug/config", controllers.DebugConfigHandler(mgr.GetClient(), flags)); err != nil {
+			setupLog.Error(err, "unable to set up debug config endpoint")
+			os.Exit(1)
+		}
 	}
 
 	//+kubebuilder:scaffold:builder
@@ -163,12 +383,65 @@ func main() {
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
 
+// requiredKinds are the kinds watched by the controllers, by group version
+var requiredKinds = map[schema.GroupVersion][]string{
+	horizonapi.GroupVersion:  {"Issuer", "ClusterIssuer"},
+	cmapi.SchemeGroupVersion: {"CertificateRequest"},
+}
+
+// waitForCRDs waits until the API server serves the kinds watched by the
+// controllers, since the CRDs may be applied at the same time as the
+// controller on fresh installs.
+func waitForCRDs(ctx context.Context, config *rest.Config, timeout time.Duration) error {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var missing []string
+	err = wait.PollImmediateUntil(crdPollInterval, func() (bool, error) {
+		missing = missingKinds(discoveryClient)
+		if len(missing) > 0 {
+			setupLog.Info("waiting for CRDs to be established", "missing", missing)
+			return false, nil
+		}
+		return true, nil
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("CRDs not established after %s: %v", timeout, missing)
+	}
+	return nil
+}
+
+// missingKinds returns the required kinds that the API server does not serve
+func missingKinds(discoveryClient discovery.DiscoveryInterface) []string {
+	var missing []string
+	for groupVersion, kinds := range requiredKinds {
+		served := make(map[string]bool)
+		// A group version that is not served yet returns a not found error
+		if resources, err := discoveryClient.ServerResourcesForGroupVersion(groupVersion.String()); err == nil {
+			for _, resource := range resources.APIResources {
+				served[resource.Kind] = true
+			}
+		}
+		for _, kind := range kinds {
+			if !served[kind] {
+				missing = append(missing, groupVersion.WithKind(kind).String())
+			}
+		}
+	}
+	return missing
+}
+
 var errNotInCluster = errors.New("not running in-cluster")
 
 // Copied from controller-runtime/pkg/leaderelection
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/evertrust/horizon-issuer/internal/issuer/horizon"
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// reloader applies the flags that are safe to change at runtime, read from
+// a file holding one flag per line, such as --horizon-requeue-jitter=20.
+// Flags missing from the file are reset to their command line value.
+type reloader struct {
+	path string
+	// logLevel and issuer are reconfigured, issuer being nil when the
+	// CertificateRequest controller is disabled
+	logLevel zapcore.Level
+	level    uberzap.AtomicLevel
+	requeue  horizon.RequeueSettings
+	issuer   *horizon.HorizonIssuer
+}
+
+// reload reads the file and applies its flags. Nothing is applied when the
+// file is invalid.
+func (r *reloader) reload() error {
+	content, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	requeue := r.requeue
+	flags := flag.NewFlagSet(r.path, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	logLevel := flags.String("zap-log-level", "", "")
+	flags.DurationVar(&requeue.UnavailableRequeueAfter, "horizon-unavailable-requeue-after", requeue.UnavailableRequeueAfter, "")
+	flags.DurationVar(&requeue.SubmittedPollInterval, "horizon-submitted-poll-interval", requeue.SubmittedPollInterval, "")
+	flags.DurationVar(&requeue.PollInterval, "horizon-poll-interval", requeue.PollInterval, "")
+	flags.IntVar(&requeue.Jitter, "horizon-requeue-jitter", requeue.Jitter, "")
+	flags.DurationVar(&requeue.NotReadyRequeueAfter, "horizon-not-ready-requeue-after", requeue.NotReadyRequeueAfter, "")
+
+	var args []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			args = append(args, line)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	if requeue.Jitter < 0 || requeue.Jitter > 100 {
+		return fmt.Errorf("--horizon-requeue-jitter must be between 0 and 100, got %d", requeue.Jitter)
+	}
+
+	level := r.logLevel
+	if *logLevel != "" {
+		if level, err = parseLogLevel(*logLevel); err != nil {
+			return err
+		}
+	}
+
+	r.level.SetLevel(level)
+	if r.issuer != nil {
+		r.issuer.Reconfigure(requeue)
+	}
+	return nil
+}
+
+// watch reloads the file whenever the controller receives SIGHUP, until
+// the context is done.
+func (r *reloader) watch(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := r.reload(); err != nil {
+				setupLog.Error(err, "unable to reload flags, keeping the current ones", "path", r.path)
+			} else {
+				setupLog.Info("reloaded flags", "path", r.path)
+			}
+		}
+	}
+}
+
+// parseLogLevel parses a log level like --zap-log-level does: debug, info,
+// error, or an integer greater than 0 for custom debug levels.
+func parseLogLevel(value string) (zapcore.Level, error) {
+	switch strings.ToLower(value) {
+	case "debug":
+		return zapcore.DebugLevel, nil
+	case "info":
+		return zapcore.InfoLevel, nil
+	case "error":
+		return zapcore.ErrorLevel, nil
+	}
+	level, err := strconv.Atoi(value)
+	if err != nil || level <= 0 {
+		return 0, fmt.Errorf("invalid log level %q", value)
+	}
+	return zapcore.Level(int8(-level)), nil
+}